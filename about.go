@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+var startedAt = time.Now()
+
+var (
+	ffmpegVersionOnce  sync.Once
+	ffmpegVersionValue string
+)
+
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+func buildInfo() BuildInfo {
+	info := BuildInfo{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = s.Value
+			}
+		}
+	}
+	return info
+}
+
+func ffmpegVersion() string {
+	ffmpegVersionOnce.Do(func() {
+		output, err := command("ffmpeg", "-version").Output()
+		if err != nil {
+			return
+		}
+		line, _, _ := strings.Cut(string(output), "\n")
+		if fields := strings.Fields(line); len(fields) >= 3 && fields[1] == "version" {
+			ffmpegVersionValue = fields[2]
+		}
+	})
+	return ffmpegVersionValue
+}
+
+func enabledFeatures() map[string]bool {
+	return map[string]bool{
+		"tenants":          len(tenants) > 0,
+		"email":            cfg.SMTPAddr != "" && cfg.SMTPFrom != "",
+		"webdav":           cfg.WebDAVEnabled,
+		"cast":             cfg.CastEnabled,
+		"ssdp":             cfg.CastEnabled && cfg.SSDPEnabled,
+		"torrents":         cfg.TorrentEnabled,
+		"metrics":          cfg.MetricsEnabled,
+		"public_stats":     cfg.StatsPublic,
+		"error_reporting":  reporter != nil,
+		"proxies":          len(proxies) > 0,
+		"po_token":         cfg.YouTubePOToken != "" || cfg.POTProviderURL != "",
+		"fair_scheduling":  cfg.MaxRunningPerOwner > 0,
+		"small_job_lane":   cfg.PrioritizeSmallJobs,
+		"job_archival":     cfg.JobArchiveAfter > 0,
+		"abuse_protection": cfg.AbuseProtection,
+		"geoip":            access.geoip != nil,
+		"security_headers": cfg.SecurityHeaders,
+		"events":           len(cfg.EventSinks) > 0,
+		"ytdlp_workers":    cfg.YTDLPWorkers > 0,
+	}
+}
+
+func handleAbout(w http.ResponseWriter, r *http.Request) {
+	uptime := time.Since(startedAt).Truncate(time.Second)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"build":          buildInfo(),
+		"ytdlp_version":  ytdlpVersion(),
+		"ffmpeg_version": ffmpegVersion(),
+		"started_at":     startedAt.UTC().Truncate(time.Second),
+		"uptime":         uptime.String(),
+		"uptime_seconds": int64(uptime.Seconds()),
+		"job_store":      cfg.JobStore,
+		"queue_backend":  cfg.QueueBackend,
+		"url_validator":  cfg.URLValidator,
+		"workers":        cfg.Workers,
+		"features":       enabledFeatures(),
+		"flags":          flagStates(),
+	})
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	banReasonFailures = "excessive_failures"
+	banReasonScanning = "domain_scanning"
+	banReasonRotation = "ip_rotation"
+
+	banReviewKey = "bans:review"
+)
+
+var errBanNotFound = errors.New("ban not found")
+
+var bansIssued = metrics.Counter("odl_bans_total", "Temporary bans issued by abuse detection.", "reason")
+
+type Ban struct {
+	ID        string     `json:"id"`
+	Subject   string     `json:"subject"`
+	Reason    string     `json:"reason"`
+	Detail    string     `json:"detail,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	Reviewed  *time.Time `json:"reviewed_at,omitempty"`
+}
+
+func (b *Ban) status() string {
+	if b.Reviewed != nil {
+		return "reviewed"
+	}
+	return "pending"
+}
+
+func banKey(id string) string {
+	return fmt.Sprintf("ban:%s", id)
+}
+
+func banSubjectKey(subject string) string {
+	return fmt.Sprintf("ban_subject:%s", subject)
+}
+
+var trustedProxies []*net.IPNet
+
+func loadTrustedProxies() error {
+	for _, entry := range cfg.TrustedProxies {
+		network, err := parseCIDR(entry)
+		if err != nil {
+			return err
+		}
+		trustedProxies = append(trustedProxies, network)
+	}
+	return nil
+}
+
+func trustedProxy(ip net.IP) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !cfg.TrustProxyHeaders {
+		return host
+	}
+	if len(trustedProxies) > 0 {
+		if ip := net.ParseIP(host); ip == nil || !trustedProxy(ip) {
+			return host
+		}
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	client := host
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
+		}
+		client = ip.String()
+		if !trustedProxy(ip) {
+			break
+		}
+	}
+	return client
+}
+
+func clientSubnet(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String()
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+}
+
+func activeBan(subjects ...string) (*Ban, bool) {
+	pipe := rdb.Pipeline()
+	gets := make([]*redis.StringCmd, 0, len(subjects))
+	for _, subject := range subjects {
+		if subject != "" {
+			gets = append(gets, pipe.Get(ctx, banSubjectKey(subject)))
+		}
+	}
+	pipe.Exec(ctx)
+	for _, get := range gets {
+		id, err := get.Result()
+		if err != nil {
+			continue
+		}
+		if ban, err := getBan(id); err == nil {
+			return ban, true
+		}
+	}
+	return nil, false
+}
+
+func issueBan(subject, reason, detail string) {
+	if _, banned := activeBan(subject); banned {
+		return
+	}
+	now := time.Now().UTC()
+	ban := &Ban{
+		ID:        utils.RandomID(8),
+		Subject:   subject,
+		Reason:    reason,
+		Detail:    detail,
+		CreatedAt: now,
+		ExpiresAt: now.Add(cfg.AbuseBanDuration),
+	}
+	ok, err := rdb.SetNX(ctx, banSubjectKey(subject), ban.ID, cfg.AbuseBanDuration).Result()
+	if err != nil || !ok {
+		return
+	}
+	if err := saveBan(ban); err != nil {
+		log.Printf("abuse: saving ban for %s: %v", subject, err)
+		return
+	}
+	rdb.ZAdd(ctx, banReviewKey, redis.Z{Score: float64(now.Unix()), Member: ban.ID})
+	bansIssued.Inc(reason)
+	log.Printf("abuse: banned %s for %s (%s)", subject, cfg.AbuseBanDuration, reason)
+}
+
+func saveBan(ban *Ban) error {
+	data, err := json.Marshal(ban)
+	if err != nil {
+		return err
+	}
+	ttl := time.Until(ban.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return rdb.Set(ctx, banKey(ban.ID), data, ttl).Err()
+}
+
+func getBan(id string) (*Ban, error) {
+	data, err := rdb.Get(ctx, banKey(id)).Result()
+	if err == redis.Nil {
+		return nil, errBanNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ban Ban
+	if err := json.Unmarshal([]byte(data), &ban); err != nil {
+		return nil, err
+	}
+	return &ban, nil
+}
+
+func listBans(pendingOnly bool) ([]*Ban, error) {
+	ids, err := rdb.ZRange(ctx, banReviewKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	bans := []*Ban{}
+	for _, id := range ids {
+		ban, err := getBan(id)
+		if errors.Is(err, errBanNotFound) {
+			rdb.ZRem(ctx, banReviewKey, id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if pendingOnly && ban.Reviewed != nil {
+			continue
+		}
+		bans = append(bans, ban)
+	}
+	return bans, nil
+}
+
+func reviewBan(id string) (*Ban, error) {
+	ban, err := getBan(id)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	ban.Reviewed = &now
+	return ban, saveBan(ban)
+}
+
+func liftBan(id string) error {
+	ban, err := getBan(id)
+	if err != nil {
+		return err
+	}
+	keys := []string{banKey(id), banSubjectKey(ban.Subject)}
+	if ip, ok := strings.CutPrefix(ban.Subject, "ip:"); ok {
+		keys = append(keys, "abuse:failures:"+ip, "abuse:domains:"+ip)
+	}
+	if subnet, ok := strings.CutPrefix(ban.Subject, "subnet:"); ok {
+		keys = append(keys, "abuse:subnet:"+subnet)
+	}
+	rdb.Del(ctx, keys...)
+	rdb.ZRem(ctx, banReviewKey, id)
+	return nil
+}
+
+func countInWindow(key string) int64 {
+	n, err := rdb.Incr(ctx, key).Result()
+	if err != nil {
+		return 0
+	}
+	if n == 1 {
+		rdb.Expire(ctx, key, cfg.AbuseWindow)
+	}
+	return n
+}
+
+func addInWindow(key, member string) int64 {
+	pipe := rdb.Pipeline()
+	pipe.SAdd(ctx, key, member)
+	card := pipe.SCard(ctx, key)
+	pipe.Exec(ctx)
+	n, _ := card.Result()
+	if n == 1 {
+		rdb.Expire(ctx, key, cfg.AbuseWindow)
+	}
+	return n
+}
+
+func noteURLSubmission(r *http.Request, videoURL string) {
+	if !cfg.AbuseProtection {
+		return
+	}
+	ip := clientIP(r)
+	if parsed, err := url.Parse(videoURL); err == nil && parsed.Host != "" && cfg.AbuseMaxDomains > 0 {
+		if n := addInWindow("abuse:domains:"+ip, strings.ToLower(parsed.Hostname())); n > cfg.AbuseMaxDomains {
+			issueBan("ip:"+ip, banReasonScanning, fmt.Sprintf("%d domains in %s", n, cfg.AbuseWindow))
+		}
+	}
+	if subnet := clientSubnet(ip); subnet != "" && cfg.AbuseMaxSubnetIPs > 0 {
+		if n := addInWindow("abuse:subnet:"+subnet, ip); n > cfg.AbuseMaxSubnetIPs {
+			issueBan("subnet:"+subnet, banReasonRotation, fmt.Sprintf("%d addresses in %s", n, cfg.AbuseWindow))
+		}
+	}
+}
+
+func isAbuseFailure(status int) bool {
+	switch status {
+	case http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusGone, http.StatusUnprocessableEntity:
+		return true
+	}
+	return false
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *statusRecorder) ReadFrom(src io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(src)
+	}
+	return copyBuffered(w.ResponseWriter, src)
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func withAbuseProtection(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.AbuseProtection || strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ip := clientIP(r)
+		if ban, banned := activeBan("ip:"+ip, "subnet:"+clientSubnet(ip)); banned {
+			w.Header().Set("Retry-After", fmt.Sprint(int(time.Until(ban.ExpiresAt).Seconds())+1))
+			writeError(w, r, http.StatusForbidden, codeOriginBlocked, "Too many suspicious requests, try again later")
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if isAbuseFailure(rec.status) && cfg.AbuseMaxFailures > 0 {
+			if n := countInWindow("abuse:failures:" + ip); n > cfg.AbuseMaxFailures {
+				issueBan("ip:"+ip, banReasonFailures, fmt.Sprintf("%d failed requests in %s", n, cfg.AbuseWindow))
+			}
+		}
+	})
+}
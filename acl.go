@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	aclKey          = "acl"
+	aclRefreshEvery = 10 * time.Second
+)
+
+type AccessList struct {
+	Allow            []string `json:"allow"`
+	Deny             []string `json:"deny"`
+	BlockedCountries []string `json:"blocked_countries"`
+}
+
+type compiledACL struct {
+	allow     []*net.IPNet
+	deny      []*net.IPNet
+	countries map[string]bool
+}
+
+func parseCIDR(entry string) (*net.IPNet, error) {
+	entry = strings.TrimSpace(entry)
+	if !strings.Contains(entry, "/") {
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %q", entry)
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(entry)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR %q", entry)
+	}
+	return network, nil
+}
+
+func compileACL(list AccessList) (*compiledACL, error) {
+	acl := &compiledACL{countries: make(map[string]bool)}
+	for _, entry := range list.Allow {
+		network, err := parseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		acl.allow = append(acl.allow, network)
+	}
+	for _, entry := range list.Deny {
+		network, err := parseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		acl.deny = append(acl.deny, network)
+	}
+	for _, code := range list.BlockedCountries {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if len(code) != 2 {
+			return nil, fmt.Errorf("invalid country code %q", code)
+		}
+		acl.countries[code] = true
+	}
+	return acl, nil
+}
+
+func matchesAny(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+type accessControl struct {
+	mu       sync.RWMutex
+	acl      *compiledACL
+	loadedAt time.Time
+	geoip    *maxminddb.Reader
+}
+
+var access = &accessControl{}
+
+func openGeoIP(path string) (*maxminddb.Reader, error) {
+	if path == "" {
+		return nil, nil
+	}
+	return maxminddb.Open(path)
+}
+
+func defaultAccessList() AccessList {
+	return AccessList{Allow: cfg.ACLAllow, Deny: cfg.ACLDeny, BlockedCountries: cfg.ACLBlockedCountries}
+}
+
+func loadAccessList() (AccessList, error) {
+	data, err := rdb.Get(ctx, aclKey).Result()
+	if err == redis.Nil {
+		return defaultAccessList(), nil
+	}
+	if err != nil {
+		return AccessList{}, err
+	}
+	var list AccessList
+	if err := json.Unmarshal([]byte(data), &list); err != nil {
+		return AccessList{}, err
+	}
+	return list, nil
+}
+
+func saveAccessList(list AccessList) error {
+	acl, err := compileACL(list)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	if err := rdb.Set(ctx, aclKey, data, 0).Err(); err != nil {
+		return err
+	}
+	access.mu.Lock()
+	access.acl, access.loadedAt = acl, time.Now()
+	access.mu.Unlock()
+	return nil
+}
+
+func (a *accessControl) current() *compiledACL {
+	a.mu.RLock()
+	acl, fresh := a.acl, time.Since(a.loadedAt) < aclRefreshEvery
+	a.mu.RUnlock()
+	if acl != nil && fresh {
+		return acl
+	}
+
+	list, err := loadAccessList()
+	if err == nil {
+		var compiled *compiledACL
+		if compiled, err = compileACL(list); err == nil {
+			acl = compiled
+		}
+	}
+	if err != nil {
+		log.Printf("acl: %v", err)
+		if acl == nil {
+			acl = &compiledACL{}
+		}
+	}
+	a.mu.Lock()
+	a.acl, a.loadedAt = acl, time.Now()
+	a.mu.Unlock()
+	return acl
+}
+
+func (a *accessControl) invalidate() {
+	a.mu.Lock()
+	a.acl = nil
+	a.mu.Unlock()
+}
+
+func (a *accessControl) country(ip net.IP) string {
+	if a.geoip == nil {
+		return ""
+	}
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := a.geoip.Lookup(ip, &record); err != nil {
+		return ""
+	}
+	return record.Country.ISOCode
+}
+
+func (a *accessControl) allows(ip net.IP) bool {
+	acl := a.current()
+	if matchesAny(acl.deny, ip) {
+		return false
+	}
+	if matchesAny(acl.allow, ip) {
+		return true
+	}
+	if len(acl.allow) > 0 {
+		return false
+	}
+	if len(acl.countries) > 0 && acl.countries[a.country(ip)] {
+		return false
+	}
+	return true
+}
+
+func withAccessControl(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ip := net.ParseIP(clientIP(r)); ip != nil && !access.allows(ip) {
+			writeError(w, r, http.StatusForbidden, codeOriginBlocked, "Access denied")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
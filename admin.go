@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func isAdminToken(token string) bool {
+	return cfg.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminToken)) == 1
+}
+
+func adminRequest(r *http.Request) bool {
+	return isAdminToken(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+}
+
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminRequest(r) {
+			writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func requireFeedToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		feed := cfg.FeedToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.FeedToken)) == 1
+		if !feed && !adminRequest(r) {
+			writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func handleListRecycled(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseListQuery(w, r)
+	if !ok {
+		return
+	}
+	artifacts, err := store.RecycledArtifacts()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error listing recycled artifacts")
+		return
+	}
+	artifacts, next := paginate(artifacts, q, func(artifact *Artifact) (time.Time, string, string) {
+		deletedAt := artifact.CreatedAt
+		if artifact.DeletedAt != nil {
+			deletedAt = *artifact.DeletedAt
+		}
+		return deletedAt, artifact.ID, artifact.DeleteReason
+	})
+	writePage(w, "artifacts", artifacts, next)
+}
+
+func handleRestoreArtifact(w http.ResponseWriter, r *http.Request) {
+	artifact, err := getArtifact(r.PathValue("id"))
+	if errors.Is(err, errArtifactNotFound) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Artifact not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading artifact")
+		return
+	}
+	if artifact.DeletedAt == nil {
+		writeError(w, r, http.StatusConflict, codeConflict, "Artifact is not deleted")
+		return
+	}
+
+	if err := store.Restore(artifact); err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error restoring artifact")
+		return
+	}
+	writeJSON(w, http.StatusOK, artifact)
+}
+
+func handleCreateUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name   string `json:"name"`
+		Tenant string `json:"tenant"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Name == "" {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid JSON body")
+		return
+	}
+
+	user, apiKey, err := createUser(req.Name, tenantByID(req.Tenant))
+	if errors.Is(err, errUserExists) {
+		writeError(w, r, http.StatusConflict, codeConflict, "User already exists")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error creating user")
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{"user": user, "api_key": apiKey})
+}
+
+func handleListUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := listUsers()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error listing users")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"users": users})
+}
+
+func handleListLocks(w http.ResponseWriter, r *http.Request) {
+	locks, err := listLocks()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error listing locks")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"locks": locks})
+}
+
+func handleBreakLock(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	broken, err := breakLock(name)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error breaking lock")
+		return
+	}
+	if !broken {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Lock not found")
+		return
+	}
+	if id, ok := strings.CutPrefix(name, "subscription:"); ok {
+		rdb.ZAdd(ctx, subscriptionsDueKey, redis.Z{Score: float64(time.Now().Unix()), Member: id})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleListBans(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseListQuery(w, r)
+	if !ok {
+		return
+	}
+	bans, err := listBans(r.URL.Query().Get("pending") == "true")
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error listing bans")
+		return
+	}
+	bans, next := paginate(bans, q, func(ban *Ban) (time.Time, string, string) {
+		return ban.CreatedAt, ban.ID, ban.status()
+	})
+	writePage(w, "bans", bans, next)
+}
+
+func handleReviewBan(w http.ResponseWriter, r *http.Request) {
+	ban, err := reviewBan(r.PathValue("id"))
+	if errors.Is(err, errBanNotFound) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Ban not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error reviewing ban")
+		return
+	}
+	writeJSON(w, http.StatusOK, ban)
+}
+
+func handleLiftBan(w http.ResponseWriter, r *http.Request) {
+	err := liftBan(r.PathValue("id"))
+	if errors.Is(err, errBanNotFound) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Ban not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error lifting ban")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleGetACL(w http.ResponseWriter, r *http.Request) {
+	list, err := loadAccessList()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading access list")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"acl":   list,
+		"geoip": access.geoip != nil,
+	})
+}
+
+func handleUpdateACL(w http.ResponseWriter, r *http.Request) {
+	var list AccessList
+	if !decodeJSON(w, r, &list) {
+		return
+	}
+	if len(list.BlockedCountries) > 0 && access.geoip == nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Country blocking requires GEOIP_DB")
+		return
+	}
+	if _, err := compileACL(list); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	if err := saveAccessList(list); err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error saving access list")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"acl": list})
+}
+
+func handleResetACL(w http.ResponseWriter, r *http.Request) {
+	if err := rdb.Del(ctx, aclKey).Err(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error resetting access list")
+		return
+	}
+	access.invalidate()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+)
+
+const maxFormatsURLs = 5
+
+var metadataNotModified = metrics.Counter("odl_metadata_not_modified_total", "Metadata API requests answered with 304 because the client's ETag still matched.")
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func jsonETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `W/"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+func etagMatches(r *http.Request, etag string) bool {
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, v any) bool {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error encoding response")
+		return false
+	}
+	etag := jsonETag(body)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(body, '\n'))
+	return false
+}
+
+func handleMetadata(w http.ResponseWriter, r *http.Request) {
+	videoURL := r.URL.Query().Get("url")
+	if !isAllowedVideoURL(r, videoURL) {
+		writeError(w, r, http.StatusBadRequest, codeUnsupportedURL, "Invalid or unsupported video URL")
+		return
+	}
+
+	videoData, err := fetchVideoMetaData(videoURL)
+	if err != nil {
+		writeFetchError(w, r, err)
+		return
+	}
+	if writeJSONWithETag(w, r, videoData) {
+		metadataNotModified.Inc()
+	}
+}
+
+func handleFormats(w http.ResponseWriter, r *http.Request) {
+	videoURLs := r.URL.Query()["url"]
+	if len(videoURLs) == 0 {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Missing video URL")
+		return
+	}
+	if len(videoURLs) > maxFormatsURLs {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Too many video URLs")
+		return
+	}
+
+	videos := make([]*FormatMatrix, len(videoURLs))
+	forEachConcurrently(len(videoURLs), cfg.MetadataConcurrency, func(i int) {
+		videoURL := videoURLs[i]
+		if !isAllowedVideoURL(r, videoURL) {
+			videos[i] = &FormatMatrix{URL: videoURL, Error: "Invalid or unsupported video URL"}
+			return
+		}
+		ytdlpData, err := fetchYTDLPOutput(videoURL)
+		if err != nil {
+			videos[i] = &FormatMatrix{URL: videoURL, Error: fetchErrorMessage(err)}
+			return
+		}
+		videos[i] = newFormatMatrix(ytdlpData)
+	})
+
+	writeJSON(w, http.StatusOK, map[string]any{"videos": videos})
+}
+
+func handleCreateJob(w http.ResponseWriter, r *http.Request) {
+	var req jobRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	tenant := requestTenant(r)
+	if err := req.validate(tenant); err != nil {
+		writeError(w, r, http.StatusBadRequest, validationErrorCode(err), err.Error())
+		return
+	}
+
+	job, err := createJob(req, tenant, requestUser(r), clientIP(r))
+	if errors.Is(err, errQuotaExceeded) {
+		writeError(w, r, http.StatusTooManyRequests, codeQuotaExceeded, "Daily job quota exceeded")
+		return
+	}
+	if writeStorageQuotaError(w, r, err) {
+		return
+	}
+	if writeCooldownError(w, r, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error creating job")
+		return
+	}
+	job.ETA = estimateJob(job)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func handleListJobs(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	if user == nil {
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
+		return
+	}
+	q, ok := parseListQuery(w, r)
+	if !ok {
+		return
+	}
+	jobs, err := jobstore.ListJobs(user.ID, q)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error listing jobs")
+		return
+	}
+	jobs, next := paginate(jobs, q, jobListKey)
+	writePage(w, "jobs", jobs, next)
+}
+
+func handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := getJob(r.PathValue("id"))
+	if errors.Is(err, errJobNotFound) {
+		if summary, err := getArchivedJob(r.PathValue("id")); err == nil {
+			writeJSON(w, http.StatusOK, summary)
+			return
+		}
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Job not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading job")
+		return
+	}
+	job.ETA = estimateJob(job)
+	if !ownsJob(r, job) {
+		job.ArtifactID = ""
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func ownsJob(r *http.Request, job *Job) bool {
+	if adminRequest(r) {
+		return true
+	}
+	user := requestUser(r)
+	return user != nil && job.UserID == user.ID
+}
+
+func artifactOwner(artifact *Artifact) string {
+	if artifact.UserID != "" {
+		return artifact.UserID
+	}
+	if job, err := getJob(artifact.JobID); err == nil {
+		return job.UserID
+	}
+	return ""
+}
+
+func ownsArtifact(r *http.Request, artifact *Artifact) bool {
+	if adminRequest(r) {
+		return true
+	}
+	user := requestUser(r)
+	return user != nil && artifactOwner(artifact) == user.ID
+}
+
+func loadArtifact(w http.ResponseWriter, r *http.Request, id string) *Artifact {
+	artifact, err := getArtifact(id)
+	if errors.Is(err, errArtifactNotFound) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Artifact not found")
+		return nil
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading artifact")
+		return nil
+	}
+	return artifact
+}
+
+func loadOwnArtifact(w http.ResponseWriter, r *http.Request) *Artifact {
+	artifact := loadArtifact(w, r, r.PathValue("id"))
+	if artifact != nil && !ownsArtifact(r, artifact) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Artifact not found")
+		return nil
+	}
+	return artifact
+}
+
+func handleGetArtifact(w http.ResponseWriter, r *http.Request) {
+	if artifact := loadOwnArtifact(w, r); artifact != nil {
+		writeJSON(w, http.StatusOK, artifact)
+	}
+}
+
+func handleDownloadArtifact(w http.ResponseWriter, r *http.Request) {
+	artifact := loadArtifact(w, r, r.PathValue("id"))
+	if artifact == nil {
+		return
+	}
+	if ownsArtifact(r, artifact) || validSeedToken(artifact, r.URL.Query().Get("seed")) {
+		serveArtifact(w, r, artifact)
+		return
+	}
+	if token := r.URL.Query().Get("token"); token != "" && linkTokenArtifact(token) == artifact.ID {
+		r.SetPathValue("token", token)
+		handleOneTimeLink(w, r)
+		return
+	}
+	writeError(w, r, http.StatusNotFound, codeNotFound, "Artifact not found")
+}
+
+func serveArtifactByID(w http.ResponseWriter, r *http.Request, id string) {
+	if artifact := loadArtifact(w, r, id); artifact != nil {
+		serveArtifact(w, r, artifact)
+	}
+}
+
+func serveArtifact(w http.ResponseWriter, r *http.Request, artifact *Artifact) {
+	if artifact.Protected {
+		if wait, locked := passphraseLocked(r, artifact); locked {
+			passphraseFailures.Inc("locked")
+			w.Header().Set("Retry-After", fmt.Sprint(int64((wait+time.Second-1)/time.Second)))
+			writeError(w, r, http.StatusTooManyRequests, codeQuotaExceeded, "Too many wrong passphrases, try again later")
+			return
+		}
+	}
+	f, err := store.Unlock(artifact, requestPassphrase(w, r))
+	if errors.Is(err, errArtifactDeleted) {
+		writeError(w, r, http.StatusGone, codeGone, "Artifact has been deleted")
+		return
+	}
+	if writeColdTierError(w, r, err) {
+		return
+	}
+	if writePassphraseError(w, r, artifact, err) {
+		return
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Artifact not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error opening artifact")
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, artifact.FileName))
+	w.Header().Set("Content-Type", artifact.ContentType)
+	w.Header().Set("X-Content-Checksum", utils.ChecksumHeader(artifact.Checksum))
+	if artifact.Checksum != "" {
+		w.Header().Set("ETag", `"`+artifact.Checksum+`"`)
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	cw := newCountingResponseWriter(w)
+	http.ServeContent(cw, r, artifact.FileName, artifact.CreatedAt, f)
+	t := cw.transfer()
+	t.Incomplete = cw.short()
+	recordTransfer("artifact", t)
+	recordJobTransfer(artifact.JobID, t)
+}
+
+func handleDeleteArtifact(w http.ResponseWriter, r *http.Request) {
+	artifact := loadOwnArtifact(w, r)
+	if artifact == nil {
+		return
+	}
+
+	if err := store.SoftDelete(artifact, "user"); err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error deleting artifact")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleArtifactChecksum(w http.ResponseWriter, r *http.Request) {
+	artifact := loadOwnArtifact(w, r)
+	if artifact == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":        artifact.ID,
+		"algorithm": utils.ChecksumAlgorithm,
+		"checksum":  artifact.Checksum,
+		"size":      artifact.Size,
+	})
+}
+
+func handleArtifactTorrent(w http.ResponseWriter, r *http.Request) {
+	if !cfg.TorrentEnabled {
+		http.NotFound(w, r)
+		return
+	}
+	artifact := loadOwnArtifact(w, r)
+	if artifact == nil {
+		return
+	}
+
+	data, err := store.Torrent(artifact)
+	if errors.Is(err, errArtifactDeleted) {
+		writeError(w, r, http.StatusGone, codeGone, "Artifact has been deleted")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error generating torrent")
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.torrent"`, artifact.FileName))
+	w.Header().Set("Content-Type", "application/x-bittorrent")
+	w.Write(data)
+}
+
+func handleCreateSubscription(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	if user == nil {
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "API key required")
+		return
+	}
+
+	var req struct {
+		URL             string `json:"url"`
+		Name            string `json:"name"`
+		FormatID        string `json:"format"`
+		IntervalMinutes int    `json:"interval_minutes"`
+		OffPeak         bool   `json:"off_peak"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.OffPeak && offPeak == nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, errOffPeakDisabled.Error())
+		return
+	}
+	if !isAllowedVideoURL(r, req.URL) {
+		writeError(w, r, http.StatusBadRequest, codeUnsupportedURL, "Invalid or unsupported video URL")
+		return
+	}
+	if req.FormatID != "" && !isValidFormatID(req.FormatID) {
+		writeError(w, r, http.StatusBadRequest, codeInvalidFormat, "Invalid format")
+		return
+	}
+
+	sub := &Subscription{
+		UserID:          user.ID,
+		Tenant:          user.Tenant,
+		URL:             req.URL,
+		Name:            req.Name,
+		FormatID:        req.FormatID,
+		IntervalMinutes: req.IntervalMinutes,
+		OffPeak:         req.OffPeak,
+	}
+	if err := createSubscription(sub); err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error creating subscription")
+		return
+	}
+	writeJSON(w, http.StatusCreated, sub)
+}
+
+func handleListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	if user == nil {
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "API key required")
+		return
+	}
+
+	q, ok := parseListQuery(w, r)
+	if !ok {
+		return
+	}
+	subs, err := listUserSubscriptions(user.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error listing subscriptions")
+		return
+	}
+	subs, next := paginate(subs, q, func(sub *Subscription) (time.Time, string, string) {
+		return sub.CreatedAt, sub.ID, sub.status()
+	})
+	writePage(w, "subscriptions", subs, next)
+}
+
+func handleDeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	if user == nil {
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "API key required")
+		return
+	}
+
+	sub, err := getSubscription(r.PathValue("id"))
+	if errors.Is(err, errSubscriptionNotFound) || (err == nil && sub.UserID != user.ID) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Subscription not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading subscription")
+		return
+	}
+
+	if err := deleteSubscription(sub); err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error deleting subscription")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
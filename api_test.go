@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func setupTestServer(t *testing.T, respond func(name string, args []string) FakeResult) *fakeRunner {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	prevCfg, prevRDB, prevRunner := cfg, rdb, runner
+	cfg = loadConfig()
+	rdb = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	fake := newFakeRunner(respond)
+	runner = fake
+	t.Cleanup(func() {
+		rdb.Close()
+		cfg, rdb, runner = prevCfg, prevRDB, prevRunner
+	})
+	return fake
+}
+
+func fixtureResponse(t *testing.T, file string) func(name string, args []string) FakeResult {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var line bytes.Buffer
+	if err := json.Compact(&line, data); err != nil {
+		t.Fatal(err)
+	}
+	return func(name string, args []string) FakeResult {
+		return FakeResult{Stdout: append(line.Bytes(), '\n')}
+	}
+}
+
+func TestHandleMetadataRunsYTDLP(t *testing.T) {
+	fake := setupTestServer(t, fixtureResponse(t, "testdata/ytdlp/youtube_video.json"))
+	cfg.ExtractorArgs = []string{"youtube:player_client=web"}
+
+	videoURL := "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/metadata?url="+url.QueryEscape(videoURL), nil)
+	rec := httptest.NewRecorder()
+	handleMetadata(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s, calls %v", rec.Code, rec.Body, fake.Calls())
+	}
+	var video VideoResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &video); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if video.ID != "dQw4w9WgXcQ" {
+		t.Errorf("video id = %q, want dQw4w9WgXcQ", video.ID)
+	}
+
+	calls := fake.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("yt-dlp ran %d times, want 1: %v", len(calls), calls)
+	}
+	call := calls[0]
+	if call.Name != "yt-dlp" {
+		t.Errorf("ran %q, want yt-dlp", call.Name)
+	}
+	if !slices.Equal(call.Args[len(call.Args)-2:], []string{"-j", videoURL}) {
+		t.Errorf("args = %q, want them to end with -j %s", call.Args, videoURL)
+	}
+	if i := slices.Index(call.Args, "--extractor-args"); i < 0 || call.Args[i+1] != "youtube:player_client=web" {
+		t.Errorf("args = %q, want --extractor-args youtube:player_client=web", call.Args)
+	}
+
+	rec = httptest.NewRecorder()
+	handleMetadata(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("cached status = %d, body %s", rec.Code, rec.Body)
+	}
+	if n := len(fake.Calls()); n != 1 {
+		t.Errorf("yt-dlp ran %d times after a cached lookup, want 1", n)
+	}
+}
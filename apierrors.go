@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+)
+
+const (
+	codeInvalidRequest   = "INVALID_REQUEST"
+	codeUnsupportedURL   = "UNSUPPORTED_URL"
+	codeInvalidFormat    = "INVALID_FORMAT"
+	codeFormatNotFound   = "FORMAT_NOT_FOUND"
+	codeNotDownloadable  = "NOT_DOWNLOADABLE"
+	codeFileTooLarge     = "FILE_TOO_LARGE"
+	codePayloadTooLarge  = "PAYLOAD_TOO_LARGE"
+	codeQueryTooLong     = "QUERY_TOO_LONG"
+	codeUnauthorized     = "UNAUTHORIZED"
+	codeForbidden        = "FORBIDDEN"
+	codeOriginBlocked    = "ORIGIN_BLOCKED"
+	codeNotFound         = "NOT_FOUND"
+	codeMethodNotAllowed = "METHOD_NOT_ALLOWED"
+	codeConflict         = "CONFLICT"
+	codeGone             = "GONE"
+	codeQuotaExceeded    = "QUOTA_EXCEEDED"
+	codeUpstreamError    = "UPSTREAM_ERROR"
+	codeSourceCooldown   = "SOURCE_COOLDOWN"
+	codeMaintenance      = "MAINTENANCE"
+	codeFeatureDisabled  = "FEATURE_DISABLED"
+	codeRestoring        = "RESTORING"
+	codeInternal         = "INTERNAL_ERROR"
+)
+
+var (
+	errUnsupportedURL = errors.New("Invalid or unsupported video URL")
+	errInvalidFormat  = errors.New("Invalid format")
+)
+
+var requestIDRegex = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type requestIDContextKey struct{}
+
+type APIError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Details   any    `json:"details,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRegex.MatchString(id) {
+			id = utils.RandomID(8)
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, id)))
+	})
+}
+
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey{}).(string)
+	return id
+}
+
+func wantsJSON(r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, "/api/") || strings.HasPrefix(r.URL.Path, "/admin/") {
+		return true
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func validationErrorCode(err error) string {
+	switch {
+	case errors.Is(err, errUnsupportedURL):
+		return codeUnsupportedURL
+	case errors.Is(err, errInvalidFormat):
+		return codeInvalidFormat
+	default:
+		return codeInvalidRequest
+	}
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	writeErrorDetails(w, r, status, code, message, nil)
+}
+
+func writeErrorDetails(w http.ResponseWriter, r *http.Request, status int, code, message string, details any) {
+	if !wantsJSON(r) {
+		http.Error(w, message, status)
+		return
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	writeJSON(w, status, APIError{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: requestID(r),
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+)
+
+const archiveLayoutJellyfin = "jellyfin"
+
+type nfoUniqueID struct {
+	Type    string `xml:"type,attr"`
+	Default bool   `xml:"default,attr"`
+	Value   string `xml:",chardata"`
+}
+
+type episodeNFO struct {
+	XMLName   xml.Name    `xml:"episodedetails"`
+	Title     string      `xml:"title"`
+	ShowTitle string      `xml:"showtitle"`
+	Season    string      `xml:"season"`
+	Aired     string      `xml:"aired,omitempty"`
+	Plot      string      `xml:"plot,omitempty"`
+	Runtime   int         `xml:"runtime,omitempty"`
+	Thumb     string      `xml:"thumb,omitempty"`
+	UniqueID  nfoUniqueID `xml:"uniqueid"`
+}
+
+type tvShowNFO struct {
+	XMLName xml.Name `xml:"tvshow"`
+	Title   string   `xml:"title"`
+}
+
+func archiveSeason(videoData *VideoResponse) string {
+	if year, _, ok := strings.Cut(videoData.UploadDate, "-"); ok && len(year) == 4 {
+		return year
+	}
+	return "1"
+}
+
+func jellyfinPath(job *Job, videoData *VideoResponse) (string, string, error) {
+	channel := utils.SanitizeFileName(videoData.Author)
+	root := store.UserRoot(tenantByID(job.Tenant).StoragePrefix, job.UserID)
+	dir := filepath.Join(root, "archive", channel, "Season "+archiveSeason(videoData))
+	base := utils.SanitizeFileName(fmt.Sprintf("%s [%s]", videoData.Title, videoData.ID))
+	return dir, base, os.MkdirAll(dir, 0o755)
+}
+
+func writeNFO(path string, v any) error {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), data...), 0o644)
+}
+
+func writeJellyfinMetadata(dir, base string, videoData *VideoResponse) error {
+	showPath := filepath.Join(filepath.Dir(dir), "tvshow.nfo")
+	if _, err := os.Stat(showPath); os.IsNotExist(err) {
+		if err := writeNFO(showPath, tvShowNFO{Title: videoData.Author}); err != nil {
+			return err
+		}
+	}
+
+	episode := episodeNFO{
+		Title:     videoData.Title,
+		ShowTitle: videoData.Author,
+		Season:    archiveSeason(videoData),
+		Aired:     videoData.UploadDate,
+		Plot:      videoData.Description,
+		Runtime:   int(videoData.Duration / 60),
+		UniqueID:  nfoUniqueID{Type: strings.ToLower(videoData.Source), Default: true, Value: videoData.ID},
+	}
+	if _, err := os.Stat(filepath.Join(dir, base+"-thumb.jpg")); err == nil {
+		episode.Thumb = base + "-thumb.jpg"
+	}
+	return writeNFO(filepath.Join(dir, base+".nfo"), episode)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+)
+
+var aria2Client = &http.Client{Timeout: 2 * time.Second}
+
+type aria2RPC struct {
+	port   int
+	secret string
+}
+
+func newAria2RPC() (*aria2RPC, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	return &aria2RPC{port: port, secret: utils.RandomID(16)}, nil
+}
+
+func (a *aria2RPC) args() []string {
+	return []string{
+		"--enable-rpc=true",
+		"--rpc-listen-all=false",
+		fmt.Sprintf("--rpc-listen-port=%d", a.port),
+		"--rpc-secret=" + a.secret,
+	}
+}
+
+type aria2Status struct {
+	CompletedLength string `json:"completedLength"`
+	TotalLength     string `json:"totalLength"`
+	DownloadSpeed   string `json:"downloadSpeed"`
+}
+
+func (a *aria2RPC) tellActive() ([]aria2Status, error) {
+	body, err := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      "progress",
+		"method":  "aria2.tellActive",
+		"params":  []any{"token:" + a.secret, []string{"completedLength", "totalLength", "downloadSpeed"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := aria2Client.Post(fmt.Sprintf("http://127.0.0.1:%d/jsonrpc", a.port), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Result []aria2Status `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	if out.Error != nil {
+		return nil, fmt.Errorf("aria2c: %s", out.Error.Message)
+	}
+	return out.Result, nil
+}
+
+func (a *aria2RPC) poll(tracker *progressTracker) (stop func()) {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			active, err := a.tellActive()
+			if err != nil || len(active) == 0 {
+				continue
+			}
+			var completed, total, speed int64
+			for _, s := range active {
+				c, _ := strconv.ParseInt(s.CompletedLength, 10, 64)
+				t, _ := strconv.ParseInt(s.TotalLength, 10, 64)
+				v, _ := strconv.ParseInt(s.DownloadSpeed, 10, 64)
+				completed, total, speed = completed+c, total+t, speed+v
+			}
+			tracker.update(completed, total, speed)
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+var bandwidthDeferrals = metrics.Counter("odl_bandwidth_deferrals_total", "Jobs moved to the next window because the bandwidth budget was used up.")
+
+type BandwidthBudget struct {
+	LimitBytes int64     `json:"limit_bytes"`
+	UsedBytes  int64     `json:"used_bytes"`
+	WindowEnds time.Time `json:"window_ends"`
+	Exhausted  bool      `json:"exhausted"`
+}
+
+func bandwidthWindow(t time.Time) (time.Time, time.Time) {
+	start := t.UTC().Truncate(cfg.BandwidthWindow)
+	return start, start.Add(cfg.BandwidthWindow)
+}
+
+func bandwidthKey(start time.Time) string {
+	return fmt.Sprintf("bandwidth:%d", start.Unix())
+}
+
+func recordBandwidth(bytes int64) {
+	if cfg.BandwidthBudget <= 0 || bytes <= 0 {
+		return
+	}
+	start, _ := bandwidthWindow(time.Now())
+	rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.IncrBy(ctx, bandwidthKey(start), bytes)
+		pipe.Expire(ctx, bandwidthKey(start), 2*cfg.BandwidthWindow)
+		return nil
+	})
+}
+
+func currentBandwidthBudget() *BandwidthBudget {
+	if cfg.BandwidthBudget <= 0 {
+		return nil
+	}
+	start, end := bandwidthWindow(time.Now())
+	used, err := rdb.Get(ctx, bandwidthKey(start)).Int64()
+	if err != nil && err != redis.Nil {
+		log.Printf("bandwidth budget: %v", err)
+	}
+	return &BandwidthBudget{
+		LimitBytes: cfg.BandwidthBudget,
+		UsedBytes:  used,
+		WindowEnds: end,
+		Exhausted:  used >= cfg.BandwidthBudget,
+	}
+}
+
+func jobBandwidth(job *Job, artifact *Artifact) int64 {
+	if job.Progress != nil && job.Progress.DownloadedBytes > 0 {
+		return job.Progress.DownloadedBytes
+	}
+	if artifact != nil {
+		return artifact.Size
+	}
+	return 0
+}
+
+func scheduleForBandwidth(job *Job) bool {
+	if jobLane(job) == laneFast {
+		return false
+	}
+	budget := currentBandwidthBudget()
+	if budget == nil || !budget.Exhausted {
+		return false
+	}
+	if job.RunAt != nil && !job.RunAt.Before(budget.WindowEnds) {
+		return false
+	}
+	runAt := budget.WindowEnds
+	job.RunAt = &runAt
+	job.DeferredReason = fmt.Sprintf("The server's bandwidth budget of %s is used up, the download starts when it resets at %s.",
+		utils.FormatBytes(budget.LimitBytes), runAt.Format("Mon, 02 Jan 2006 15:04 MST"))
+	bandwidthDeferrals.Inc()
+	return true
+}
+
+func deferForBandwidth(job *Job) bool {
+	if !scheduleForBandwidth(job) {
+		return false
+	}
+	job.StartedAt = nil
+	job.Progress = nil
+	if err := enqueueJob(job); err != nil {
+		log.Printf("worker: deferring job %s for the bandwidth budget: %v", job.ID, err)
+	}
+	return true
+}
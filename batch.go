@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	maxBatchURLs      = 50
+	batchTTL          = time.Hour
+	batchPollInterval = 500 * time.Millisecond
+)
+
+var errBatchNotFound = errors.New("batch not found")
+
+type Batch struct {
+	ID        string    `json:"id"`
+	URLs      []string  `json:"urls"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type BatchResult struct {
+	Index int            `json:"index"`
+	URL   string         `json:"url"`
+	Video *VideoResponse `json:"video,omitempty"`
+	Error string         `json:"error,omitempty"`
+}
+
+func batchKey(id string) string {
+	return fmt.Sprintf("batch:%s", id)
+}
+
+func batchResultsKey(id string) string {
+	return fmt.Sprintf("batch:%s:results", id)
+}
+
+func batchEventsKey(id string) string {
+	return fmt.Sprintf("batch:%s:events", id)
+}
+
+func forEachConcurrently(n, limit int, fn func(i int)) {
+	if limit < 1 {
+		limit = 1
+	}
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+func createBatch(r *http.Request, urls []string) (*Batch, error) {
+	batch := &Batch{ID: utils.RandomID(12), URLs: urls, CreatedAt: time.Now().UTC()}
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return nil, err
+	}
+	if err := rdb.Set(ctx, batchKey(batch.ID), data, batchTTL).Err(); err != nil {
+		return nil, err
+	}
+
+	allowed := make([]bool, len(urls))
+	for i, u := range urls {
+		allowed[i] = isAllowedVideoURL(r, u)
+	}
+	go forEachConcurrently(len(urls), cfg.MetadataConcurrency, func(i int) {
+		result := BatchResult{Index: i, URL: urls[i]}
+		if !allowed[i] {
+			result.Error = "Invalid or unsupported video URL"
+		} else if videoData, err := fetchVideoMetaData(urls[i]); err != nil {
+			result.Error = fetchErrorMessage(err)
+		} else {
+			result.Video = videoData
+		}
+		saveBatchResult(batch.ID, result)
+	})
+	return batch, nil
+}
+
+func saveBatchResult(id string, result BatchResult) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
+	_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, batchResultsKey(id), strconv.Itoa(result.Index), data)
+		pipe.RPush(ctx, batchEventsKey(id), data)
+		pipe.Expire(ctx, batchResultsKey(id), batchTTL)
+		pipe.Expire(ctx, batchEventsKey(id), batchTTL)
+		return nil
+	})
+	if err != nil {
+		log.Printf("batch %s: %v", id, err)
+	}
+}
+
+func getBatch(id string) (*Batch, error) {
+	data, err := rdb.Get(ctx, batchKey(id)).Result()
+	if err == redis.Nil {
+		return nil, errBatchNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var batch Batch
+	if err := json.Unmarshal([]byte(data), &batch); err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+func batchResults(id string) ([]BatchResult, error) {
+	fields, err := rdb.HGetAll(ctx, batchResultsKey(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]BatchResult, 0, len(fields))
+	for _, data := range fields {
+		var result BatchResult
+		if json.Unmarshal([]byte(data), &result) == nil {
+			results = append(results, result)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Index < results[j].Index })
+	return results, nil
+}
+
+func handleCreateBatch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URLs []string `json:"urls"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if len(req.URLs) == 0 {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid JSON body")
+		return
+	}
+	if len(req.URLs) > maxBatchURLs {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("At most %d URLs can be submitted at once", maxBatchURLs))
+		return
+	}
+
+	batch, err := createBatch(r, req.URLs)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error creating batch")
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"batch":      batch,
+		"status_url": cfg.PublicURL + "/api/v1/batches/" + batch.ID,
+		"events_url": cfg.PublicURL + "/api/v1/batches/" + batch.ID + "/events",
+	})
+}
+
+func loadBatch(w http.ResponseWriter, r *http.Request) *Batch {
+	batch, err := getBatch(r.PathValue("id"))
+	if errors.Is(err, errBatchNotFound) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Batch not found")
+		return nil
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading batch")
+		return nil
+	}
+	return batch
+}
+
+func handleGetBatch(w http.ResponseWriter, r *http.Request) {
+	batch := loadBatch(w, r)
+	if batch == nil {
+		return
+	}
+	results, err := batchResults(batch.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading batch")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":        batch.ID,
+		"total":     len(batch.URLs),
+		"completed": len(results),
+		"done":      len(results) == len(batch.URLs),
+		"results":   results,
+	})
+}
+
+func handleBatchEvents(w http.ResponseWriter, r *http.Request) {
+	batch := loadBatch(w, r)
+	if batch == nil {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Streaming unsupported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	next, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+	if next < 0 {
+		next = 0
+	}
+	fmt.Fprintf(w, "retry: %d\n\n", batchPollInterval.Milliseconds()*4)
+
+	ticker := time.NewTicker(batchPollInterval)
+	defer ticker.Stop()
+	lastWrite := time.Now()
+	for {
+		events, err := rdb.LRange(ctx, batchEventsKey(batch.ID), int64(next), -1).Result()
+		if err != nil {
+			return
+		}
+		for _, data := range events {
+			next++
+			fmt.Fprintf(w, "id: %d\nevent: result\ndata: %s\n\n", next, data)
+			lastWrite = time.Now()
+		}
+		if len(events) == 0 && cfg.EventKeepAlive > 0 && time.Since(lastWrite) >= cfg.EventKeepAlive {
+			fmt.Fprint(w, ": keep-alive\n\n")
+			lastWrite = time.Now()
+		}
+		if next >= len(batch.URLs) {
+			fmt.Fprintf(w, "id: %d\nevent: done\ndata: {}\n\n", next)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+const maxBookmarkJobs = 500
+
+type Bookmark struct {
+	URL    string `json:"url"`
+	Title  string `json:"title,omitempty"`
+	Folder string `json:"folder,omitempty"`
+}
+
+type bookmarkFailure struct {
+	URL   string `json:"url"`
+	Error string `json:"error"`
+}
+
+func parseBookmarks(r io.Reader) ([]Bookmark, error) {
+	body := bufio.NewReader(r)
+	head, _ := body.Peek(512)
+	if bytes.HasPrefix(bytes.TrimSpace(head), []byte("<")) {
+		return parseBookmarkHTML(body)
+	}
+	var bookmarks []Bookmark
+	lines := bufio.NewScanner(body)
+	for lines.Scan() {
+		line := strings.TrimSpace(lines.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		link, title, _ := strings.Cut(line, " ")
+		bookmarks = append(bookmarks, Bookmark{URL: link, Title: strings.TrimSpace(title)})
+	}
+	return bookmarks, lines.Err()
+}
+
+func parseBookmarkHTML(r io.Reader) ([]Bookmark, error) {
+	var bookmarks []Bookmark
+	var folders []string
+	var current *Bookmark
+	var heading strings.Builder
+	inHeading, folder := false, ""
+
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if errors.Is(z.Err(), io.EOF) {
+				return bookmarks, nil
+			}
+			return nil, z.Err()
+		case html.StartTagToken:
+			name, hasAttr := z.TagName()
+			switch string(name) {
+			case "a":
+				for hasAttr {
+					var key, val []byte
+					key, val, hasAttr = z.TagAttr()
+					if string(key) == "href" {
+						current = &Bookmark{URL: strings.TrimSpace(string(val)), Folder: joinFolders(folders)}
+					}
+				}
+			case "h3":
+				inHeading = true
+				heading.Reset()
+			case "dl":
+				folders = append(folders, folder)
+				folder = ""
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "a":
+				if current != nil {
+					current.Title = strings.TrimSpace(current.Title)
+					bookmarks = append(bookmarks, *current)
+					current = nil
+				}
+			case "h3":
+				inHeading = false
+				folder = strings.TrimSpace(heading.String())
+			case "dl":
+				if len(folders) > 0 {
+					folders = folders[:len(folders)-1]
+				}
+			}
+		case html.TextToken:
+			if current != nil {
+				current.Title += string(z.Text())
+			} else if inHeading {
+				heading.Write(z.Text())
+			}
+		}
+	}
+}
+
+func joinFolders(folders []string) string {
+	var names []string
+	for _, name := range folders {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, " / ")
+}
+
+func inBookmarkFolder(b Bookmark, folder string) bool {
+	if folder == "" {
+		return true
+	}
+	name := strings.ToLower(b.Folder)
+	folder = strings.ToLower(joinFolders(strings.Split(folder, "/")))
+	return name == folder || strings.HasPrefix(name, folder+" / ")
+}
+
+func queueBookmark(b Bookmark, req jobRequest, tenant *Tenant, user *User, clientAddr string) (*Job, error) {
+	req.URL = b.URL
+	req.title = b.Title
+	req.skipMetadata = true
+	if err := req.validate(tenant); err != nil {
+		return nil, err
+	}
+	return createJob(req, tenant, user, clientAddr)
+}
+
+func handleImportBookmarks(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	if user == nil {
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "API key required")
+		return
+	}
+	tenant := requestTenant(r)
+	values := r.URL.Query()
+	req := jobRequest{FormatID: values.Get("format"), OffPeak: values.Get("off_peak") == "true"}
+	if req.FormatID != "" && !isValidFormatID(req.FormatID) {
+		writeError(w, r, http.StatusBadRequest, codeInvalidFormat, errInvalidFormat.Error())
+		return
+	}
+	if req.OffPeak && offPeak == nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, errOffPeakDisabled.Error())
+		return
+	}
+
+	bookmarks, err := parseBookmarks(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		writeBodyError(w, r, err, "Invalid bookmarks file")
+		return
+	}
+
+	seen := make(map[string]bool)
+	supported, unsupported := []Bookmark{}, []Bookmark{}
+	duplicates := 0
+	for _, b := range bookmarks {
+		if !strings.HasPrefix(b.URL, "http://") && !strings.HasPrefix(b.URL, "https://") {
+			continue
+		}
+		if !inBookmarkFolder(b, values.Get("folder")) {
+			continue
+		}
+		if seen[b.URL] {
+			duplicates++
+			continue
+		}
+		seen[b.URL] = true
+		if isAllowedVideoURL(r, b.URL) {
+			supported = append(supported, b)
+		} else {
+			unsupported = append(unsupported, b)
+		}
+	}
+	if len(supported) > maxBookmarkJobs {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest,
+			fmt.Sprintf("At most %d bookmarks can be imported at once, found %d, pick a folder to import part of the file", maxBookmarkJobs, len(supported)))
+		return
+	}
+
+	resp := map[string]any{
+		"total":       len(seen),
+		"duplicates":  duplicates,
+		"unsupported": unsupported,
+	}
+	if values.Get("dry_run") == "true" {
+		resp["dry_run"] = true
+		resp["jobs"] = supported
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	if len(supported) > 0 {
+		if err := checkStorageQuota(tenant, user.ID); writeStorageQuotaError(w, r, err) {
+			return
+		}
+	}
+
+	jobs := []*Job{}
+	failed := []bookmarkFailure{}
+	for _, b := range supported {
+		job, err := queueBookmark(b, req, tenant, user, clientIP(r))
+		var quotaErr *StorageQuotaError
+		var cooldownErr *CooldownError
+		switch {
+		case errors.Is(err, errQuotaExceeded):
+			failed = append(failed, bookmarkFailure{URL: b.URL, Error: "Daily job quota exceeded"})
+		case errors.As(err, &quotaErr):
+			failed = append(failed, bookmarkFailure{URL: b.URL, Error: "Storage quota exceeded"})
+		case errors.As(err, &cooldownErr):
+			failed = append(failed, bookmarkFailure{URL: b.URL, Error: cooldownErr.Error()})
+		case err != nil:
+			failed = append(failed, bookmarkFailure{URL: b.URL, Error: "Error creating job"})
+		default:
+			jobs = append(jobs, job)
+		}
+	}
+	resp["jobs"] = jobs
+	resp["failed"] = failed
+	writeJSON(w, http.StatusAccepted, resp)
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const maxBulkJobs = 500
+
+var (
+	errJobNotCancellable = errors.New("job is not scheduled, queued or running")
+	errJobNotRetryable   = errors.New("job has not failed or been cancelled")
+	errJobRunning        = errors.New("job is running, cancel it first")
+)
+
+var bulkJobActions = map[string]func(*Job) error{
+	"cancel": cancelJob,
+	"retry":  retryJob,
+	"delete": deleteJob,
+}
+
+type bulkJobFilter struct {
+	UserID string   `json:"user_id"`
+	Status []string `json:"status"`
+	Host   string   `json:"host"`
+	Since  string   `json:"since"`
+	Until  string   `json:"until"`
+}
+
+type bulkJobRequest struct {
+	Action string         `json:"action"`
+	IDs    []string       `json:"ids"`
+	Filter *bulkJobFilter `json:"filter"`
+}
+
+type bulkJobFailure struct {
+	ID      string `json:"id"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type bulkJobResult struct {
+	Action    string           `json:"action"`
+	Matched   int              `json:"matched"`
+	Truncated bool             `json:"truncated,omitempty"`
+	Succeeded []string         `json:"succeeded"`
+	Failed    []bulkJobFailure `json:"failed"`
+}
+
+func cancelJob(job *Job) error {
+	switch job.Status {
+	case JobScheduled, JobQueued, JobRunning:
+	default:
+		return errJobNotCancellable
+	}
+	if err := rdb.Set(ctx, jobCancelKey(job.ID), "1", cfg.JobTTL).Err(); err != nil {
+		return err
+	}
+	rdb.ZRem(ctx, jobScheduleKey, job.ID)
+	dropJobPassphrase(job.ID)
+
+	now := time.Now().UTC()
+	job.Status = JobCancelled
+	job.FinishedAt = &now
+	if err := saveJob(job); err != nil {
+		return err
+	}
+	markJobFinished(job)
+	events.Publish(EventJobCancelled, job.Tenant, *job)
+	return nil
+}
+
+func retryJob(job *Job) error {
+	if job.Status != JobFailed && job.Status != JobCancelled {
+		return errJobNotRetryable
+	}
+	tenant := tenantByID(job.Tenant)
+	if err := checkStorageQuota(tenant, job.UserID); err != nil {
+		return err
+	}
+	if ok, err := tenant.ConsumeQuota("jobs", tenant.Quota.DailyJobs); err != nil {
+		return err
+	} else if !ok {
+		return errQuotaExceeded
+	}
+	rdb.Del(ctx, jobCancelKey(job.ID))
+	rdb.ZRem(ctx, jobsFinishedKey, job.ID)
+
+	job.Error = ""
+	job.RunAt = nil
+	job.StartedAt = nil
+	job.FinishedAt = nil
+	job.Progress = nil
+	job.ArtifactID = ""
+	job.Checksum = ""
+	job.OneTimeURL = ""
+	return enqueueJob(job)
+}
+
+func deleteJob(job *Job) error {
+	if job.Status == JobRunning {
+		return errJobRunning
+	}
+	if job.ArtifactID != "" {
+		if artifact, err := getArtifact(job.ArtifactID); err == nil {
+			if err := store.SoftDelete(artifact, "user"); err != nil {
+				return err
+			}
+		}
+	}
+	rdb.ZRem(ctx, jobScheduleKey, job.ID)
+	rdb.ZRem(ctx, jobsFinishedKey, job.ID)
+	rdb.Del(ctx, jobCancelKey(job.ID))
+	dropJobPassphrase(job.ID)
+	deleteJobLog(job.ID)
+	return jobstore.DeleteJob(job)
+}
+
+func bulkFailure(id string, err error) bulkJobFailure {
+	code := codeInternal
+	var storageErr *StorageQuotaError
+	switch {
+	case errors.Is(err, errJobNotFound):
+		code = codeNotFound
+	case errors.Is(err, errJobNotCancellable), errors.Is(err, errJobNotRetryable), errors.Is(err, errJobRunning):
+		code = codeConflict
+	case errors.Is(err, errQuotaExceeded), errors.As(err, &storageErr):
+		code = codeQuotaExceeded
+	}
+	return bulkJobFailure{ID: id, Code: code, Message: err.Error()}
+}
+
+func (f *bulkJobFilter) matchesHost(videoURL string) bool {
+	if f.Host == "" {
+		return true
+	}
+	u, err := url.Parse(videoURL)
+	if err != nil {
+		return false
+	}
+	host, want := strings.ToLower(u.Hostname()), strings.ToLower(f.Host)
+	return host == want || strings.HasSuffix(host, "."+want)
+}
+
+func (f *bulkJobFilter) query() (ListQuery, error) {
+	q := ListQuery{Limit: maxBulkJobs, Statuses: f.Status}
+	var err error
+	if q.Since, err = parseListTime(f.Since); err != nil {
+		return q, errors.New("Invalid since date, use RFC 3339 or YYYY-MM-DD")
+	}
+	if q.Until, err = parseListTime(f.Until); err != nil {
+		return q, errors.New("Invalid until date, use RFC 3339 or YYYY-MM-DD")
+	}
+	return q, nil
+}
+
+func filterJobs(userID string, f *bulkJobFilter, q ListQuery) ([]*Job, bool, error) {
+	var jobs []*Job
+	for {
+		page, err := jobstore.ListJobs(userID, q)
+		if err != nil {
+			return nil, false, err
+		}
+		page, next := paginate(page, q, jobListKey)
+		for _, job := range page {
+			if !f.matchesHost(job.URL) {
+				continue
+			}
+			if jobs = append(jobs, job); len(jobs) > maxBulkJobs {
+				return jobs[:maxBulkJobs], true, nil
+			}
+		}
+		if next == "" {
+			return jobs, false, nil
+		}
+		if q.cursor, err = decodeCursor(next); err != nil {
+			return nil, false, err
+		}
+	}
+}
+
+func handleBulkJobs(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	if user == nil {
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "API key required")
+		return
+	}
+	runBulkJobs(w, r, user.ID)
+}
+
+func handleAdminBulkJobs(w http.ResponseWriter, r *http.Request) {
+	runBulkJobs(w, r, "")
+}
+
+func runBulkJobs(w http.ResponseWriter, r *http.Request, ownerID string) {
+	var req bulkJobRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	action, ok := bulkJobActions[req.Action]
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Action must be cancel, retry or delete")
+		return
+	}
+	if (len(req.IDs) == 0) == (req.Filter == nil) {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Provide either ids or filter")
+		return
+	}
+	if len(req.IDs) > maxBulkJobs {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("At most %d job IDs can be given", maxBulkJobs))
+		return
+	}
+
+	result := bulkJobResult{Action: req.Action, Succeeded: []string{}, Failed: []bulkJobFailure{}}
+	var jobs []*Job
+	if req.Filter != nil {
+		userID := ownerID
+		if userID == "" {
+			userID = req.Filter.UserID
+		}
+		if userID == "" {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Filter requires user_id")
+			return
+		}
+		q, err := req.Filter.query()
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		if jobs, result.Truncated, err = filterJobs(userID, req.Filter, q); err != nil {
+			writeError(w, r, http.StatusInternalServerError, codeInternal, "Error listing jobs")
+			return
+		}
+		result.Matched = len(jobs)
+	} else {
+		result.Matched = len(req.IDs)
+		for _, id := range req.IDs {
+			job, err := getJob(id)
+			if err == nil && ownerID != "" && job.UserID != ownerID {
+				err = errJobNotFound
+			}
+			if err != nil {
+				result.Failed = append(result.Failed, bulkFailure(id, err))
+				continue
+			}
+			jobs = append(jobs, job)
+		}
+	}
+
+	for _, job := range jobs {
+		if err := action(job); err != nil {
+			result.Failed = append(result.Failed, bulkFailure(job.ID, err))
+			continue
+		}
+		result.Succeeded = append(result.Succeeded, job.ID)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+)
+
+const maxBundleOutputs = 5
+
+var (
+	bundleVideoExts    = map[string]bool{"mp4": true, "mkv": true, "webm": true}
+	bundleAudioExts    = map[string]bool{"mp3": true, "m4a": true, "opus": true, "flac": true, "wav": true}
+	bundleSubtitleExts = map[string]bool{"srt": true, "vtt": true, "ass": true}
+	subtitleLangsRegex = regexp.MustCompile(`^[A-Za-z0-9.*,-]{1,64}$`)
+)
+
+type JobOutput struct {
+	Type   string `json:"type"`
+	Format string `json:"format,omitempty"`
+	Ext    string `json:"ext,omitempty"`
+}
+
+func (o *JobOutput) normalize() error {
+	switch o.Type {
+	case "video":
+		if o.Format != "" && !isValidFormatID(o.Format) {
+			return errors.New("Invalid video output")
+		}
+		if o.Format == "" {
+			o.Format = defaultJobFormat
+		}
+		if o.Ext == "" {
+			o.Ext = mergeOutputFormat
+		}
+		if !bundleVideoExts[o.Ext] {
+			return errors.New("Invalid video output")
+		}
+	case "audio":
+		if !flagEnabled(flagAudio) {
+			return errors.New("Audio downloads are disabled on this server")
+		}
+		if o.Format != "" && !isValidFormatID(o.Format) {
+			return errors.New("Invalid audio output")
+		}
+		if o.Format == "" {
+			o.Format = "ba/b"
+		}
+		if o.Ext == "" {
+			o.Ext = "mp3"
+		}
+		if !bundleAudioExts[o.Ext] {
+			return errors.New("Invalid audio output")
+		}
+	case "subtitles":
+		if o.Format == "" {
+			o.Format = "en.*"
+		}
+		if o.Ext == "" {
+			o.Ext = "srt"
+		}
+		if !subtitleLangsRegex.MatchString(o.Format) || !bundleSubtitleExts[o.Ext] {
+			return errors.New("Invalid subtitles output")
+		}
+	default:
+		return fmt.Errorf("Unknown output type %q", o.Type)
+	}
+	return nil
+}
+
+func (o JobOutput) args() []string {
+	switch o.Type {
+	case "audio":
+		return []string{"-f", o.Format, "-x", "--audio-format", o.Ext}
+	case "subtitles":
+		return []string{"--skip-download", "--write-subs", "--write-auto-subs", "--sub-langs", o.Format, "--convert-subs", o.Ext}
+	default:
+		return []string{"-f", o.Format, "--merge-output-format", o.Ext}
+	}
+}
+
+func handleBundle(w http.ResponseWriter, r *http.Request) {
+	if !parseForm(w, r) {
+		return
+	}
+
+	req := jobRequest{
+		URL:   r.FormValue("videoURL"),
+		Email: r.FormValue("email"),
+	}
+	for _, kind := range r.Form["outputs"] {
+		output := JobOutput{Type: kind}
+		if kind == "video" {
+			output.Format = r.FormValue("format")
+		}
+		req.Outputs = append(req.Outputs, output)
+	}
+	if len(req.Outputs) == 0 {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Select at least one output")
+		return
+	}
+	tenant := requestTenant(r)
+	if err := req.validate(tenant); err != nil {
+		writeError(w, r, http.StatusBadRequest, validationErrorCode(err), err.Error())
+		return
+	}
+
+	job, err := createJob(req, tenant, requestUser(r), clientIP(r))
+	if errors.Is(err, errQuotaExceeded) {
+		writeError(w, r, http.StatusTooManyRequests, codeQuotaExceeded, "Daily job quota exceeded")
+		return
+	}
+	if writeStorageQuotaError(w, r, err) {
+		return
+	}
+	if writeCooldownError(w, r, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Error creating bundle: %v", err))
+		return
+	}
+
+	fmt.Fprintf(w, `
+		<div class="mt-4 p-3 rounded-md bg-neutral-800">
+			<p class="text-white mb-2">Bundle queued. A one-time download link will be available once it is ready.</p>
+			<p class="text-white text-sm">Track it at <a class="underline" href="/api/v1/jobs/%s">/api/v1/jobs/%s</a></p>
+		</div>`, job.ID, job.ID)
+}
+
+func downloadBundleToStore(job *Job, videoData *VideoResponse) (*Artifact, error) {
+	dir, err := store.JobDir(tenantByID(job.Tenant).StoragePrefix, job.UserID, job.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for i, output := range job.Outputs {
+		outDir := filepath.Join(dir, fmt.Sprintf("%d-%s", i, output.Type))
+		args := append(output.args(), "--prefer-ffmpeg", "--no-mtime", "--no-playlist")
+		if cfg.MaxFilesize > 0 {
+			args = append(args, "--max-filesize", fmt.Sprint(cfg.MaxFilesize))
+		}
+		args = append(args, "-o", filepath.Join(outDir, "%(title).100B.%(ext)s"))
+
+		if err := runJobDownload(job, args); err != nil {
+			return nil, fmt.Errorf("yt-dlp (%s): %w", output.Type, err)
+		}
+
+		entries, err := os.ReadDir(outDir)
+		if err != nil {
+			return nil, fmt.Errorf("%s output: %w", output.Type, err)
+		}
+		produced := false
+		for _, entry := range entries {
+			name := entry.Name()
+			if !entry.Type().IsRegular() || strings.HasSuffix(name, ".part") {
+				continue
+			}
+			files = append(files, filepath.Join(outDir, name))
+			produced = true
+		}
+		if !produced {
+			return nil, fmt.Errorf("yt-dlp did not produce a %s file", output.Type)
+		}
+	}
+
+	zipPath := filepath.Join(dir, utils.SanitizeFileName(videoData.Title)+".zip")
+	if err := writeBundle(zipPath, files); err != nil {
+		return nil, err
+	}
+	for i, output := range job.Outputs {
+		os.RemoveAll(filepath.Join(dir, fmt.Sprintf("%d-%s", i, output.Type)))
+	}
+	if err := scanJobFile(job, videoData, zipPath); err != nil {
+		return nil, err
+	}
+	return store.AddArtifact(job, zipPath)
+}
+
+func writeBundle(zipPath string, files []string) error {
+	out, err := os.Create(zipPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	zw := zip.NewWriter(out)
+	seen := make(map[string]bool)
+	for _, path := range files {
+		name := filepath.Base(path)
+		if seen[name] {
+			name = filepath.Base(filepath.Dir(path)) + "-" + name
+		}
+		seen[name] = true
+
+		if err := addBundleFile(zw, path, name); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func addBundleFile(zw *zip.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Store
+	if bundleSubtitleExts[strings.TrimPrefix(filepath.Ext(name), ".")] {
+		header.Method = zip.Deflate
+	}
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = copyBuffered(w, f)
+	return err
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	icalTimeFormat  = "20060102T150405Z"
+	icalRunDuration = "PT15M"
+)
+
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+func handleSubscriptionCalendar(w http.ResponseWriter, r *http.Request) {
+	subs, err := listAllSubscriptions()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error listing subscriptions")
+		return
+	}
+
+	host := strings.TrimPrefix(strings.TrimPrefix(cfg.PublicURL, "https://"), "http://")
+	now := time.Now().UTC().Format(icalTimeFormat)
+
+	var b strings.Builder
+	b.WriteString("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//OneTimeDownload//Subscriptions//EN\r\nX-WR-CALNAME:Subscription runs\r\n")
+	writeEvent := func(uid string, start time.Time, summary, description string) {
+		fmt.Fprintf(&b, "BEGIN:VEVENT\r\nUID:%s@%s\r\nDTSTAMP:%s\r\nDTSTART:%s\r\nDURATION:%s\r\nSUMMARY:%s\r\nDESCRIPTION:%s\r\nEND:VEVENT\r\n",
+			uid, host, now, start.UTC().Format(icalTimeFormat), icalRunDuration,
+			icalEscaper.Replace(summary), icalEscaper.Replace(description))
+	}
+	for _, sub := range subs {
+		name := sub.Name
+		if name == "" {
+			name = sub.URL
+		}
+		description := fmt.Sprintf("%s\nUser: %s\nInterval: %d minutes", sub.URL, sub.UserID, sub.IntervalMinutes)
+		writeEvent("next-"+sub.ID, sub.NextRunAt, "Subscription run: "+name, description)
+		if sub.LastRunAt != nil {
+			status := "ok"
+			if sub.LastError != "" {
+				status = "failed: " + sub.LastError
+			}
+			writeEvent(fmt.Sprintf("run-%s-%d", sub.ID, sub.LastRunAt.Unix()), *sub.LastRunAt,
+				"Subscription run: "+name, description+"\nResult: "+status)
+		}
+	}
+	b.WriteString("END:VCALENDAR\r\n")
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	ssdpAddr       = "239.255.255.250:1900"
+	ssdpDeviceType = "urn:schemas-upnp-org:device:MediaServer:1"
+	dlnaFeatures   = "DLNA.ORG_OP=01;DLNA.ORG_CI=0;DLNA.ORG_FLAGS=01700000000000000000000000000000"
+)
+
+func castKey(token string) string {
+	return fmt.Sprintf("cast:%s", token)
+}
+
+func castURL(token string) string {
+	return fmt.Sprintf("%s/cast/%s", cfg.PublicURL, token)
+}
+
+func createCastToken(artifact *Artifact) (string, error) {
+	token := utils.RandomID(16)
+	return token, rdb.Set(ctx, castKey(token), artifact.ID, cfg.CastTTL).Err()
+}
+
+func handleCreateCast(w http.ResponseWriter, r *http.Request) {
+	artifact, err := getArtifact(r.PathValue("id"))
+	if errors.Is(err, errArtifactNotFound) || (err == nil && artifact.DeletedAt != nil) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Artifact not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading artifact")
+		return
+	}
+
+	token, err := createCastToken(artifact)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error creating cast link")
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]any{
+		"url":          castURL(token),
+		"content_type": artifact.ContentType,
+		"expires_at":   time.Now().Add(cfg.CastTTL).UTC(),
+	})
+}
+
+func handleCastMedia(w http.ResponseWriter, r *http.Request) {
+	artifactID, err := rdb.Get(ctx, castKey(r.PathValue("token"))).Result()
+	if err == redis.Nil {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Cast link expired")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading cast link")
+		return
+	}
+	artifact, err := getArtifact(artifactID)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Artifact not found")
+		return
+	}
+	f, err := store.Open(artifact)
+	if writeColdTierError(w, r, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Artifact not found")
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", artifact.ContentType)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header()["transferMode.dlna.org"] = []string{"Streaming"}
+	w.Header()["contentFeatures.dlna.org"] = []string{dlnaFeatures}
+	http.ServeContent(w, r, artifact.FileName, artifact.CreatedAt, f)
+}
+
+func handleWatch(w http.ResponseWriter, r *http.Request) {
+	artifact, err := getArtifact(r.PathValue("id"))
+	if err != nil || artifact.DeletedAt != nil {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Artifact not found")
+		return
+	}
+	token, err := createCastToken(artifact)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error creating cast link")
+		return
+	}
+
+	fmt.Fprintf(w, `<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8" />
+	<meta name="viewport" content="width=device-width, initial-scale=1.0" />
+	<title>%s</title>
+	<script src="https://cdn.tailwindcss.com"></script>
+</head>
+<body class="bg-neutral-900 text-white min-h-screen">
+	<div class="container mx-auto px-4 py-8 max-w-3xl">
+		<h2 class="text-xl font-bold mb-4">%s</h2>
+		<video id="player" src="%s" controls class="w-full rounded-md mb-4"></video>
+		<button id="cast" class="w-full bg-red-900 text-white p-3 rounded-md hover:bg-blue-600">Cast to TV</button>
+		<p class="text-sm text-gray-300 mt-4">DLNA players can open this link directly: <code>%s</code></p>
+	</div>
+	<script src="/static/cast.js"></script>
+</body>
+</html>`,
+		html.EscapeString(artifact.FileName),
+		html.EscapeString(artifact.FileName),
+		castURL(token),
+		castURL(token),
+	)
+}
+
+func dlnaDeviceUUID() string {
+	host, _ := os.Hostname()
+	sum := sha1.Sum([]byte("onetimedownload:" + host + cfg.PublicURL))
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+func handleDLNADescription(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	fmt.Fprintf(w, `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+	<specVersion><major>1</major><minor>0</minor></specVersion>
+	<device>
+		<deviceType>%s</deviceType>
+		<friendlyName>OneTimeDownload</friendlyName>
+		<manufacturer>OneTimeDownload</manufacturer>
+		<modelName>OneTimeDownload</modelName>
+		<presentationURL>%s</presentationURL>
+		<UDN>uuid:%s</UDN>
+	</device>
+</root>`, ssdpDeviceType, cfg.PublicURL, dlnaDeviceUUID())
+}
+
+func ssdpMessage(startLine, ntHeader, nt string) []byte {
+	usn := "uuid:" + dlnaDeviceUUID()
+	if nt != usn {
+		usn += "::" + nt
+	}
+	return []byte(strings.Join([]string{
+		startLine,
+		"HOST: " + ssdpAddr,
+		"CACHE-CONTROL: max-age=1800",
+		"LOCATION: " + cfg.PublicURL + "/dlna/description.xml",
+		"SERVER: OneTimeDownload UPnP/1.0",
+		ntHeader + ": " + nt,
+		"USN: " + usn,
+		"NTS: ssdp:alive",
+		"", "",
+	}, "\r\n"))
+}
+
+func runSSDP() {
+	group, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		log.Printf("ssdp: %v", err)
+		return
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		log.Printf("ssdp: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	go func() {
+		for {
+			conn.WriteToUDP(ssdpMessage("NOTIFY * HTTP/1.1", "NT", ssdpDeviceType), group)
+			time.Sleep(5 * time.Minute)
+		}
+	}()
+
+	buf := make([]byte, 2048)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			log.Printf("ssdp: %v", err)
+			return
+		}
+		msg := string(buf[:n])
+		if !strings.HasPrefix(msg, "M-SEARCH") {
+			continue
+		}
+		if !strings.Contains(msg, "ssdp:all") && !strings.Contains(msg, ssdpDeviceType) {
+			continue
+		}
+		response := strings.Replace(string(ssdpMessage("HTTP/1.1 200 OK", "ST", ssdpDeviceType)), "NTS: ssdp:alive\r\n", "EXT:\r\n", 1)
+		conn.WriteToUDP([]byte(response), from)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+const clientHintsHeader = "Sec-CH-UA-Mobile, Save-Data"
+
+type clientProfile struct {
+	Mobile   bool
+	SaveData bool
+}
+
+func requestClientProfile(r *http.Request) clientProfile {
+	p := clientProfile{
+		SaveData: strings.EqualFold(r.Header.Get("Save-Data"), "on"),
+	}
+	if hint := r.Header.Get("Sec-CH-UA-Mobile"); hint != "" {
+		p.Mobile = hint == "?1"
+	} else {
+		ua := r.UserAgent()
+		p.Mobile = strings.Contains(ua, "Mobi") || strings.Contains(ua, "Android")
+	}
+	return p
+}
+
+func (p clientProfile) maxHeight() int {
+	height := 0
+	if p.Mobile {
+		height = cfg.MobileMaxHeight
+	}
+	if p.SaveData && cfg.SaveDataMaxHeight > 0 && (height == 0 || cfg.SaveDataMaxHeight < height) {
+		height = cfg.SaveDataMaxHeight
+	}
+	return height
+}
+
+func (v *VideoResponse) RecommendedMedia(maxHeight int) Media {
+	if maxHeight > 0 {
+		for _, media := range v.Medias {
+			if !media.AudioOnly() && media.Height > 0 && media.Height <= maxHeight && !exceedsMaxFilesize(media.EstimatedSize()) {
+				return media
+			}
+		}
+	}
+	return v.DefaultMedia()
+}
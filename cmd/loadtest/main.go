@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ticketRegex = regexp.MustCompile(`/download(?:/|\?t=)([A-Za-z0-9]+)`)
+
+type options struct {
+	target         string
+	videoURL       string
+	format         string
+	requests       int
+	concurrency    int
+	download       bool
+	timeout        time.Duration
+	originAddr     string
+	originSize     int64
+	maxFailureRate float64
+}
+
+type sample struct {
+	phase    string
+	duration time.Duration
+	bytes    int64
+	err      error
+}
+
+type phaseReport struct {
+	Phase     string         `json:"phase"`
+	Requests  int            `json:"requests"`
+	Failures  int            `json:"failures"`
+	Failure   float64        `json:"failure_rate"`
+	P50MS     float64        `json:"p50_ms"`
+	P90MS     float64        `json:"p90_ms"`
+	P99MS     float64        `json:"p99_ms"`
+	MaxMS     float64        `json:"max_ms"`
+	BytesRead int64          `json:"bytes"`
+	Errors    map[string]int `json:"errors,omitempty"`
+}
+
+func main() {
+	var o options
+	flag.StringVar(&o.target, "target", "http://localhost:8080", "Base URL of the instance under test")
+	flag.StringVar(&o.videoURL, "url", "", "Video URL to submit, defaults to a file on the mock origin")
+	flag.StringVar(&o.format, "format", "", "Format to download, defaults to the first format the picker offers")
+	flag.IntVar(&o.requests, "n", 100, "Total submit (and download) iterations")
+	flag.IntVar(&o.concurrency, "c", 10, "Iterations running at the same time")
+	flag.BoolVar(&o.download, "download", true, "Download the video after each submit")
+	flag.DurationVar(&o.timeout, "timeout", 2*time.Minute, "Timeout of a single request")
+	flag.StringVar(&o.originAddr, "origin", "127.0.0.1:9090", "Address of the mock origin, empty to disable it")
+	flag.Int64Var(&o.originSize, "origin-size", 10<<20, "Size in bytes of the files the mock origin serves")
+	flag.Float64Var(&o.maxFailureRate, "max-failure-rate", 0.01, "Exit with status 1 when more iterations fail than this")
+	flag.Parse()
+
+	if o.originAddr != "" {
+		addr, err := startOrigin(o.originAddr, o.originSize)
+		if err != nil {
+			log.Fatalf("starting mock origin: %v", err)
+		}
+		if o.videoURL == "" {
+			o.videoURL = fmt.Sprintf("http://%s/video/loadtest.mp4", addr)
+		}
+	}
+	if o.videoURL == "" {
+		log.Fatal("-url is required when the mock origin is disabled")
+	}
+
+	client := &http.Client{Timeout: o.timeout}
+	start := time.Now()
+	samples := run(client, o)
+	elapsed := time.Since(start)
+
+	reports := summarize(samples)
+	out := map[string]any{
+		"target":      o.target,
+		"url":         o.videoURL,
+		"iterations":  o.requests,
+		"concurrency": o.concurrency,
+		"elapsed_s":   elapsed.Seconds(),
+		"throughput":  float64(o.requests) / elapsed.Seconds(),
+		"phases":      reports,
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(out)
+
+	for _, r := range reports {
+		if r.Failure > o.maxFailureRate {
+			os.Exit(1)
+		}
+	}
+}
+
+func startOrigin(addr string, size int64) (string, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	content := bytes.Repeat([]byte{0}, int(size))
+	copy(content, "\x00\x00\x00\x18ftypmp42")
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /video/{name}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		http.ServeContent(w, r, r.PathValue("name"), time.Time{}, bytes.NewReader(content))
+	})
+	go http.Serve(ln, mux)
+	return ln.Addr().String(), nil
+}
+
+func run(client *http.Client, o options) []sample {
+	var mu sync.Mutex
+	var samples []sample
+	record := func(s sample) {
+		mu.Lock()
+		samples = append(samples, s)
+		mu.Unlock()
+	}
+
+	jobs := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < o.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				ticket, format, s := submit(client, o)
+				record(s)
+				if !o.download || s.err != nil {
+					continue
+				}
+				record(download(client, o, ticket, format))
+			}
+		}()
+	}
+	for i := 0; i < o.requests; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+	wg.Wait()
+	return samples
+}
+
+func submit(client *http.Client, o options) (string, string, sample) {
+	s := sample{phase: "submit"}
+	start := time.Now()
+	resp, err := client.PostForm(o.target+"/submit", url.Values{"videoURL": {o.videoURL}})
+	if err != nil {
+		s.err = err
+		return "", "", s
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	s.duration, s.bytes = time.Since(start), int64(len(body))
+	if err != nil {
+		s.err = err
+		return "", "", s
+	}
+	if resp.StatusCode != http.StatusOK {
+		s.err = fmt.Errorf("status %d", resp.StatusCode)
+		return "", "", s
+	}
+	m := ticketRegex.FindSubmatch(body)
+	if m == nil {
+		s.err = errors.New("no download ticket in page")
+		return "", "", s
+	}
+
+	format := o.format
+	if format == "" {
+		if format, err = firstFormat(client, o); err != nil {
+			s.err = err
+			return "", "", s
+		}
+	}
+	return string(m[1]), format, s
+}
+
+var (
+	firstFormatOnce sync.Once
+	firstFormatID   string
+	firstFormatErr  error
+)
+
+func firstFormat(client *http.Client, o options) (string, error) {
+	firstFormatOnce.Do(func() {
+		resp, err := client.Get(o.target + "/api/v1/metadata?url=" + url.QueryEscape(o.videoURL))
+		if err != nil {
+			firstFormatErr = err
+			return
+		}
+		defer resp.Body.Close()
+		var video struct {
+			Medias []struct {
+				FormatID string `json:"format_id"`
+			} `json:"medias"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&video); err != nil {
+			firstFormatErr = err
+			return
+		}
+		if len(video.Medias) == 0 {
+			firstFormatErr = errors.New("video has no formats")
+			return
+		}
+		firstFormatID = video.Medias[0].FormatID
+	})
+	return firstFormatID, firstFormatErr
+}
+
+func download(client *http.Client, o options, ticket, format string) sample {
+	s := sample{phase: "download"}
+	q := url.Values{"format": {format}, "filename": {"loadtest"}}
+	start := time.Now()
+	resp, err := client.Get(o.target + "/download/" + ticket + "?" + q.Encode())
+	if err != nil {
+		s.err = err
+		return s
+	}
+	defer resp.Body.Close()
+	s.bytes, err = io.Copy(io.Discard, resp.Body)
+	s.duration = time.Since(start)
+	switch {
+	case err != nil:
+		s.err = err
+	case resp.StatusCode != http.StatusOK:
+		s.err = fmt.Errorf("status %d", resp.StatusCode)
+	case resp.Trailer.Get("X-Content-Checksum") == "":
+		s.err = errors.New("missing checksum trailer")
+	}
+	return s
+}
+
+func summarize(samples []sample) []phaseReport {
+	byPhase := map[string][]sample{}
+	for _, s := range samples {
+		byPhase[s.phase] = append(byPhase[s.phase], s)
+	}
+	var reports []phaseReport
+	for _, phase := range []string{"submit", "download"} {
+		list := byPhase[phase]
+		if len(list) == 0 {
+			continue
+		}
+		r := phaseReport{Phase: phase, Requests: len(list)}
+		var durations []time.Duration
+		for _, s := range list {
+			r.BytesRead += s.bytes
+			if s.err != nil {
+				r.Failures++
+				if r.Errors == nil {
+					r.Errors = map[string]int{}
+				}
+				r.Errors[errorKey(s.err)]++
+				continue
+			}
+			durations = append(durations, s.duration)
+		}
+		r.Failure = float64(r.Failures) / float64(r.Requests)
+		sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+		r.P50MS = percentile(durations, 0.50)
+		r.P90MS = percentile(durations, 0.90)
+		r.P99MS = percentile(durations, 0.99)
+		r.MaxMS = percentile(durations, 1)
+		reports = append(reports, r)
+	}
+	return reports
+}
+
+func percentile(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * p)
+	return float64(sorted[i].Microseconds()) / 1000
+}
+
+func errorKey(err error) string {
+	msg := err.Error()
+	if i := strings.LastIndex(msg, ": "); i >= 0 && strings.Contains(msg, "://") {
+		return msg[i+2:]
+	}
+	return msg
+}
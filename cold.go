@@ -0,0 +1,471 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	coldTierDir = "dir"
+	coldTierS3  = "s3"
+
+	TierCold      = "cold"
+	TierRestoring = "restoring"
+
+	artifactAccessKey = "artifacts:accessed"
+	coldTierLockName  = "cold_tier"
+	coldTierLockTTL   = 5 * time.Minute
+	coldRestoreRetry  = 15 * time.Minute
+)
+
+var errRestorePending = errors.New("the file is being restored from cold storage")
+
+var (
+	coldTierMoves    = metrics.Counter("odl_cold_tier_moves_total", "Stored files moved to or restored from the cold tier.", "direction")
+	coldTierFailures = metrics.Counter("odl_cold_tier_failures_total", "Failed moves to or from the cold tier.", "direction")
+)
+
+type ColdStore interface {
+	Put(c context.Context, key, path string) error
+	Get(c context.Context, key, path string) error
+	Delete(c context.Context, key string) error
+}
+
+var (
+	coldStore ColdStore
+	thawing   singleflight.Group
+)
+
+func newColdStore(kind string) (ColdStore, error) {
+	switch kind {
+	case "":
+		return nil, nil
+	case coldTierDir:
+		if cfg.ColdTierDir == "" {
+			return nil, errors.New("COLD_TIER=dir needs COLD_TIER_DIR")
+		}
+		return dirColdStore{dir: cfg.ColdTierDir}, os.MkdirAll(cfg.ColdTierDir, 0o755)
+	case coldTierS3:
+		if cfg.ColdTierS3Endpoint == "" || cfg.ColdTierS3Bucket == "" {
+			return nil, errors.New("COLD_TIER=s3 needs COLD_TIER_S3_ENDPOINT and COLD_TIER_S3_BUCKET")
+		}
+		return &s3ColdStore{
+			endpoint:     strings.TrimSuffix(cfg.ColdTierS3Endpoint, "/"),
+			bucket:       cfg.ColdTierS3Bucket,
+			region:       cfg.ColdTierS3Region,
+			accessKey:    cfg.ColdTierS3AccessKey,
+			secretKey:    cfg.ColdTierS3SecretKey,
+			storageClass: cfg.ColdTierS3StorageClass,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown cold tier %q", kind)
+	}
+}
+
+func coldKey(artifact *Artifact) string {
+	return "artifacts/" + artifact.ID
+}
+
+func coldArtifactLock(artifact *Artifact) string {
+	return "cold_artifact:" + artifact.ID
+}
+
+func waitForColdLock(artifact *Artifact) (*Lock, error) {
+	deadline := time.Now().Add(coldTierLockTTL)
+	for {
+		lock, err := acquireLock(coldArtifactLock(artifact), coldTierLockTTL)
+		if !errors.Is(err, errLockHeld) || time.Now().After(deadline) {
+			return lock, err
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func (s *ContentStore) Touch(artifact *Artifact) {
+	rdb.ZAdd(ctx, artifactAccessKey, redis.Z{Score: float64(time.Now().Unix()), Member: artifact.ID})
+}
+
+func (s *ContentStore) servedInPlace(artifact *Artifact) bool {
+	if artifact.UserID == "" {
+		return false
+	}
+	if cfg.WebDAVEnabled {
+		return true
+	}
+	rel, err := filepath.Rel(s.UserRoot(tenantByID(artifact.Tenant).StoragePrefix, artifact.UserID), artifact.Path)
+	return err == nil && strings.HasPrefix(rel, "archive"+string(filepath.Separator))
+}
+
+func (s *ContentStore) Freeze(artifact *Artifact) error {
+	if artifact.DeletedAt != nil || artifact.Tier != "" || s.servedInPlace(artifact) {
+		rdb.ZRem(ctx, artifactAccessKey, artifact.ID)
+		return nil
+	}
+	if err := coldStore.Put(ctx, coldKey(artifact), artifact.Path); err != nil {
+		coldTierFailures.Inc("freeze")
+		return err
+	}
+
+	lock, err := acquireLock(coldArtifactLock(artifact), coldTierLockTTL)
+	if err != nil {
+		coldStore.Delete(ctx, coldKey(artifact))
+		if errors.Is(err, errLockHeld) {
+			return nil
+		}
+		return err
+	}
+	defer lock.Release()
+	current, err := getArtifact(artifact.ID)
+	if err != nil {
+		coldStore.Delete(ctx, coldKey(artifact))
+		return err
+	}
+	accessed, err := rdb.ZScore(ctx, artifactAccessKey, artifact.ID).Result()
+	if current.DeletedAt != nil || current.Tier != "" || (err == nil && time.Unix(int64(accessed), 0).After(time.Now().Add(-cfg.ColdTierAfter))) {
+		coldStore.Delete(ctx, coldKey(artifact))
+		return nil
+	}
+	*artifact = *current
+	now := time.Now().UTC()
+	artifact.Tier = TierCold
+	artifact.FrozenAt = &now
+	if err := saveArtifact(artifact); err != nil {
+		return err
+	}
+	os.Remove(artifact.Path)
+	rdb.ZRem(ctx, artifactAccessKey, artifact.ID)
+	coldTierMoves.Inc("freeze")
+	debugf("cold tier: moved artifact %s (%s)", artifact.ID, utils.FormatBytes(artifact.Size))
+	return nil
+}
+
+func (s *ContentStore) Thaw(artifact *Artifact) error {
+	_, err, _ := thawing.Do(artifact.ID, func() (any, error) {
+		lock, err := waitForColdLock(artifact)
+		if err != nil {
+			return nil, err
+		}
+		defer lock.Release()
+		if current, err := getArtifact(artifact.ID); err == nil && current.Tier == "" {
+			return nil, nil
+		}
+		if coldStore == nil {
+			return nil, errors.New("artifact is in the cold tier, but no cold tier is configured")
+		}
+		if err := os.MkdirAll(filepath.Dir(artifact.Path), 0o755); err != nil {
+			return nil, err
+		}
+		tmp := artifact.Path + ".thaw-" + utils.RandomID(4)
+		err = coldStore.Get(ctx, coldKey(artifact), tmp)
+		if errors.Is(err, errRestorePending) {
+			if artifact.Tier != TierRestoring {
+				artifact.Tier = TierRestoring
+				saveArtifact(artifact)
+			}
+			return nil, err
+		}
+		if err == nil {
+			err = os.Rename(tmp, artifact.Path)
+		}
+		if err != nil {
+			os.Remove(tmp)
+			coldTierFailures.Inc("thaw")
+			return nil, err
+		}
+		artifact.Tier = ""
+		artifact.FrozenAt = nil
+		if err := saveArtifact(artifact); err != nil {
+			return nil, err
+		}
+		if err := coldStore.Delete(ctx, coldKey(artifact)); err != nil {
+			log.Printf("cold tier: deleting the cold copy of artifact %s: %v", artifact.ID, err)
+		}
+		coldTierMoves.Inc("thaw")
+		return nil, nil
+	})
+	if err != nil {
+		return err
+	}
+	artifact.Tier = ""
+	artifact.FrozenAt = nil
+	return nil
+}
+
+func runColdTiering() {
+	if coldStore == nil || cfg.ColdTierAfter <= 0 {
+		return
+	}
+	if n, err := rdb.Exists(ctx, artifactAccessKey).Result(); err == nil && n == 0 {
+		rdb.ZUnionStore(ctx, artifactAccessKey, &redis.ZStore{Keys: []string{artifactIndexKey}})
+	}
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		lock, err := acquireLock(coldTierLockName, coldTierLockTTL)
+		if errors.Is(err, errLockHeld) {
+			continue
+		}
+		if err != nil {
+			log.Printf("cold tier: %v", err)
+			continue
+		}
+		store.sweep(artifactAccessKey, cfg.ColdTierAfter, store.Freeze)
+		lock.Release()
+	}
+}
+
+func writeColdTierError(w http.ResponseWriter, r *http.Request, err error) bool {
+	if !errors.Is(err, errRestorePending) {
+		return false
+	}
+	w.Header().Set("Retry-After", fmt.Sprint(int(coldRestoreRetry.Seconds())))
+	writeError(w, r, http.StatusServiceUnavailable, codeRestoring, "This file is being restored from cold storage, try again later")
+	return true
+}
+
+type dirColdStore struct {
+	dir string
+}
+
+func (s dirColdStore) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+func (s dirColdStore) Put(c context.Context, key, path string) error {
+	dst := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if err := copyFile(path, dst+".part"); err != nil {
+		return err
+	}
+	return os.Rename(dst+".part", dst)
+}
+
+func (s dirColdStore) Get(c context.Context, key, path string) error {
+	return copyFile(s.path(key), path)
+}
+
+func (s dirColdStore) Delete(c context.Context, key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+type s3ColdStore struct {
+	endpoint     string
+	bucket       string
+	region       string
+	accessKey    string
+	secretKey    string
+	storageClass string
+}
+
+func (s *s3ColdStore) Put(c context.Context, key, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	if s.storageClass != "" {
+		header.Set("X-Amz-Storage-Class", s.storageClass)
+	}
+	resp, err := s.do(c, http.MethodPut, key, "", f, info.Size(), header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3ColdStore) Get(c context.Context, key, path string) error {
+	resp, err := s.do(c, http.MethodGet, key, "", nil, 0, nil)
+	var s3Err *s3Error
+	if errors.As(err, &s3Err) && s3Err.Code == "InvalidObjectState" {
+		return s.restore(c, key)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func (s *s3ColdStore) restore(c context.Context, key string) error {
+	body := fmt.Sprintf("<RestoreRequest><Days>%d</Days></RestoreRequest>", cfg.ColdTierRestoreDays)
+	resp, err := s.do(c, http.MethodPost, key, "restore=", strings.NewReader(body), int64(len(body)), nil)
+	var s3Err *s3Error
+	if err != nil && !(errors.As(err, &s3Err) && s3Err.Code == "RestoreAlreadyInProgress") {
+		return err
+	}
+	if resp != nil {
+		resp.Body.Close()
+	}
+	return errRestorePending
+}
+
+func (s *s3ColdStore) Delete(c context.Context, key string) error {
+	resp, err := s.do(c, http.MethodDelete, key, "", nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type s3Error struct {
+	Status int
+	Code   string
+}
+
+func (e *s3Error) Error() string {
+	return fmt.Sprintf("s3 answered %d %s", e.Status, e.Code)
+}
+
+func (s *s3ColdStore) do(c context.Context, method, key, query string, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	target := s.endpoint + "/" + s.bucket + "/" + key
+	if query != "" {
+		target += "?" + query
+	}
+	req, err := http.NewRequestWithContext(c, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	s.sign(req, time.Now().UTC())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		code := http.StatusText(resp.StatusCode)
+		if _, rest, ok := strings.Cut(string(data), "<Code>"); ok {
+			code, _, _ = strings.Cut(rest, "</Code>")
+		}
+		return nil, &s3Error{Status: resp.StatusCode, Code: code}
+	}
+	return resp, nil
+}
+
+func (s *s3ColdStore) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	payload := req.Header.Get("X-Amz-Content-Sha256")
+	if payload == "" {
+		payload = "UNSIGNED-PAYLOAD"
+		req.Header.Set("X-Amz-Content-Sha256", payload)
+	}
+
+	names := []string{"host"}
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			names = append(names, lower)
+		}
+	}
+	slices.Sort(names)
+	var headers strings.Builder
+	for _, name := range names {
+		value := req.URL.Host
+		if name != "host" {
+			value = strings.TrimSpace(req.Header.Get(name))
+		}
+		fmt.Fprintf(&headers, "%s:%s\n", name, value)
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		headers.String(),
+		signedHeaders,
+		payload,
+	}, "\n")
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	for _, part := range []string{s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(values url.Values) string {
+	pairs := make([]string, 0, len(values))
+	for name, list := range values {
+		for _, value := range list {
+			pairs = append(pairs, strings.ReplaceAll(url.QueryEscape(name), "+", "%20")+"="+strings.ReplaceAll(url.QueryEscape(value), "+", "%20"))
+		}
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
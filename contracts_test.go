@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateContracts = flag.Bool("update", false, "rewrite the golden output of the yt-dlp contract fixtures instead of comparing it")
+
+func TestYTDLPContracts(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "ytdlp", "contracts", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevFlags := cfg.FeatureFlags
+	cfg.FeatureFlags = nil
+	t.Cleanup(func() { cfg.FeatureFlags = prevFlags })
+
+	checked := 0
+	for _, file := range files {
+		if strings.HasSuffix(file, ".golden.json") {
+			continue
+		}
+		checked++
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".json"), func(t *testing.T) {
+			checkContract(t, file)
+		})
+	}
+	if checked == 0 {
+		t.Fatal("no fixtures in testdata/ytdlp/contracts")
+	}
+}
+
+func checkContract(t *testing.T, file string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := parseYTDLPOutput(data)
+	if err != nil {
+		t.Fatalf("parsing: %v", err)
+	}
+	got, _ := json.MarshalIndent(newVideoResponse(out), "", "  ")
+	golden := strings.TrimSuffix(file, ".json") + ".golden.json"
+	if *updateContracts {
+		if err := os.WriteFile(golden, append(got, '\n'), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLines := strings.Split(string(bytes.TrimSpace(want)), "\n")
+	gotLines := strings.Split(string(got), "\n")
+	for i := 0; i < len(wantLines) || i < len(gotLines); i++ {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if w != g {
+			t.Fatalf("line %d: want %s, got %s", i+1, strings.TrimSpace(w), strings.TrimSpace(g))
+		}
+	}
+}
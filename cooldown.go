@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	cooldownStrikeWindow = 24 * time.Hour
+	maxStderrTail        = 16 * 1024
+)
+
+var rateLimitMarkers = []string{
+	"HTTP Error 429",
+	"Too Many Requests",
+	"Sign in to confirm you're not a bot",
+	"Sign in to confirm you’re not a bot",
+}
+
+var cooldownsStarted = metrics.Counter("odl_source_cooldowns_total", "Cooldowns started because a source rate limited yt-dlp.", "source")
+
+type Cooldown struct {
+	Source  string    `json:"source"`
+	Reason  string    `json:"reason"`
+	Strikes int64     `json:"strikes"`
+	Until   time.Time `json:"until"`
+}
+
+type CooldownError struct {
+	Cooldown *Cooldown
+}
+
+func (e *CooldownError) Error() string {
+	return fmt.Sprintf("%s is rate limiting this server, try again after %s", e.Cooldown.Source, e.Cooldown.Until.Format(time.RFC3339))
+}
+
+func cooldownKey(source string) string {
+	return fmt.Sprintf("cooldown:%s", source)
+}
+
+func cooldownStrikesKey(source string) string {
+	return fmt.Sprintf("cooldown_strikes:%s", source)
+}
+
+func detectMarker(output string, markers []string) (string, bool) {
+	for _, marker := range markers {
+		if strings.Contains(output, marker) {
+			return marker, true
+		}
+	}
+	return "", false
+}
+
+func activeCooldown(source string) (*Cooldown, bool) {
+	if source == "" {
+		return nil, false
+	}
+	data, err := rdb.Get(ctx, cooldownKey(source)).Result()
+	if err != nil {
+		return nil, false
+	}
+	var c Cooldown
+	if json.Unmarshal([]byte(data), &c) != nil || time.Now().After(c.Until) {
+		return nil, false
+	}
+	return &c, true
+}
+
+func startCooldown(source, reason string) *Cooldown {
+	if source == "" || cfg.CooldownBase <= 0 {
+		return nil
+	}
+	if c, ok := activeCooldown(source); ok {
+		return c
+	}
+	pipe := rdb.Pipeline()
+	incr := pipe.Incr(ctx, cooldownStrikesKey(source))
+	pipe.Expire(ctx, cooldownStrikesKey(source), cooldownStrikeWindow)
+	pipe.Exec(ctx)
+	strikes, err := incr.Result()
+	if err != nil {
+		strikes = 1
+	}
+
+	d := cfg.CooldownBase
+	for i := int64(1); i < strikes && (cfg.CooldownMax <= 0 || d < cfg.CooldownMax); i++ {
+		d *= 2
+	}
+	if cfg.CooldownMax > 0 {
+		d = min(d, cfg.CooldownMax)
+	}
+	c := &Cooldown{Source: source, Reason: reason, Strikes: strikes, Until: time.Now().Add(d).UTC().Truncate(time.Second)}
+	data, _ := json.Marshal(c)
+	rdb.Set(ctx, cooldownKey(source), data, d)
+	cooldownsStarted.Inc(source)
+	log.Printf("cooldown: %s until %s (%s)", source, c.Until.Format(time.RFC3339), reason)
+	return c
+}
+
+func checkRateLimit(source string, proxy *Proxy, err error) error {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+	return checkRateLimitOutput(source, proxy, string(exitErr.Stderr), err)
+}
+
+func checkRateLimitOutput(source string, proxy *Proxy, output string, err error) error {
+	if rotateProxy(proxy, source, output) {
+		return fmt.Errorf("%w: %w", errProxyBlocked, err)
+	}
+	marker, ok := detectMarker(output, rateLimitMarkers)
+	if !ok {
+		return err
+	}
+	if c := startCooldown(source, marker); c != nil {
+		return &CooldownError{Cooldown: c}
+	}
+	return err
+}
+
+type tailBuffer struct {
+	buf   []byte
+	limit int
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	limit := t.limit
+	if limit <= 0 {
+		limit = maxStderrTail
+	}
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > limit {
+		t.buf = t.buf[len(t.buf)-limit:]
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	return string(t.buf)
+}
+
+func urlSource(videoURL string) string {
+	u, err := url.Parse(videoURL)
+	if err != nil {
+		return ""
+	}
+	return siteLabel(u)
+}
+
+func deferForCooldown(job *Job, err error) bool {
+	var cooldownErr *CooldownError
+	if !errors.As(err, &cooldownErr) {
+		return false
+	}
+	runAt := cooldownErr.Cooldown.Until
+	job.RunAt = &runAt
+	job.StartedAt = nil
+	job.Progress = nil
+	if err := enqueueJob(job); err != nil {
+		log.Printf("worker: rescheduling job %s: %v", job.ID, err)
+	}
+	return true
+}
+
+func listCooldowns() ([]*Cooldown, error) {
+	keys, err := scanKeys("cooldown:*")
+	if err != nil {
+		return nil, err
+	}
+	cooldowns := []*Cooldown{}
+	for _, key := range keys {
+		if c, ok := activeCooldown(strings.TrimPrefix(key, "cooldown:")); ok {
+			cooldowns = append(cooldowns, c)
+		}
+	}
+	return cooldowns, nil
+}
+
+func writeCooldownError(w http.ResponseWriter, r *http.Request, err error) bool {
+	var cooldownErr *CooldownError
+	if !errors.As(err, &cooldownErr) {
+		return false
+	}
+	w.Header().Set("Retry-After", fmt.Sprint(int(time.Until(cooldownErr.Cooldown.Until).Seconds())+1))
+	writeError(w, r, http.StatusServiceUnavailable, codeSourceCooldown, cooldownErr.Error())
+	return true
+}
+
+func writeFetchError(w http.ResponseWriter, r *http.Request, err error) {
+	if writeCooldownError(w, r, err) {
+		return
+	}
+	var ytdlpErr *YTDLPError
+	if errors.As(err, &ytdlpErr) {
+		switch ytdlpErr.Class {
+		case "unsupported_url":
+			writeError(w, r, http.StatusBadRequest, codeUnsupportedURL, fetchErrorMessage(err))
+			return
+		case "unavailable":
+			writeError(w, r, http.StatusUnprocessableEntity, codeNotDownloadable, fetchErrorMessage(err))
+			return
+		}
+	}
+	writeError(w, r, http.StatusInternalServerError, codeInternal, fetchErrorMessage(err))
+}
+
+func fetchErrorMessage(err error) string {
+	var cooldownErr *CooldownError
+	if errors.As(err, &cooldownErr) {
+		return cooldownErr.Error()
+	}
+	var ytdlpErr *YTDLPError
+	if errors.As(err, &ytdlpErr) {
+		switch ytdlpErr.Class {
+		case "unsupported_url":
+			return "This URL is not supported"
+		case "unavailable":
+			return "This video is unavailable"
+		}
+	}
+	return "Error fetching video meta data"
+}
+
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "unavailable", "error": "redis unreachable"})
+		return
+	}
+	cooldowns, err := listCooldowns()
+	if err != nil {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "unavailable", "error": "redis unreachable"})
+		return
+	}
+	status := "ok"
+	if len(cooldowns) > 0 {
+		status = "degraded"
+	}
+	_, maintenance := activeMaintenance()
+	writeJSON(w, http.StatusOK, map[string]any{"status": status, "cooldowns": cooldowns, "maintenance": maintenance})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+func validateCORS() error {
+	if cfg.CORSCredentials && slices.Contains(cfg.CORSOrigins, "*") {
+		return errors.New("CORS_ALLOW_CREDENTIALS=true needs explicit CORS_ALLOWED_ORIGINS, not *")
+	}
+	return nil
+}
+
+func corsOriginAllowed(origin string) bool {
+	for _, allowed := range cfg.CORSOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if prefix, suffix, ok := strings.Cut(allowed, "*."); ok && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !corsOriginAllowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		if cfg.CORSCredentials || !corsOriginAllowed("*") {
+			h.Set("Access-Control-Allow-Origin", origin)
+		} else {
+			h.Set("Access-Control-Allow-Origin", "*")
+		}
+		if cfg.CORSCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+			h.Set("Access-Control-Expose-Headers", strings.Join(cfg.CORSExposedHeaders, ", "))
+			next.ServeHTTP(w, r)
+			return
+		}
+		h.Set("Access-Control-Allow-Methods", strings.Join(cfg.CORSMethods, ", "))
+		h.Set("Access-Control-Allow-Headers", strings.Join(cfg.CORSHeaders, ", "))
+		if cfg.CORSMaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.CORSMaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
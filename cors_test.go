@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSWildcardWithCredentials(t *testing.T) {
+	prevCfg := cfg
+	t.Cleanup(func() { cfg = prevCfg })
+
+	tests := []struct {
+		name        string
+		origins     []string
+		credentials bool
+		valid       bool
+		allowOrigin string
+	}{
+		{"wildcard", []string{"*"}, false, true, "*"},
+		{"wildcard with credentials", []string{"*"}, true, false, ""},
+		{"wildcard among others with credentials", []string{"https://app.example.com", "*"}, true, false, ""},
+		{"explicit origin with credentials", []string{"https://app.example.com"}, true, true, "https://app.example.com"},
+		{"subdomain pattern with credentials", []string{"https://*.example.com"}, true, true, "https://app.example.com"},
+		{"other origin", []string{"https://other.example.org"}, false, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg = loadConfig()
+			cfg.CORSOrigins, cfg.CORSCredentials = tt.origins, tt.credentials
+			err := validateCORS()
+			if (err == nil) != tt.valid {
+				t.Fatalf("validateCORS() = %v, want valid %v", err, tt.valid)
+			}
+			if !tt.valid {
+				return
+			}
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/jobs", nil)
+			req.Header.Set("Origin", "https://app.example.com")
+			rec := httptest.NewRecorder()
+			withCORS(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).ServeHTTP(rec, req)
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.allowOrigin)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Credentials") == "true"; got != (tt.credentials && tt.allowOrigin != "") {
+				t.Errorf("Access-Control-Allow-Credentials sent = %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+)
+
+const artifactChecksumKey = "artifacts:by_checksum"
+
+var (
+	dedupedArtifacts = metrics.Counter("odl_deduplicated_artifacts_total", "Stored files replaced by a link to an identical file already in the store.")
+	dedupSavedBytes  = metrics.Counter("odl_deduplicated_bytes_total", "Bytes not kept on disk because an identical file was already stored.")
+)
+
+func fileEncrypted(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, len(encryptionMagic))
+	_, err = io.ReadFull(f, magic)
+	return err == nil && string(magic) == encryptionMagic
+}
+
+func deduplicateFile(path, checksum string, size int64) string {
+	if !cfg.Deduplicate {
+		return ""
+	}
+	id, err := rdb.HGet(ctx, artifactChecksumKey, checksum).Result()
+	if err != nil {
+		return ""
+	}
+	existing, err := getArtifact(id)
+	if err != nil || existing.DeletedAt != nil || existing.Tier != "" || existing.Protected || existing.Checksum != checksum || existing.Size != size {
+		return ""
+	}
+	if fileEncrypted(existing.Path) != (keyring != nil) {
+		return ""
+	}
+	link := path + ".dedup-" + utils.RandomID(4)
+	if err := os.Link(existing.Path, link); err != nil {
+		debugf("dedup: linking %s to artifact %s: %v", path, existing.ID, err)
+		return ""
+	}
+	if err := os.Rename(link, path); err != nil {
+		os.Remove(link)
+		return ""
+	}
+	dedupedArtifacts.Inc()
+	dedupSavedBytes.Add(float64(size))
+	if existing.Blob != "" {
+		return existing.Blob
+	}
+	return existing.ID
+}
+
+func indexArtifactChecksum(artifact *Artifact) {
+	if cfg.Deduplicate && !artifact.Protected && !artifact.Deduplicated {
+		rdb.HSet(ctx, artifactChecksumKey, artifact.Checksum, artifact.ID)
+	}
+}
+
+func unindexArtifactChecksum(artifact *Artifact) {
+	if id, err := rdb.HGet(ctx, artifactChecksumKey, artifact.Checksum).Result(); err == nil && id == artifact.ID {
+		rdb.HDel(ctx, artifactChecksumKey, artifact.Checksum)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeduplicatedArtifactsCountOneBlob(t *testing.T) {
+	setupTestServer(t, nil)
+	cfg.Deduplicate = true
+	prevStore, prevKeyring := store, keyring
+	t.Cleanup(func() { store, keyring = prevStore, prevKeyring })
+	keyring = nil
+	var err error
+	if store, err = newContentStore(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("the same video, downloaded twice")
+	var artifacts []*Artifact
+	for _, jobID := range []string{"job1", "job2"} {
+		job := &Job{ID: jobID, Tenant: "default", UserID: "u1"}
+		dir, err := store.JobDir("", job.UserID, job.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "video.mp4")
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		artifact, err := store.AddArtifact(job, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		artifacts = append(artifacts, artifact)
+	}
+	if !artifacts[1].Deduplicated {
+		t.Fatal("second artifact was not deduplicated")
+	}
+
+	size := int64(len(data))
+	check := func(step string, want int64) {
+		t.Helper()
+		if got := storedBytes(storageTenantsKey, "default"); got != want {
+			t.Errorf("%s: tenant usage = %d, want %d", step, got, want)
+		}
+		if got := storedBytes(storageUsersKey, "u1"); got != want {
+			t.Errorf("%s: user usage = %d, want %d", step, got, want)
+		}
+	}
+	check("both stored", size)
+
+	if err := store.SoftDelete(artifacts[0], "test"); err != nil {
+		t.Fatal(err)
+	}
+	check("one deleted", size)
+	if err := store.Restore(artifacts[0]); err != nil {
+		t.Fatal(err)
+	}
+	check("restored", size)
+
+	rdb.Del(ctx, storageTenantsKey, storageUsersKey)
+	rebuildStorageUsage()
+	check("rebuilt", size)
+
+	for _, artifact := range artifacts {
+		if err := store.Purge(artifact); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check("both purged", 0)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+)
+
+const (
+	diagnoseLockName    = "diagnose"
+	diagnoseMaxFilesize = "50M"
+)
+
+type DiagnoseStage struct {
+	Name       string         `json:"name"`
+	OK         bool           `json:"ok"`
+	DurationMS int64          `json:"duration_ms"`
+	Error      string         `json:"error,omitempty"`
+	Details    map[string]any `json:"details,omitempty"`
+}
+
+type DiagnoseReport struct {
+	OK          bool             `json:"ok"`
+	URL         string           `json:"url"`
+	Format      string           `json:"format"`
+	FailedStage string           `json:"failed_stage,omitempty"`
+	Stages      []*DiagnoseStage `json:"stages"`
+	DurationMS  int64            `json:"duration_ms"`
+}
+
+func (d *DiagnoseReport) run(name string, fn func(stage *DiagnoseStage) error) bool {
+	stage := &DiagnoseStage{Name: name}
+	start := time.Now()
+	err := fn(stage)
+	stage.DurationMS = time.Since(start).Milliseconds()
+	d.Stages = append(d.Stages, stage)
+	if err != nil {
+		stage.Error = redactSecrets(err.Error())
+		d.FailedStage = name
+		return false
+	}
+	stage.OK = true
+	return true
+}
+
+func runDiagnosis(c context.Context, videoURL, formatID, expectedSHA256 string) *DiagnoseReport {
+	report := &DiagnoseReport{URL: videoURL, Format: formatID}
+	start := time.Now()
+	defer func() { report.DurationMS = time.Since(start).Milliseconds() }()
+
+	if !report.run("redis", func(*DiagnoseStage) error {
+		return rdb.Ping(ctx).Err()
+	}) {
+		return report
+	}
+
+	var dir string
+	if !report.run("storage", func(stage *DiagnoseStage) error {
+		var err error
+		if err = os.MkdirAll(cfg.StorageDir, 0o750); err != nil {
+			return err
+		}
+		dir, err = os.MkdirTemp(cfg.StorageDir, ".diagnose-")
+		return err
+	}) {
+		return report
+	}
+	defer os.RemoveAll(dir)
+
+	if !report.run("metadata", func(stage *DiagnoseStage) error {
+		invalidateMetadata(videoURL)
+		videoData, err := fetchVideoMetaData(videoURL)
+		if err != nil {
+			return err
+		}
+		stage.Details = map[string]any{"title": videoData.Title, "source": videoData.Source, "formats": len(videoData.Medias)}
+		return nil
+	}) {
+		return report
+	}
+
+	var file string
+	if !report.run("download", func(stage *DiagnoseStage) error {
+		reqCtx, cancel := context.WithTimeout(c, cfg.DiagnoseTimeout)
+		defer cancel()
+		args := ytdlpArgs(pickProxy(urlSource(videoURL)),
+			"-f", formatID,
+			"--no-playlist",
+			"--no-mtime",
+			"--max-filesize", diagnoseMaxFilesize,
+			"-o", filepath.Join(dir, "diagnose.%(ext)s"),
+			videoURL,
+		)
+		output := &tailBuffer{}
+		cmd := runner.CommandContext(reqCtx, "yt-dlp", args...)
+		cmd.Stdout, cmd.Stderr = output, output
+		if err := cmd.Run(); err != nil {
+			if reqCtx.Err() != nil {
+				return fmt.Errorf("yt-dlp did not finish within %s", cfg.DiagnoseTimeout)
+			}
+			lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+			stage.Details = map[string]any{"failure_class": classifyYTDLPFailure(output.String()), "last_line": redactSecrets(lines[len(lines)-1])}
+			return fmt.Errorf("yt-dlp: %w", err)
+		}
+		matches, _ := filepath.Glob(filepath.Join(dir, "diagnose.*"))
+		for _, path := range matches {
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && utils.IsMediaExt(filepath.Ext(path)) {
+				file = path
+				stage.Details = map[string]any{"file": filepath.Base(path), "size": info.Size()}
+				return nil
+			}
+		}
+		return errors.New("yt-dlp did not produce a media file")
+	}) {
+		return report
+	}
+
+	report.run("checksum", func(stage *DiagnoseStage) error {
+		sum, err := utils.FileSHA256(file)
+		if err != nil {
+			return err
+		}
+		stage.Details = map[string]any{"algorithm": utils.ChecksumAlgorithm, "checksum": sum}
+		if expectedSHA256 != "" && !strings.EqualFold(sum, expectedSHA256) {
+			return fmt.Errorf("checksum %s does not match the expected %s", sum, expectedSHA256)
+		}
+		return nil
+	})
+	report.OK = report.FailedStage == ""
+	return report
+}
+
+func handleDiagnose(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		URL    string `json:"url"`
+		Format string `json:"format"`
+		SHA256 string `json:"sha256"`
+	}{URL: cfg.DiagnoseURL, Format: cfg.DiagnoseFormat}
+	if r.ContentLength != 0 && !decodeJSON(w, r, &req) {
+		return
+	}
+	expected := req.SHA256
+	if req.URL == cfg.DiagnoseURL && expected == "" {
+		expected = cfg.DiagnoseSHA256
+	}
+	if !utils.ValidateURL(req.URL) {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid test video URL")
+		return
+	}
+	if !isValidFormatID(req.Format) {
+		writeError(w, r, http.StatusBadRequest, codeInvalidFormat, "Invalid format")
+		return
+	}
+
+	lock, err := acquireLock(diagnoseLockName+":"+instanceID, cfg.DiagnoseTimeout+time.Minute)
+	if errors.Is(err, errLockHeld) {
+		writeError(w, r, http.StatusConflict, codeConflict, "A diagnosis is already running")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error starting diagnosis")
+		return
+	}
+	defer lock.Release()
+
+	report := runDiagnosis(r.Context(), req.URL, req.Format, expected)
+	status := http.StatusOK
+	if !report.OK {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
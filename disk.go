@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+)
+
+const (
+	diskFullDefer  = "defer"
+	diskFullReject = "reject"
+
+	diskRetry = 10 * time.Minute
+)
+
+var (
+	storeFreeBytes = metrics.Gauge("odl_store_free_bytes", "Free space on the file store's disk at the last admission check.")
+	diskRefusals   = metrics.Counter("odl_disk_admission_refusals_total", "Jobs held back because the file store was near capacity.", "action")
+)
+
+var diskReserved atomic.Int64
+
+type DiskFullError struct {
+	Needed int64
+	Free   int64
+}
+
+func (e *DiskFullError) Error() string {
+	return fmt.Sprintf("the file store has %s free, this download needs about %s", utils.FormatBytes(e.Free), utils.FormatBytes(e.Needed))
+}
+
+func diskNeeded(job *Job, videoData *VideoResponse) int64 {
+	size := estimateJobSize(job, videoData)
+	if size <= 0 {
+		size = job.EstimatedSize
+	}
+	return size + cfg.DiskReserve
+}
+
+func admitToDisk(job *Job, videoData *VideoResponse) (func(), error) {
+	if !cfg.DiskAdmission {
+		return func() {}, nil
+	}
+	free, err := store.FreeSpace()
+	if err != nil {
+		log.Printf("job %s: checking free disk space: %v", job.ID, err)
+		return func() {}, nil
+	}
+	storeFreeBytes.Set(float64(free))
+	needed := diskNeeded(job, videoData)
+	if reserved := diskReserved.Add(needed); free-reserved < 0 {
+		diskReserved.Add(-needed)
+		return nil, &DiskFullError{Needed: needed, Free: max(free-reserved+needed, 0)}
+	}
+	return func() { diskReserved.Add(-needed) }, nil
+}
+
+func deferForDisk(job *Job, err error) bool {
+	var diskErr *DiskFullError
+	if !errors.As(err, &diskErr) {
+		return false
+	}
+	diskRefusals.Inc(cfg.DiskFullAction)
+	log.Printf("job %s: %v", job.ID, diskErr)
+	if cfg.DiskFullAction != diskFullDefer {
+		return false
+	}
+	runAt := time.Now().Add(diskRetry).UTC()
+	job.RunAt = &runAt
+	job.DeferredReason = fmt.Sprintf("The server is running low on disk space, the download is retried at %s.", runAt.Format("Mon, 02 Jan 2006 15:04 MST"))
+	if err := enqueueJob(job); err != nil {
+		log.Printf("worker: deferring job %s for disk space: %v", job.ID, err)
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var archiveLinePattern = regexp.MustCompile(`^[a-z0-9_:]+ [^\s]+$`)
+
+func downloadArchiveKey(userID string) string {
+	return fmt.Sprintf("user:%s:download_archive", userID)
+}
+
+func archiveEntryID(extractor, videoID, videoURL string) string {
+	if extractor == "" {
+		extractor = urlSource(videoURL)
+	}
+	if extractor == "" || videoID == "" {
+		return ""
+	}
+	return strings.ToLower(extractor) + " " + videoID
+}
+
+func recordDownloadArchive(job *Job, videoData *VideoResponse) {
+	if job.UserID == "" {
+		return
+	}
+	if entry := archiveEntryID(videoData.Source, videoData.ID, job.URL); entry != "" {
+		rdb.SAdd(ctx, downloadArchiveKey(job.UserID), entry)
+	}
+}
+
+func inDownloadArchive(userID string, entry playlistEntry) bool {
+	id := archiveEntryID(entry.IEKey, entry.ID, entry.URL)
+	if userID == "" || id == "" {
+		return false
+	}
+	found, _ := rdb.SIsMember(ctx, downloadArchiveKey(userID), id).Result()
+	return found
+}
+
+func handleExportDownloadArchive(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	if user == nil {
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "API key required")
+		return
+	}
+	entries, err := rdb.SMembers(ctx, downloadArchiveKey(user.ID)).Result()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading download archive")
+		return
+	}
+	slices.Sort(entries)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="archive.txt"`)
+	for _, entry := range entries {
+		fmt.Fprintln(w, entry)
+	}
+}
+
+func handleImportDownloadArchive(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	if user == nil {
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "API key required")
+		return
+	}
+	var entries []string
+	invalid := []string{}
+	lines := bufio.NewScanner(http.MaxBytesReader(w, r.Body, maxImportSize))
+	for n := 1; lines.Scan(); n++ {
+		line := strings.TrimSpace(lines.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		extractor, id, _ := strings.Cut(line, " ")
+		line = strings.ToLower(extractor) + " " + strings.TrimSpace(id)
+		if !archiveLinePattern.MatchString(line) {
+			invalid = append(invalid, fmt.Sprintf("line %d", n))
+			continue
+		}
+		entries = append(entries, line)
+	}
+	if err := lines.Err(); err != nil {
+		writeBodyError(w, r, err, "Invalid archive file")
+		return
+	}
+
+	added := int64(0)
+	if len(entries) > 0 {
+		n, err := rdb.SAdd(ctx, downloadArchiveKey(user.ID), entries).Result()
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, codeInternal, "Error saving download archive")
+			return
+		}
+		added = n
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"imported":  added,
+		"duplicate": int64(len(entries)) - added,
+		"invalid":   invalid,
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	downloaderNative = "native"
+	downloaderAria2c = "aria2c"
+)
+
+type DownloadOptions struct {
+	ConcurrentFragments int               `json:"concurrent_fragments,omitempty"`
+	Downloader          string            `json:"downloader,omitempty"`
+	ExtractorArgs       map[string]string `json:"extractor_args,omitempty"`
+}
+
+func (o *DownloadOptions) normalize() error {
+	if o.ConcurrentFragments < 0 {
+		return errors.New("Invalid concurrent_fragments")
+	}
+	if o.ConcurrentFragments > cfg.MaxConcurrentFragments {
+		o.ConcurrentFragments = cfg.MaxConcurrentFragments
+	}
+	switch o.Downloader {
+	case "", downloaderNative, downloaderAria2c:
+	default:
+		return fmt.Errorf("Unsupported downloader %q", o.Downloader)
+	}
+	return validateExtractorArgs(o.ExtractorArgs)
+}
+
+func (o DownloadOptions) fragments() int {
+	if o.ConcurrentFragments == 0 {
+		return cfg.ConcurrentFragments
+	}
+	return o.ConcurrentFragments
+}
+
+func (o DownloadOptions) downloader() string {
+	if o.Downloader == "" {
+		return cfg.Downloader
+	}
+	return o.Downloader
+}
+
+func (o DownloadOptions) args(streaming bool, aria2cArgs ...string) []string {
+	fragments := o.fragments()
+
+	var args []string
+	if fragments > 1 {
+		args = append(args, "--concurrent-fragments", strconv.Itoa(fragments))
+	}
+	if o.downloader() == downloaderAria2c && !streaming {
+		n := max(fragments, 1)
+		downloaderArgs := fmt.Sprintf("aria2c:-x %d -s %d -k 1M", n, n)
+		if len(aria2cArgs) > 0 {
+			downloaderArgs += " " + strings.Join(aria2cArgs, " ")
+		}
+		args = append(args, "--downloader", downloaderAria2c, "--downloader-args", downloaderArgs)
+	}
+	return append(args, extractorArgs(o.ExtractorArgs)...)
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	encryptionMagic     = "ODLENC1\x00"
+	encryptionChunkSize = 64 << 10
+	encryptionNonceSize = 12
+)
+
+var errNoStorageKey = errors.New("artifact is encrypted with a key that is not configured")
+
+var encryptedArtifacts = metrics.Counter("odl_storage_encrypted_artifacts_total", "Artifacts encrypted before being added to the content store.")
+
+type storedFile interface {
+	io.ReadSeekCloser
+	Stat() (fs.FileInfo, error)
+}
+
+type storageKeyring struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+var keyring *storageKeyring
+
+func loadStorageKeys() (*storageKeyring, error) {
+	encoded := maps.Clone(cfg.StorageKeys)
+	if cfg.StorageKeyCommand != "" {
+		if cfg.StorageKeyID == "" {
+			return nil, errors.New("STORAGE_ENCRYPTION_KEY_COMMAND needs STORAGE_ENCRYPTION_KEY_ID")
+		}
+		out, err := command("/bin/sh", "-c", cfg.StorageKeyCommand).Output()
+		if err != nil {
+			return nil, fmt.Errorf("STORAGE_ENCRYPTION_KEY_COMMAND: %w", err)
+		}
+		encoded[cfg.StorageKeyID] = strings.TrimSpace(string(out))
+	}
+	if len(encoded) == 0 {
+		return nil, nil
+	}
+
+	kr := &storageKeyring{active: cfg.StorageKeyID, keys: make(map[string]cipher.AEAD)}
+	for id, value := range encoded {
+		if len(id) > 255 {
+			return nil, fmt.Errorf("key id %q is too long", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("key %q must be 32 bytes encoded as base64", id)
+		}
+		aead, err := newAESGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		kr.keys[id] = aead
+		if kr.active == "" && len(encoded) == 1 {
+			kr.active = id
+		}
+	}
+	if _, ok := kr.keys[kr.active]; !ok {
+		return nil, fmt.Errorf("STORAGE_ENCRYPTION_KEY_ID %q is not one of the configured keys", kr.active)
+	}
+	return kr, nil
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(base []byte, index int64) []byte {
+	nonce := bytes.Clone(base)
+	counter := binary.BigEndian.Uint64(nonce[4:]) ^ uint64(index)
+	binary.BigEndian.PutUint64(nonce[4:], counter)
+	return nonce
+}
+
+func encryptFile(path string) error {
+	return encryptFileWith(path, keyring.active, keyring.keys[keyring.active])
+}
+
+func encryptFileWith(path, keyID string, aead cipher.AEAD) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, encryptionNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	var header bytes.Buffer
+	header.WriteString(encryptionMagic)
+	header.WriteByte(byte(len(keyID)))
+	header.WriteString(keyID)
+	header.Write(nonce)
+	binary.Write(&header, binary.BigEndian, uint32(encryptionChunkSize))
+	binary.Write(&header, binary.BigEndian, uint64(info.Size()))
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".encrypting-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(header.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	buf := make([]byte, encryptionChunkSize)
+	sealed := make([]byte, 0, encryptionChunkSize+aead.Overhead())
+	for index := int64(0); ; index++ {
+		n, readErr := io.ReadFull(src, buf)
+		if n > 0 || index == 0 {
+			sealed = aead.Seal(sealed[:0], chunkNonce(nonce, index), buf[:n], header.Bytes())
+			if _, err := tmp.Write(sealed); err != nil {
+				tmp.Close()
+				return err
+			}
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			tmp.Close()
+			return readErr
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	os.Chmod(tmp.Name(), info.Mode().Perm())
+	return os.Rename(tmp.Name(), path)
+}
+
+type encryptedFile struct {
+	f         *os.File
+	aead      cipher.AEAD
+	header    []byte
+	nonce     []byte
+	chunkSize int64
+	size      int64
+	offset    int64
+	index     int64
+	plain     []byte
+}
+
+func openStoredFile(path, passphrase string) (storedFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	ef, encrypted, err := readEncryptionHeader(f, passphrase)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if encrypted {
+		return ef, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func readEncryptionHeader(f *os.File, passphrase string) (*encryptedFile, bool, error) {
+	magic := make([]byte, len(encryptionMagic))
+	if _, err := io.ReadFull(f, magic); err != nil || string(magic) != encryptionMagic {
+		return nil, false, nil
+	}
+	var idLen [1]byte
+	if _, err := io.ReadFull(f, idLen[:]); err != nil {
+		return nil, false, err
+	}
+	rest := make([]byte, int(idLen[0])+encryptionNonceSize+4+8)
+	if _, err := io.ReadFull(f, rest); err != nil {
+		return nil, false, err
+	}
+	keyID := string(rest[:idLen[0]])
+	var aead cipher.AEAD
+	var err error
+	switch {
+	case isPassphraseKeyID(keyID):
+		if aead, err = passphraseAEAD(keyID, passphrase); err != nil {
+			return nil, false, err
+		}
+	case keyring != nil:
+		aead = keyring.keys[keyID]
+	}
+	if aead == nil {
+		return nil, false, fmt.Errorf("%w: %q", errNoStorageKey, keyID)
+	}
+	fields := rest[idLen[0]:]
+	if binary.BigEndian.Uint32(fields[encryptionNonceSize:]) == 0 {
+		return nil, false, errors.New("encrypted artifact has an invalid header")
+	}
+	ef := &encryptedFile{
+		f:         f,
+		aead:      aead,
+		header:    slices.Concat([]byte(encryptionMagic), idLen[:], rest),
+		nonce:     fields[:encryptionNonceSize],
+		chunkSize: int64(binary.BigEndian.Uint32(fields[encryptionNonceSize:])),
+		size:      int64(binary.BigEndian.Uint64(fields[encryptionNonceSize+4:])),
+		index:     -1,
+	}
+	if isPassphraseKeyID(keyID) {
+		if err := ef.load(0); err != nil {
+			return nil, false, errWrongPassphrase
+		}
+	}
+	return ef, true, nil
+}
+
+func (e *encryptedFile) load(index int64) error {
+	if index == e.index {
+		return nil
+	}
+	n := min(e.chunkSize, e.size-index*e.chunkSize)
+	sealed := make([]byte, n+int64(e.aead.Overhead()))
+	pos := int64(len(e.header)) + index*(e.chunkSize+int64(e.aead.Overhead()))
+	if _, err := e.f.ReadAt(sealed, pos); err != nil {
+		return fmt.Errorf("reading encrypted chunk %d: %w", index, err)
+	}
+	plain, err := e.aead.Open(e.plain[:0], chunkNonce(e.nonce, index), sealed, e.header)
+	if err != nil {
+		e.index = -1
+		return fmt.Errorf("decrypting chunk %d: %w", index, err)
+	}
+	e.plain, e.index = plain, index
+	return nil
+}
+
+func (e *encryptedFile) Read(p []byte) (int, error) {
+	if e.offset >= e.size {
+		return 0, io.EOF
+	}
+	index := e.offset / e.chunkSize
+	if err := e.load(index); err != nil {
+		return 0, err
+	}
+	n := copy(p, e.plain[e.offset-index*e.chunkSize:])
+	e.offset += int64(n)
+	return n, nil
+}
+
+func (e *encryptedFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += e.offset
+	case io.SeekEnd:
+		offset += e.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before start of file")
+	}
+	e.offset = offset
+	return offset, nil
+}
+
+func (e *encryptedFile) Close() error {
+	return e.f.Close()
+}
+
+func (e *encryptedFile) Stat() (fs.FileInfo, error) {
+	info, err := e.f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return decryptedFileInfo{FileInfo: info, size: e.size}, nil
+}
+
+func (e *encryptedFile) Readdir(int) ([]fs.FileInfo, error) {
+	return nil, errors.New("not a directory")
+}
+
+func (e *encryptedFile) Write([]byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+type decryptedFileInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (i decryptedFileInfo) Size() int64 {
+	return i.size
+}
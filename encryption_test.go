@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptedFileRoundTripAndTampering(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	aead, err := newAESGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prevKeyring := keyring
+	keyring = &storageKeyring{active: "k1", keys: map[string]cipher.AEAD{"k1": aead}}
+	t.Cleanup(func() { keyring = prevKeyring })
+
+	plain := make([]byte, 2*encryptionChunkSize+1000)
+	rand.Read(plain)
+	path := filepath.Join(t.TempDir(), "video.mp4")
+	if err := os.WriteFile(path, plain, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := encryptFile(path); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headerLen := len(encryptionMagic) + 1 + len("k1") + encryptionNonceSize + 4 + 8
+	chunkLen := encryptionChunkSize + aead.Overhead()
+	chunk := func(data []byte, i int) []byte {
+		return data[headerLen+i*chunkLen : headerLen+(i+1)*chunkLen]
+	}
+
+	tests := []struct {
+		name   string
+		modify func([]byte) []byte
+		ok     bool
+	}{
+		{"intact", func(b []byte) []byte { return b }, true},
+		{"flipped byte", func(b []byte) []byte {
+			b[headerLen+chunkLen+10] ^= 1
+			return b
+		}, false},
+		{"changed size in header", func(b []byte) []byte {
+			b[headerLen-1]--
+			return b
+		}, false},
+		{"truncated last chunk", func(b []byte) []byte { return b[:len(b)-100] }, false},
+		{"last chunk dropped", func(b []byte) []byte { return b[:headerLen+2*chunkLen] }, false},
+		{"chunks reordered", func(b []byte) []byte {
+			first, second := bytes.Clone(chunk(b, 0)), bytes.Clone(chunk(b, 1))
+			copy(chunk(b, 0), second)
+			copy(chunk(b, 1), first)
+			return b
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tampered := filepath.Join(t.TempDir(), "video.mp4")
+			if err := os.WriteFile(tampered, tt.modify(bytes.Clone(sealed)), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			f, err := openStoredFile(tampered, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			got, err := io.ReadAll(f)
+			if tt.ok {
+				if err != nil || !bytes.Equal(got, plain) {
+					t.Fatalf("read %d bytes, err %v, want the original %d bytes", len(got), err, len(plain))
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("read %d bytes without an error", len(got))
+			}
+		})
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+)
+
+const (
+	errorReportQueue   = 100
+	errorReportTimeout = 5 * time.Second
+)
+
+var ytdlpFailureClasses = []struct {
+	class   string
+	markers []string
+}{
+	{"bot_check", []string{"Sign in to confirm you're not a bot", "Sign in to confirm you’re not a bot"}},
+	{"rate_limited", []string{"HTTP Error 429", "Too Many Requests"}},
+	{"forbidden", []string{"HTTP Error 403"}},
+	{"geo_blocked", []string{"not available in your country", "geo restriction", "geo-restricted"}},
+	{"login_required", []string{"Private video", "members-only", "This video is only available for registered users", "login required"}},
+	{"unavailable", []string{"Video unavailable", "This video has been removed", "HTTP Error 404"}},
+	{"format_unavailable", []string{"Requested format is not available"}},
+	{"unsupported_url", []string{"Unsupported URL"}},
+	{"network", []string{"timed out", "Connection reset", "Temporary failure in name resolution", "Unable to connect"}},
+	{"postprocessing", []string{"Postprocessing:", "ffmpeg"}},
+}
+
+type YTDLPError struct {
+	Class string
+	Err   error
+}
+
+func (e *YTDLPError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *YTDLPError) Unwrap() error {
+	return e.Err
+}
+
+type ErrorEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   float64           `json:"timestamp"`
+	Platform    string            `json:"platform"`
+	Level       string            `json:"level"`
+	Logger      string            `json:"logger,omitempty"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Message     string            `json:"message,omitempty"`
+	Exception   []errorException  `json:"exception,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Extra       map[string]any    `json:"extra,omitempty"`
+	Fingerprint []string          `json:"fingerprint,omitempty"`
+	Request     *errorRequest     `json:"request,omitempty"`
+}
+
+type errorException struct {
+	Type       string           `json:"type"`
+	Value      string           `json:"value"`
+	Stacktrace *errorStacktrace `json:"stacktrace,omitempty"`
+}
+
+type errorStacktrace struct {
+	Frames []errorFrame `json:"frames"`
+}
+
+type errorFrame struct {
+	Function string `json:"function"`
+	File     string `json:"filename"`
+	Line     int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+type errorRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+type errorReporter struct {
+	endpoint string
+	auth     string
+	dsn      string
+	events   chan *ErrorEvent
+	client   *http.Client
+}
+
+var reporter *errorReporter
+
+var (
+	ytdlpVersionOnce  sync.Once
+	ytdlpVersionValue string
+)
+
+func newErrorReporter(dsn string) (*errorReporter, error) {
+	if dsn == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil || u.Host == "" {
+		return nil, errors.New("invalid Sentry DSN")
+	}
+	path := strings.Trim(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	prefix, project := "", path
+	if i >= 0 {
+		prefix, project = "/"+path[:i], path[i+1:]
+	}
+	if project == "" {
+		return nil, errors.New("Sentry DSN without project ID")
+	}
+	r := &errorReporter{
+		endpoint: fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, prefix, project),
+		auth:     fmt.Sprintf("Sentry sentry_version=7, sentry_client=onetimedownload/1.0, sentry_key=%s", u.User.Username()),
+		dsn:      dsn,
+		events:   make(chan *ErrorEvent, errorReportQueue),
+		client:   &http.Client{Timeout: errorReportTimeout},
+	}
+	go r.run()
+	return r, nil
+}
+
+func (r *errorReporter) run() {
+	for event := range r.events {
+		if err := r.send(event); err != nil {
+			log.Printf("error reporting: %v", err)
+		}
+	}
+}
+
+func (r *errorReporter) send(event *ErrorEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	json.NewEncoder(&body).Encode(map[string]string{"event_id": event.EventID, "dsn": r.dsn, "sent_at": time.Now().UTC().Format(time.RFC3339)})
+	json.NewEncoder(&body).Encode(map[string]any{"type": "event", "length": len(payload)})
+	body.Write(payload)
+	body.WriteByte('\n')
+
+	req, err := http.NewRequest(http.MethodPost, r.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", r.auth)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s answered %s", r.endpoint, resp.Status)
+	}
+	return nil
+}
+
+func reportEvent(event *ErrorEvent) {
+	if reporter == nil {
+		return
+	}
+	event.EventID = utils.RandomID(16)
+	event.Timestamp = float64(time.Now().UnixMilli()) / 1000
+	event.Platform = "go"
+	event.ServerName = instanceID
+	event.Environment = cfg.SentryEnvironment
+	event.Release = cfg.SentryRelease
+	select {
+	case reporter.events <- event:
+	default:
+		log.Printf("error reporting: queue full, dropping event")
+	}
+}
+
+func stackFrames(skip int) *errorStacktrace {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var list []errorFrame
+	for {
+		frame, more := frames.Next()
+		list = append(list, errorFrame{
+			Function: frame.Function,
+			File:     frame.File,
+			Line:     frame.Line,
+			InApp:    strings.HasPrefix(frame.Function, "main."),
+		})
+		if !more {
+			break
+		}
+	}
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return &errorStacktrace{Frames: list}
+}
+
+func reportPanic(value any, r *http.Request) {
+	event := &ErrorEvent{
+		Level:     "fatal",
+		Logger:    "panic",
+		Exception: []errorException{{Type: "panic", Value: fmt.Sprint(value), Stacktrace: stackFrames(5)}},
+	}
+	if r != nil {
+		event.Request = &errorRequest{Method: r.Method, URL: r.URL.Path}
+		event.Tags = map[string]string{"request_id": requestID(r)}
+	}
+	reportEvent(event)
+}
+
+func reportServerError(r *http.Request, status int) {
+	if !cfg.SentryReport5xx {
+		return
+	}
+	route := r.Pattern
+	if route == "" {
+		route = r.URL.Path
+	}
+	reportEvent(&ErrorEvent{
+		Level:       "error",
+		Logger:      "http",
+		Message:     fmt.Sprintf("%s returned %d", route, status),
+		Tags:        map[string]string{"status": fmt.Sprint(status), "route": route, "request_id": requestID(r)},
+		Request:     &errorRequest{Method: r.Method, URL: r.URL.Path},
+		Fingerprint: []string{"http", route, fmt.Sprint(status)},
+	})
+}
+
+func classifyYTDLPFailure(output string) string {
+	for _, c := range ytdlpFailureClasses {
+		if _, ok := detectMarker(output, c.markers); ok {
+			return c.class
+		}
+	}
+	return "unknown"
+}
+
+func ytdlpVersion() string {
+	ytdlpVersionOnce.Do(func() {
+		output, err := command("yt-dlp", "--version").Output()
+		if err == nil {
+			ytdlpVersionValue = strings.TrimSpace(string(output))
+		}
+	})
+	return ytdlpVersionValue
+}
+
+func reportYTDLPFailure(stage, videoURL, extractor, output string, err error) {
+	if reporter == nil || !cfg.SentryReportYTDLP || err == nil {
+		return
+	}
+	class := classifyYTDLPFailure(output)
+	domain := ""
+	if u, err := url.Parse(videoURL); err == nil {
+		domain = u.Hostname()
+	}
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	reportEvent(&ErrorEvent{
+		Level:   "warning",
+		Logger:  "yt-dlp",
+		Message: fmt.Sprintf("yt-dlp %s failed: %s", stage, class),
+		Tags: map[string]string{
+			"stage":         stage,
+			"failure_class": class,
+			"domain":        domain,
+			"extractor":     strings.ToLower(extractor),
+			"ytdlp_version": ytdlpVersion(),
+		},
+		Extra:       map[string]any{"error": err.Error(), "last_line": redactSecrets(lines[len(lines)-1])},
+		Fingerprint: []string{"yt-dlp", stage, class, domain},
+	})
+}
+
+func withErrorReporting(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reporter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer func() {
+			if v := recover(); v != nil {
+				if v != http.ErrAbortHandler {
+					reportPanic(v, r)
+				}
+				panic(v)
+			}
+		}()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status >= 500 {
+			reportServerError(r, rec.status)
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	jobDurationsKey    = "jobs:durations"
+	jobDurationSamples = 100
+	defaultJobDuration = time.Minute
+	workerHeartbeat    = 10 * time.Second
+)
+
+type JobETA struct {
+	Position    int64     `json:"queue_position"`
+	Workers     int64     `json:"workers"`
+	WaitSeconds int64     `json:"wait_seconds"`
+	StartAt     time.Time `json:"estimated_start_at"`
+	FinishAt    time.Time `json:"estimated_finish_at"`
+}
+
+func workersKey(instance string) string {
+	return fmt.Sprintf("workers:%s", instance)
+}
+
+func runWorkerHeartbeat(n int) {
+	ticker := time.NewTicker(workerHeartbeat)
+	defer ticker.Stop()
+	for {
+		rdb.Set(ctx, workersKey(instanceID), n, 3*workerHeartbeat)
+		<-ticker.C
+	}
+}
+
+func recordJobDuration(job *Job) {
+	if job.StartedAt == nil || job.FinishedAt == nil || job.Status != JobCompleted {
+		return
+	}
+	d := job.FinishedAt.Sub(*job.StartedAt)
+	rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, jobDurationsKey, int64(d/time.Millisecond))
+		pipe.LTrim(ctx, jobDurationsKey, 0, jobDurationSamples-1)
+		return nil
+	})
+}
+
+func averageJobDuration() time.Duration {
+	samples, err := rdb.LRange(ctx, jobDurationsKey, 0, -1).Result()
+	if err != nil || len(samples) == 0 {
+		return defaultJobDuration
+	}
+	var total, n int64
+	for _, s := range samples {
+		if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+			total += ms
+			n++
+		}
+	}
+	if n == 0 {
+		return defaultJobDuration
+	}
+	return time.Duration(total/n) * time.Millisecond
+}
+
+func availableWorkers() int64 {
+	keys, err := scanKeys("workers:*")
+	if err != nil || len(keys) == 0 {
+		return int64(max(cfg.Workers, 1))
+	}
+	var total int64
+	for _, key := range keys {
+		if n, err := rdb.Get(ctx, key).Int64(); err == nil {
+			total += n
+		}
+	}
+	return max(total, 1)
+}
+
+func estimateJob(job *Job) *JobETA {
+	if job.Status != JobQueued {
+		return nil
+	}
+	ahead, err := queue.Ahead(job.ID)
+	if errors.Is(err, errPositionUnknown) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("eta: job %s: %v", job.ID, err)
+		return nil
+	}
+	workers := availableWorkers()
+	avg := averageJobDuration()
+	wait := time.Duration(ahead/workers)*avg + avg/2
+	now := time.Now().UTC()
+	return &JobETA{
+		Position:    ahead + 1,
+		Workers:     workers,
+		WaitSeconds: int64(wait.Seconds()),
+		StartAt:     now.Add(wait).Truncate(time.Second),
+		FinishAt:    now.Add(wait + avg).Truncate(time.Second),
+	}
+}
+
+func describeWait(eta *JobETA) string {
+	if eta == nil {
+		return ""
+	}
+	minutes := (eta.WaitSeconds + 59) / 60
+	if minutes <= 1 {
+		return "It should start within a minute."
+	}
+	return fmt.Sprintf("It is number %d in the queue and should start in about %d minutes.", eta.Position, minutes)
+}
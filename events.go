@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+)
+
+const eventBufferSize = 1024
+
+const (
+	EventJobCreated    = "job.created"
+	EventJobCompleted  = "job.completed"
+	EventJobFailed     = "job.failed"
+	EventJobCancelled  = "job.cancelled"
+	EventLinkConsumed  = "link.consumed"
+	EventQuotaExceeded = "quota.exceeded"
+)
+
+var (
+	eventsPublished = metrics.Counter("odl_events_published_total", "Events delivered to a sink.", "sink", "type")
+	eventsFailed    = metrics.Counter("odl_events_failed_total", "Events a sink failed to deliver.", "sink", "type")
+	eventsDropped   = metrics.Counter("odl_events_dropped_total", "Events dropped because the bus buffer was full.")
+)
+
+type Event struct {
+	ID     string    `json:"id"`
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Tenant string    `json:"tenant,omitempty"`
+	Data   any       `json:"data"`
+}
+
+type EventSink interface {
+	Name() string
+	Publish(event Event) error
+}
+
+type EventBus struct {
+	sinks []EventSink
+	queue chan Event
+}
+
+var events = &EventBus{}
+
+func newEventBus(names []string) (*EventBus, error) {
+	bus := &EventBus{queue: make(chan Event, eventBufferSize)}
+	for _, name := range names {
+		sink, err := newEventSink(name)
+		if err != nil {
+			return nil, fmt.Errorf("event sink %s: %w", name, err)
+		}
+		bus.sinks = append(bus.sinks, sink)
+	}
+	if len(bus.sinks) > 0 {
+		go bus.run()
+	}
+	return bus, nil
+}
+
+func (b *EventBus) run() {
+	for event := range b.queue {
+		for _, sink := range b.sinks {
+			if err := sink.Publish(event); err != nil {
+				log.Printf("events: %s: %s: %v", sink.Name(), event.Type, err)
+				eventsFailed.Inc(sink.Name(), event.Type)
+				continue
+			}
+			eventsPublished.Inc(sink.Name(), event.Type)
+		}
+	}
+}
+
+func (b *EventBus) Publish(eventType, tenant string, data any) {
+	if len(b.sinks) == 0 {
+		return
+	}
+	event := Event{
+		ID:     utils.RandomID(12),
+		Type:   eventType,
+		Time:   time.Now().UTC(),
+		Tenant: tenant,
+		Data:   data,
+	}
+	select {
+	case b.queue <- event:
+	default:
+		eventsDropped.Inc()
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+)
+
+func newEventSink(name string) (EventSink, error) {
+	switch name {
+	case "redis":
+		return redisEventSink{channel: cfg.EventRedisChannel}, nil
+	case "nats":
+		conn, err := nats.Connect(cfg.NATSURL, nats.Name("onetimedownload"))
+		if err != nil {
+			return nil, err
+		}
+		return &natsEventSink{conn: conn, subject: cfg.EventNATSSubject}, nil
+	case "kafka":
+		if len(cfg.KafkaBrokers) == 0 {
+			return nil, errors.New("KAFKA_BROKERS is not set")
+		}
+		return &kafkaEventSink{writer: &kafka.Writer{
+			Addr:         kafka.TCP(cfg.KafkaBrokers...),
+			Topic:        cfg.EventKafkaTopic,
+			Balancer:     &kafka.Hash{},
+			BatchTimeout: 100 * time.Millisecond,
+		}}, nil
+	case "webhook":
+		if len(cfg.EventWebhooks) == 0 {
+			return nil, errors.New("EVENT_WEBHOOKS is not set")
+		}
+		return webhookEventSink{urls: cfg.EventWebhooks}, nil
+	default:
+		return nil, errors.New("unknown sink")
+	}
+}
+
+type redisEventSink struct {
+	channel string
+}
+
+func (s redisEventSink) Name() string { return "redis" }
+
+func (s redisEventSink) Publish(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return rdb.Publish(ctx, s.channel, data).Err()
+}
+
+type natsEventSink struct {
+	conn    *nats.Conn
+	subject string
+}
+
+func (s *natsEventSink) Name() string { return "nats" }
+
+func (s *natsEventSink) Publish(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return s.conn.Publish(s.subject+"."+event.Type, data)
+}
+
+type kafkaEventSink struct {
+	writer *kafka.Writer
+}
+
+func (s *kafkaEventSink) Name() string { return "kafka" }
+
+func (s *kafkaEventSink) Publish(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	writeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	return s.writer.WriteMessages(writeCtx, kafka.Message{
+		Key:     []byte(event.Type),
+		Value:   data,
+		Headers: []kafka.Header{{Key: "type", Value: []byte(event.Type)}},
+	})
+}
+
+type webhookEventSink struct {
+	urls []string
+}
+
+func (s webhookEventSink) Name() string { return "webhook" }
+
+func (s webhookEventSink) Publish(event Event) error {
+	var errs []error
+	for _, u := range s.urls {
+		if err := sendWebhook(eventWebhookClient, u, event); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", u, err))
+		}
+	}
+	return errors.Join(errs...)
+}
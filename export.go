@@ -0,0 +1,276 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	stateExportVersion = 1
+	maxImportSize      = 64 << 20
+)
+
+type SubscriptionExport struct {
+	Subscription
+	Seen []string `json:"seen,omitempty"`
+}
+
+type StateExport struct {
+	Version       int                  `json:"version"`
+	ExportedAt    time.Time            `json:"exported_at"`
+	Users         []UserRecord         `json:"users"`
+	Links         []LinkRecord         `json:"links"`
+	Subscriptions []SubscriptionExport `json:"subscriptions"`
+	Settings      map[string]any       `json:"settings,omitempty"`
+
+	DownloadArchives map[string][]string         `json:"download_archives,omitempty"`
+	WatchLater       map[string]WatchLaterExport `json:"watch_later,omitempty"`
+}
+
+type WatchLaterExport struct {
+	WatchLaterSettings
+	Items []*WatchLaterItem `json:"items"`
+}
+
+type ImportSummary struct {
+	Users         int `json:"users"`
+	Links         int `json:"links"`
+	Subscriptions int `json:"subscriptions"`
+	Skipped       int `json:"skipped"`
+}
+
+func settingsSnapshot() map[string]any {
+	data, _ := json.Marshal(cfg)
+	var settings map[string]any
+	json.Unmarshal(data, &settings)
+	return settings
+}
+
+func exportState() (*StateExport, error) {
+	users, err := jobstore.ExportUsers()
+	if err != nil {
+		return nil, fmt.Errorf("users: %w", err)
+	}
+	links, err := jobstore.ExportLinks()
+	if err != nil {
+		return nil, fmt.Errorf("links: %w", err)
+	}
+	subs, err := listAllSubscriptions()
+	if err != nil {
+		return nil, fmt.Errorf("subscriptions: %w", err)
+	}
+
+	state := &StateExport{
+		Version:       stateExportVersion,
+		ExportedAt:    time.Now().UTC(),
+		Users:         users,
+		Links:         links,
+		Subscriptions: make([]SubscriptionExport, 0, len(subs)),
+		Settings:      settingsSnapshot(),
+	}
+	for _, sub := range subs {
+		seen, _ := rdb.SMembers(ctx, subscriptionSeenKey(sub.ID)).Result()
+		state.Subscriptions = append(state.Subscriptions, SubscriptionExport{Subscription: *sub, Seen: seen})
+	}
+	state.DownloadArchives = make(map[string][]string)
+	for _, user := range users {
+		if entries, _ := rdb.SMembers(ctx, downloadArchiveKey(user.ID)).Result(); len(entries) > 0 {
+			state.DownloadArchives[user.ID] = entries
+		}
+	}
+	state.WatchLater = make(map[string]WatchLaterExport)
+	for _, user := range users {
+		if items, _ := listWatchLater(user.ID); len(items) > 0 {
+			state.WatchLater[user.ID] = WatchLaterExport{WatchLaterSettings: getWatchLaterSettings(user.ID), Items: items}
+		}
+	}
+	return state, nil
+}
+
+func importState(state *StateExport) (*ImportSummary, error) {
+	if state.Version != stateExportVersion {
+		return nil, fmt.Errorf("unsupported export version %d", state.Version)
+	}
+
+	summary := &ImportSummary{}
+	for _, rec := range state.Users {
+		err := jobstore.ImportUser(rec)
+		if errors.Is(err, errUserExists) {
+			summary.Skipped++
+			continue
+		}
+		if err != nil {
+			return summary, fmt.Errorf("user %s: %w", rec.Name, err)
+		}
+		summary.Users++
+	}
+	for _, rec := range state.Links {
+		if err := jobstore.ImportLink(rec); err != nil {
+			return summary, fmt.Errorf("link: %w", err)
+		}
+		summary.Links++
+	}
+	for _, exported := range state.Subscriptions {
+		sub := exported.Subscription
+		if _, err := getSubscription(sub.ID); err == nil {
+			summary.Skipped++
+			continue
+		}
+		if err := saveSubscription(&sub); err != nil {
+			return summary, fmt.Errorf("subscription %s: %w", sub.ID, err)
+		}
+		rdb.SAdd(ctx, userSubscriptionsKey(sub.UserID), sub.ID)
+		if len(exported.Seen) > 0 {
+			rdb.SAdd(ctx, subscriptionSeenKey(sub.ID), exported.Seen)
+		}
+		rdb.ZAdd(ctx, subscriptionsDueKey, redis.Z{Score: float64(sub.NextRunAt.Unix()), Member: sub.ID})
+		summary.Subscriptions++
+	}
+	for userID, entries := range state.DownloadArchives {
+		if len(entries) > 0 {
+			rdb.SAdd(ctx, downloadArchiveKey(userID), entries)
+		}
+	}
+	for userID, list := range state.WatchLater {
+		for _, item := range list.Items {
+			if data, err := json.Marshal(item); err == nil {
+				rdb.HSetNX(ctx, watchLaterKey(userID), item.ID, data)
+			}
+		}
+		if err := saveWatchLaterSettings(userID, list.WatchLaterSettings); err != nil {
+			return summary, fmt.Errorf("watch later %s: %w", userID, err)
+		}
+	}
+	return summary, nil
+}
+
+type stateFile struct {
+	Name string
+	Data any
+}
+
+func stateFiles(state *StateExport) []stateFile {
+	return []stateFile{
+		{"manifest.json", map[string]any{"version": state.Version, "exported_at": state.ExportedAt}},
+		{"users.json", state.Users},
+		{"links.json", state.Links},
+		{"subscriptions.json", state.Subscriptions},
+		{"settings.json", state.Settings},
+	}
+}
+
+func writeStateArchive(w io.Writer, state *StateExport) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, file := range stateFiles(state) {
+		data, err := json.MarshalIndent(file.Data, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(&tar.Header{Name: file.Name, Mode: 0o600, Size: int64(len(data)), ModTime: state.ExportedAt}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func readStateArchive(r io.Reader) (*StateExport, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	state := &StateExport{}
+	targets := map[string]any{
+		"users.json":         &state.Users,
+		"links.json":         &state.Links,
+		"subscriptions.json": &state.Subscriptions,
+		"settings.json":      &state.Settings,
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Name == "manifest.json" {
+			if err := json.NewDecoder(tr).Decode(state); err != nil {
+				return nil, fmt.Errorf("%s: %w", header.Name, err)
+			}
+			continue
+		}
+		if target, ok := targets[header.Name]; ok {
+			if err := json.NewDecoder(tr).Decode(target); err != nil {
+				return nil, fmt.Errorf("%s: %w", header.Name, err)
+			}
+		}
+	}
+	return state, nil
+}
+
+func handleExportState(w http.ResponseWriter, r *http.Request) {
+	state, err := exportState()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Error exporting state: %v", err))
+		return
+	}
+
+	name := "onetimedownload-" + state.ExportedAt.Format("20060102-150405")
+	if r.URL.Query().Get("format") == "tar" {
+		var buf bytes.Buffer
+		if err := writeStateArchive(&buf, state); err != nil {
+			writeError(w, r, http.StatusInternalServerError, codeInternal, "Error writing archive")
+			return
+		}
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.tar.gz"`, name))
+		w.Write(buf.Bytes())
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, name))
+	writeJSON(w, http.StatusOK, state)
+}
+
+func handleImportState(w http.ResponseWriter, r *http.Request) {
+	body := bufio.NewReader(http.MaxBytesReader(w, r.Body, maxImportSize))
+
+	var state *StateExport
+	if magic, _ := body.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		var err error
+		if state, err = readStateArchive(body); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("Invalid archive: %v", err))
+			return
+		}
+	} else {
+		state = &StateExport{}
+		if err := json.NewDecoder(body).Decode(state); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid JSON body")
+			return
+		}
+	}
+
+	summary, err := importState(state)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Error importing state: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
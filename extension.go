@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+var extensionSchemes = []string{"chrome-extension://", "moz-extension://", "safari-web-extension://"}
+
+func extensionOriginAllowed(origin string) bool {
+	if len(cfg.ExtensionOrigins) > 0 {
+		return slices.Contains(cfg.ExtensionOrigins, origin)
+	}
+	for _, scheme := range extensionSchemes {
+		if strings.HasPrefix(origin, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+func withExtensionCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" && extensionOriginAllowed(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, X-API-Key, Content-Type")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.Header().Add("Vary", "Origin")
+		}
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func handleExtensionResolve(w http.ResponseWriter, r *http.Request) {
+	videoURL := r.URL.Query().Get("url")
+	if !isAllowedVideoURL(r, videoURL) {
+		writeError(w, r, http.StatusBadRequest, codeUnsupportedURL, "Invalid or unsupported video URL")
+		return
+	}
+
+	videoData, err := fetchVideoMetaData(videoURL)
+	if err != nil {
+		writeFetchError(w, r, err)
+		return
+	}
+	if err := checkDownloadable(videoData); err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, codeNotDownloadable, err.Error())
+		return
+	}
+	if len(videoData.Medias) == 0 {
+		writeError(w, r, http.StatusUnprocessableEntity, codeNotDownloadable, "No downloadable formats found for this video")
+		return
+	}
+
+	ticket, err := issueDownloadTicket(requestTenant(r), videoData)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error creating download link")
+		return
+	}
+	media := videoData.BestCombinedMedia()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"title":        videoData.Title,
+		"author":       videoData.Author,
+		"thumbnail":    videoData.Thumbnail,
+		"duration":     videoData.Duration,
+		"format":       media,
+		"download_url": downloadLink(ticket, videoData, media.FormatID),
+	})
+}
+
+func handleExtensionQueue(w http.ResponseWriter, r *http.Request) {
+	var req jobRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	tenant := requestTenant(r)
+	if err := req.validate(tenant); err != nil {
+		writeError(w, r, http.StatusBadRequest, validationErrorCode(err), err.Error())
+		return
+	}
+
+	job, err := createJob(req, tenant, requestUser(r), clientIP(r))
+	if errors.Is(err, errQuotaExceeded) {
+		writeError(w, r, http.StatusTooManyRequests, codeQuotaExceeded, "Daily job quota exceeded")
+		return
+	}
+	if writeStorageQuotaError(w, r, err) {
+		return
+	}
+	if writeCooldownError(w, r, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error creating job")
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]any{
+		"job":        job,
+		"status_url": cfg.PublicURL + "/api/v1/jobs/" + job.ID,
+	})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	maxExtractorArgs     = 16
+	maxExtractorArgValue = 1024
+)
+
+var (
+	extractorArgKeyRegex   = regexp.MustCompile(`^[a-z0-9_-]+:[a-z0-9_]+$`)
+	extractorArgValueRegex = regexp.MustCompile(`^[A-Za-z0-9_.,+/=-]+$`)
+)
+
+type extractorArgSet struct {
+	order []string
+	args  map[string][]string
+}
+
+func (s *extractorArgSet) set(extractor, key, value string) {
+	if s.args == nil {
+		s.args = make(map[string][]string)
+	}
+	if _, ok := s.args[extractor]; !ok {
+		s.order = append(s.order, extractor)
+	}
+	pairs := slices.DeleteFunc(s.args[extractor], func(pair string) bool {
+		return strings.HasPrefix(pair, key+"=")
+	})
+	s.args[extractor] = append(pairs, key+"="+value)
+}
+
+func (s *extractorArgSet) parse(raw string) {
+	extractor, rest, ok := strings.Cut(raw, ":")
+	if !ok {
+		return
+	}
+	for _, pair := range strings.Split(rest, ";") {
+		if key, value, ok := strings.Cut(pair, "="); ok && key != "" {
+			s.set(strings.ToLower(extractor), key, value)
+		}
+	}
+}
+
+func (s *extractorArgSet) flags() []string {
+	var flags []string
+	for _, extractor := range s.order {
+		flags = append(flags, "--extractor-args", extractor+":"+strings.Join(s.args[extractor], ";"))
+	}
+	return flags
+}
+
+func parseExtractorArg(raw string) (string, string, error) {
+	key, value, ok := strings.Cut(raw, "=")
+	if !ok {
+		return "", "", fmt.Errorf("Invalid extractor argument %q, use extractor:key=value", raw)
+	}
+	return strings.ToLower(key), value, nil
+}
+
+func validateExtractorArgs(args map[string]string) error {
+	if len(args) > maxExtractorArgs {
+		return fmt.Errorf("At most %d extractor arguments can be given", maxExtractorArgs)
+	}
+	for key, value := range args {
+		if !extractorArgKeyRegex.MatchString(key) || !slices.Contains(cfg.ExtractorArgsAllowlist, key) {
+			return fmt.Errorf("Extractor argument %q is not allowed", key)
+		}
+		if len(value) > maxExtractorArgValue || !extractorArgValueRegex.MatchString(value) {
+			return fmt.Errorf("Invalid value for extractor argument %q", key)
+		}
+	}
+	return nil
+}
+
+func extractorArgs(requested map[string]string) []string {
+	var set extractorArgSet
+	for _, raw := range cfg.ExtractorArgs {
+		set.parse(raw)
+	}
+	if cfg.YouTubePOToken != "" {
+		set.set("youtube", "po_token", cfg.YouTubePOToken)
+	}
+	if cfg.YouTubeVisitorData != "" {
+		set.set("youtube", "visitor_data", cfg.YouTubeVisitorData)
+	}
+	if cfg.POTProviderURL != "" {
+		set.set("youtubepot-bgutilhttp", "base_url", cfg.POTProviderURL)
+	}
+
+	keys := make([]string, 0, len(requested))
+	for key := range requested {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		extractor, name, _ := strings.Cut(key, ":")
+		set.set(extractor, name, requested[key])
+	}
+	return set.flags()
+}
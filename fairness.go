@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const runningJobLease = 2 * time.Hour
+
+func submitterHash(ip string) string {
+	if ip == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(ip))
+	return hex.EncodeToString(sum[:8])
+}
+
+func jobOwner(job *Job) string {
+	switch {
+	case job.UserID != "":
+		return "user:" + job.UserID
+	case job.Submitter != "":
+		return "ip:" + job.Submitter
+	default:
+		return "tenant:" + job.Tenant
+	}
+}
+
+func runningJobsKey(owner string) string {
+	return fmt.Sprintf("jobs:running:%s", owner)
+}
+
+func runningJobs(owner string) int64 {
+	key := runningJobsKey(owner)
+	rdb.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(time.Now().Add(-runningJobLease).Unix(), 10))
+	n, _ := rdb.ZCard(ctx, key).Result()
+	return n
+}
+
+func startOwnerRun(job *Job) {
+	key := runningJobsKey(jobOwner(job))
+	rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(time.Now().Unix()), Member: job.ID})
+		pipe.Expire(ctx, key, runningJobLease)
+		return nil
+	})
+}
+
+func endOwnerRun(job *Job) {
+	rdb.ZRem(ctx, runningJobsKey(jobOwner(job)), job.ID)
+}
+
+type fairScheduler struct {
+	deferred map[string]bool
+}
+
+func (s *fairScheduler) shouldDefer(job *Job) bool {
+	if cfg.MaxRunningPerOwner <= 0 {
+		return false
+	}
+	if s.deferred[job.ID] || runningJobs(jobOwner(job)) < cfg.MaxRunningPerOwner {
+		s.deferred = nil
+		return false
+	}
+	if s.deferred == nil {
+		s.deferred = make(map[string]bool)
+	}
+	s.deferred[job.ID] = true
+	return true
+}
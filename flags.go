@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	flagAudio         = "audio"
+	flagBundles       = "bundles"
+	flagSubscriptions = "subscriptions"
+	flagSchedules     = "schedules"
+	flagBatches       = "batches"
+	flagExtension     = "extension"
+	flagWatchLater    = "watch_later"
+
+	extractorFlagPrefix = "extractor."
+	featureFlagsKey     = "feature_flags"
+	flagSyncInterval    = 15 * time.Second
+)
+
+var knownFlags = map[string]string{
+	flagAudio:         "Audio-only formats and audio bundle outputs",
+	flagBundles:       "Zip bundles with several outputs",
+	flagSubscriptions: "Channel and playlist subscriptions",
+	flagSchedules:     "Scheduling downloads of upcoming premieres",
+	flagBatches:       "Batch metadata requests",
+	flagExtension:     "Browser extension API",
+	flagWatchLater:    "Watch-later lists and their auto-download queue",
+}
+
+var extractorFlagRegex = regexp.MustCompile(`^extractor\.[a-z0-9-]{1,63}$`)
+
+var flagOverrides atomic.Pointer[map[string]bool]
+
+type FeatureFlag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Enabled     bool   `json:"enabled"`
+	Default     bool   `json:"default"`
+	Overridden  bool   `json:"overridden"`
+}
+
+func validFlagName(name string) bool {
+	_, known := knownFlags[name]
+	return known || extractorFlagRegex.MatchString(name)
+}
+
+func extractorFlag(videoURL string) string {
+	return extractorFlagPrefix + urlSource(videoURL)
+}
+
+func flagDefault(name string) bool {
+	if v, ok := cfg.FeatureFlags[name]; ok {
+		return v
+	}
+	return true
+}
+
+func flagEnabled(name string) bool {
+	if overrides := flagOverrides.Load(); overrides != nil {
+		if v, ok := (*overrides)[name]; ok {
+			return v
+		}
+	}
+	return flagDefault(name)
+}
+
+func describeFlag(name string) FeatureFlag {
+	f := FeatureFlag{Name: name, Description: knownFlags[name], Enabled: flagEnabled(name), Default: flagDefault(name)}
+	if overrides := flagOverrides.Load(); overrides != nil {
+		_, f.Overridden = (*overrides)[name]
+	}
+	return f
+}
+
+func syncFlags() error {
+	fields, err := rdb.HGetAll(ctx, featureFlagsKey).Result()
+	if err != nil {
+		return err
+	}
+	overrides := make(map[string]bool, len(fields))
+	for name, value := range fields {
+		if v, err := strconv.ParseBool(value); err == nil {
+			overrides[name] = v
+		}
+	}
+	flagOverrides.Store(&overrides)
+	return nil
+}
+
+func runFlagSync() {
+	ticker := time.NewTicker(flagSyncInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := syncFlags(); err != nil {
+			log.Printf("feature flags: %v", err)
+		}
+	}
+}
+
+func requireFlag(name string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !flagEnabled(name) {
+			writeError(w, r, http.StatusForbidden, codeFeatureDisabled, fmt.Sprintf("The %s feature is disabled on this server", name))
+			return
+		}
+		next(w, r)
+	}
+}
+
+func flagNames() []string {
+	seen := make(map[string]bool)
+	for name := range knownFlags {
+		seen[name] = true
+	}
+	for name := range cfg.FeatureFlags {
+		seen[name] = true
+	}
+	if overrides := flagOverrides.Load(); overrides != nil {
+		for name := range *overrides {
+			seen[name] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func flagStates() map[string]bool {
+	states := make(map[string]bool)
+	for _, name := range flagNames() {
+		states[name] = flagEnabled(name)
+	}
+	return states
+}
+
+func handleListFlags(w http.ResponseWriter, r *http.Request) {
+	names := flagNames()
+	list := make([]FeatureFlag, 0, len(names))
+	for _, name := range names {
+		list = append(list, describeFlag(name))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"flags": list})
+}
+
+func handleSetFlag(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(r.PathValue("name"))
+	if !validFlagName(name) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Unknown feature flag")
+		return
+	}
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Enabled == nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "enabled is required")
+		return
+	}
+	if err := rdb.HSet(ctx, featureFlagsKey, name, strconv.FormatBool(*req.Enabled)).Err(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error saving feature flag")
+		return
+	}
+	syncFlags()
+	log.Printf("feature flag %s set to %t", name, *req.Enabled)
+	writeJSON(w, http.StatusOK, describeFlag(name))
+}
+
+func handleResetFlag(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(r.PathValue("name"))
+	if !validFlagName(name) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Unknown feature flag")
+		return
+	}
+	if err := rdb.HDel(ctx, featureFlagsKey, name).Err(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error resetting feature flag")
+		return
+	}
+	syncFlags()
+	writeJSON(w, http.StatusOK, describeFlag(name))
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+)
+
+type FormatCell struct {
+	FormatID          string  `json:"format_id"`
+	Ext               string  `json:"ext"`
+	Resolution        string  `json:"resolution"`
+	Width             int     `json:"width"`
+	Height            int     `json:"height"`
+	VideoCodec        string  `json:"vcodec,omitempty"`
+	AudioCodec        string  `json:"acodec,omitempty"`
+	FPS               float64 `json:"fps,omitempty"`
+	Filesize          int64   `json:"filesize,omitempty"`
+	FilesizeEstimated bool    `json:"filesize_estimated,omitempty"`
+}
+
+type FormatMatrix struct {
+	URL         string       `json:"url"`
+	Title       string       `json:"title,omitempty"`
+	Resolutions []string     `json:"resolutions"`
+	Codecs      []string     `json:"codecs"`
+	Formats     []FormatCell `json:"formats"`
+	Error       string       `json:"error,omitempty"`
+}
+
+func resolutionLabel(height int) string {
+	if height <= 0 {
+		return "audio"
+	}
+	return fmt.Sprintf("%dp", height)
+}
+
+func newFormatMatrix(ytdlpData *YTDLPOutput) *FormatMatrix {
+	matrix := &FormatMatrix{
+		URL:         ytdlpData.WebpageURL,
+		Title:       ytdlpData.Title,
+		Resolutions: []string{},
+		Codecs:      []string{},
+		Formats:     []FormatCell{},
+	}
+
+	seenResolution := map[string]bool{}
+	seenCodec := map[string]bool{}
+	for _, f := range ytdlpData.Formats {
+		if f.FormatID == "" {
+			continue
+		}
+		if f.Vcodec == "none" && f.Acodec == "none" {
+			continue
+		}
+
+		cell := FormatCell{
+			FormatID:   f.FormatID,
+			Ext:        f.Ext,
+			Resolution: resolutionLabel(f.Height),
+			Width:      f.Width,
+			Height:     f.Height,
+			VideoCodec: utils.NormalizeCodec(f.Vcodec),
+			AudioCodec: utils.NormalizeCodec(f.Acodec),
+			FPS:        f.FPS,
+			Filesize:   f.Filesize,
+		}
+		if cell.Filesize == 0 && f.FilesizeApprox > 0 {
+			cell.Filesize = f.FilesizeApprox
+			cell.FilesizeEstimated = true
+		}
+		matrix.Formats = append(matrix.Formats, cell)
+
+		if !seenResolution[cell.Resolution] {
+			seenResolution[cell.Resolution] = true
+			matrix.Resolutions = append(matrix.Resolutions, cell.Resolution)
+		}
+		codec := cell.VideoCodec
+		if codec == "" {
+			codec = cell.AudioCodec
+		}
+		if codec != "" && !seenCodec[codec] {
+			seenCodec[codec] = true
+			matrix.Codecs = append(matrix.Codecs, codec)
+		}
+	}
+
+	sort.SliceStable(matrix.Formats, func(i, j int) bool {
+		a, b := matrix.Formats[i], matrix.Formats[j]
+		if a.Height != b.Height {
+			return a.Height > b.Height
+		}
+		if a.FPS != b.FPS {
+			return a.FPS > b.FPS
+		}
+		return a.Filesize > b.Filesize
+	})
+	heights := map[string]int{}
+	for _, f := range matrix.Formats {
+		heights[f.Resolution] = f.Height
+	}
+	sort.SliceStable(matrix.Resolutions, func(i, j int) bool {
+		return heights[matrix.Resolutions[i]] > heights[matrix.Resolutions[j]]
+	})
+	sort.Strings(matrix.Codecs)
+	return matrix
+}
+
+func mediaPreference(m Media) int {
+	score := 0
+	if m.Ext == "mp4" || m.Ext == "m4a" {
+		score += 2
+	}
+	if codec := utils.NormalizeCodec(m.Vcodec); codec == "h264" || (m.AudioOnly() && utils.NormalizeCodec(m.Acodec) == "aac") {
+		score += 1
+	}
+	return score
+}
+
+func betterMedia(a, b Media) bool {
+	if pa, pb := mediaPreference(a), mediaPreference(b); pa != pb {
+		return pa > pb
+	}
+	if a.FPS != b.FPS {
+		return a.FPS > b.FPS
+	}
+	return a.Bitrate > b.Bitrate
+}
+
+func dedupeMedias(medias []Media) []Media {
+	var video, audio []Media
+	videoIndex := map[int]int{}
+	audioIndex := map[string]int{}
+	for _, m := range medias {
+		if m.Ext == "mhtml" || strings.Contains(m.Quality, "storyboard") {
+			continue
+		}
+		if m.AudioOnly() {
+			if i, ok := audioIndex[m.Ext]; ok {
+				if betterMedia(m, audio[i]) {
+					audio[i] = m
+				}
+				continue
+			}
+			audioIndex[m.Ext] = len(audio)
+			audio = append(audio, m)
+			continue
+		}
+		if i, ok := videoIndex[m.Height]; ok {
+			if betterMedia(m, video[i]) {
+				video[i] = m
+			}
+			continue
+		}
+		videoIndex[m.Height] = len(video)
+		video = append(video, m)
+	}
+
+	sort.SliceStable(video, func(i, j int) bool { return video[i].Height > video[j].Height })
+	sort.SliceStable(audio, func(i, j int) bool { return betterMedia(audio[i], audio[j]) })
+	return append(video, audio...)
+}
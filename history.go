@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var historyColumns = []string{"id", "title", "url", "format", "size_bytes", "status", "created_at", "finished_at", "download_incomplete"}
+
+type HistoryEntry struct {
+	ID         string     `json:"id"`
+	Title      string     `json:"title"`
+	URL        string     `json:"url"`
+	Format     string     `json:"format"`
+	SizeBytes  int64      `json:"size_bytes"`
+	Status     JobStatus  `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	DownloadIncomplete bool `json:"download_incomplete,omitempty"`
+}
+
+func historyEntry(job *Job) HistoryEntry {
+	entry := HistoryEntry{
+		ID:         job.ID,
+		Title:      job.Title,
+		URL:        job.URL,
+		Format:     job.FormatID,
+		Status:     job.Status,
+		CreatedAt:  job.CreatedAt,
+		FinishedAt: job.FinishedAt,
+	}
+	if job.LastDownload != nil {
+		entry.DownloadIncomplete = job.LastDownload.Incomplete
+	}
+	if job.ArtifactID != "" {
+		if artifact, err := getArtifact(job.ArtifactID); err == nil {
+			entry.SizeBytes = artifact.Size
+		}
+	}
+	if entry.SizeBytes == 0 && job.Progress != nil {
+		entry.SizeBytes = max(job.Progress.TotalBytes, job.Progress.DownloadedBytes)
+	}
+	return entry
+}
+
+func (e HistoryEntry) record() []string {
+	finished := ""
+	if e.FinishedAt != nil {
+		finished = e.FinishedAt.UTC().Format(time.RFC3339)
+	}
+	return []string{
+		e.ID,
+		csvSafe(e.Title),
+		csvSafe(e.URL),
+		csvSafe(e.Format),
+		strconv.FormatInt(e.SizeBytes, 10),
+		string(e.Status),
+		e.CreatedAt.UTC().Format(time.RFC3339),
+		finished,
+		strconv.FormatBool(e.DownloadIncomplete),
+	}
+}
+
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func historyFormat(r *http.Request) string {
+	if v := r.URL.Query().Get("format"); v != "" {
+		return strings.ToLower(v)
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept)); err == nil && mediaType == "text/csv" {
+			return "csv"
+		}
+	}
+	return "json"
+}
+
+func handleExportHistory(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	if user == nil {
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "API key required")
+		return
+	}
+	format := historyFormat(r)
+	if format != "csv" && format != "json" {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid format, use csv or json")
+		return
+	}
+	q, ok := parseListQuery(w, r)
+	if !ok {
+		return
+	}
+	q.Limit = maxPageSize
+
+	jobs, err := jobstore.ListJobs(user.ID, q)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error listing jobs")
+		return
+	}
+
+	filename := fmt.Sprintf("history-%s.%s", time.Now().UTC().Format("20060102"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Cache-Control", "no-store")
+	if cfg.JobArchiveAfter > 0 {
+		w.Header().Set("X-History-Compacted-Before", time.Now().Add(-cfg.JobArchiveAfter).UTC().Format(time.RFC3339))
+	}
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	flusher, _ := w.(http.Flusher)
+
+	var out *csv.Writer
+	if format == "csv" {
+		out = csv.NewWriter(w)
+		out.Write(historyColumns)
+	} else {
+		fmt.Fprint(w, "[")
+	}
+	count := 0
+	for {
+		page := jobs[:min(len(jobs), q.Limit)]
+		for _, job := range page {
+			entry := historyEntry(job)
+			if out != nil {
+				out.Write(entry.record())
+			} else {
+				if count > 0 {
+					fmt.Fprint(w, ",")
+				}
+				line, _ := json.Marshal(entry)
+				fmt.Fprintf(w, "\n%s", line)
+			}
+			count++
+		}
+		if out != nil {
+			out.Flush()
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if len(jobs) <= q.Limit {
+			break
+		}
+		last := page[len(page)-1]
+		q.cursor = &pageCursor{at: last.CreatedAt.Unix(), id: last.ID}
+		if jobs, err = jobstore.ListJobs(user.ID, q); err != nil {
+			log.Printf("history export for user %s stopped after %d jobs: %v", user.ID, count, err)
+			break
+		}
+	}
+	if out == nil {
+		fmt.Fprint(w, "\n]\n")
+	}
+}
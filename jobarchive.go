@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	jobsFinishedKey  = "jobs:finished"
+	jobStatsKey      = "jobs:stats"
+	archiveLockName  = "job_archive"
+	archiveLockTTL   = 5 * time.Minute
+	archiveBatchSize = 500
+	maxArchivedError = 200
+	defaultStatsDays = 30
+	maxStatsDays     = 366
+)
+
+type JobSummary struct {
+	ID         string    `json:"id"`
+	Tenant     string    `json:"tenant,omitempty"`
+	UserID     string    `json:"user_id,omitempty"`
+	Host       string    `json:"host"`
+	Title      string    `json:"title,omitempty"`
+	Status     JobStatus `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Bytes      int64     `json:"bytes,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Archived   bool      `json:"archived"`
+}
+
+func jobArchiveKey(id string) string {
+	return fmt.Sprintf("job_archive:%s", id)
+}
+
+func jobStatsDayKey(day time.Time) string {
+	return fmt.Sprintf("jobs:stats:%s", day.UTC().Format("20060102"))
+}
+
+func markJobFinished(job *Job) {
+	if job.FinishedAt == nil {
+		return
+	}
+	rdb.ZAdd(ctx, jobsFinishedKey, redis.Z{Score: float64(job.FinishedAt.Unix()), Member: job.ID})
+}
+
+func summarizeJob(job *Job) *JobSummary {
+	summary := &JobSummary{
+		ID:        job.ID,
+		Tenant:    job.Tenant,
+		UserID:    job.UserID,
+		Title:     job.Title,
+		Status:    job.Status,
+		Error:     job.Error,
+		CreatedAt: job.CreatedAt,
+		Archived:  true,
+	}
+	if u, err := url.Parse(job.URL); err == nil {
+		summary.Host = u.Hostname()
+	}
+	if len(summary.Error) > maxArchivedError {
+		summary.Error = summary.Error[:maxArchivedError]
+	}
+	if job.FinishedAt != nil {
+		summary.FinishedAt = *job.FinishedAt
+	}
+	if job.ArtifactID != "" {
+		if artifact, err := getArtifact(job.ArtifactID); err == nil {
+			summary.Bytes = artifact.Size
+		}
+	}
+	return summary
+}
+
+func archiveJob(job *Job) error {
+	summary := summarizeJob(job)
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+
+	_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, jobArchiveKey(job.ID), data, cfg.JobArchiveRetention)
+		for _, key := range []string{jobStatsKey, jobStatsDayKey(summary.FinishedAt)} {
+			pipe.HIncrBy(ctx, key, string(summary.Status), 1)
+			pipe.HIncrBy(ctx, key, "bytes", summary.Bytes)
+		}
+		pipe.ZRem(ctx, jobsFinishedKey, job.ID)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	deleteJobLog(job.ID)
+	return jobstore.DeleteJob(job)
+}
+
+func getArchivedJob(id string) (*JobSummary, error) {
+	data, err := rdb.Get(ctx, jobArchiveKey(id)).Result()
+	if err == redis.Nil {
+		return nil, errJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var summary JobSummary
+	if err := json.Unmarshal([]byte(data), &summary); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+func compactJobs() {
+	lock, err := acquireLock(archiveLockName, archiveLockTTL)
+	if errors.Is(err, errLockHeld) {
+		return
+	}
+	if err != nil {
+		log.Printf("archive: %v", err)
+		return
+	}
+	defer lock.Release()
+
+	ids, err := rdb.ZRangeByScore(ctx, jobsFinishedKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(time.Now().Add(-cfg.JobArchiveAfter).Unix(), 10),
+		Count: archiveBatchSize,
+	}).Result()
+	if err != nil {
+		log.Printf("archive: %v", err)
+		return
+	}
+	archived := 0
+	for _, id := range ids {
+		job, err := getJob(id)
+		if errors.Is(err, errJobNotFound) {
+			rdb.ZRem(ctx, jobsFinishedKey, id)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if job.FinishedAt == nil {
+			rdb.ZRem(ctx, jobsFinishedKey, id)
+			continue
+		}
+		if err := archiveJob(job); err != nil {
+			log.Printf("archive: job %s: %v", id, err)
+			continue
+		}
+		archived++
+	}
+	if archived > 0 {
+		log.Printf("archive: compacted %d jobs", archived)
+	}
+}
+
+func runJobArchival() {
+	if cfg.JobArchiveAfter <= 0 {
+		return
+	}
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		compactJobs()
+	}
+}
+
+func jobStats(key string) (map[string]int64, error) {
+	fields, err := rdb.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string]int64, len(fields))
+	for name, value := range fields {
+		stats[name], _ = strconv.ParseInt(value, 10, 64)
+	}
+	return stats, nil
+}
+
+func handleJobStats(w http.ResponseWriter, r *http.Request) {
+	days := defaultStatsDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid days")
+			return
+		}
+		days = min(n, maxStatsDays)
+	}
+
+	total, err := jobStats(jobStatsKey)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading job statistics")
+		return
+	}
+	daily := make(map[string]map[string]int64, days)
+	today := time.Now().UTC()
+	for i := 0; i < days; i++ {
+		day := today.AddDate(0, 0, -i)
+		stats, err := jobStats(jobStatsDayKey(day))
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading job statistics")
+			return
+		}
+		if len(stats) > 0 {
+			daily[day.Format(time.DateOnly)] = stats
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"total": total, "daily": daily})
+}
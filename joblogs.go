@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type jobLog struct {
+	mu    sync.Mutex
+	id    string
+	buf   tailBuffer
+	dirty bool
+}
+
+func jobLogKey(id string) string {
+	return fmt.Sprintf("job_logs:%s", id)
+}
+
+func jobLogPath(id string) string {
+	return filepath.Join(cfg.JobLogDir, id+".log")
+}
+
+func openJobLog(id string) *jobLog {
+	l := &jobLog{id: id, buf: tailBuffer{limit: int(cfg.JobLogBytes)}}
+	if existing, err := readJobLog(id); err == nil {
+		l.buf.Write([]byte(existing))
+	}
+	return l
+}
+
+func (l *jobLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.dirty = true
+	return l.buf.Write(p)
+}
+
+func (l *jobLog) Printf(format string, args ...any) {
+	fmt.Fprintf(l, format+"\n", args...)
+}
+
+func (l *jobLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.String()
+}
+
+func (l *jobLog) Flush() {
+	l.mu.Lock()
+	if !l.dirty || cfg.JobLogBytes <= 0 {
+		l.mu.Unlock()
+		return
+	}
+	data := redactSecrets(l.buf.String())
+	l.dirty = false
+	l.mu.Unlock()
+
+	var err error
+	if cfg.JobLogDir != "" {
+		err = os.WriteFile(jobLogPath(l.id), []byte(data), 0o640)
+	} else {
+		err = rdb.Set(ctx, jobLogKey(l.id), data, cfg.JobTTL).Err()
+	}
+	if err != nil {
+		log.Printf("job %s: saving log: %v", l.id, err)
+	}
+}
+
+func readJobLog(id string) (string, error) {
+	if cfg.JobLogDir != "" {
+		data, err := os.ReadFile(jobLogPath(id))
+		if errors.Is(err, os.ErrNotExist) {
+			return "", errJobNotFound
+		}
+		return string(data), err
+	}
+	data, err := rdb.Get(ctx, jobLogKey(id)).Result()
+	if err == redis.Nil {
+		return "", errJobNotFound
+	}
+	return data, err
+}
+
+func deleteJobLog(id string) {
+	if cfg.JobLogDir != "" {
+		os.Remove(jobLogPath(id))
+		return
+	}
+	rdb.Del(ctx, jobLogKey(id))
+}
+
+func redactSecrets(s string) string {
+	secrets := []string{cfg.YouTubePOToken, cfg.YouTubeVisitorData}
+	for _, p := range proxies {
+		secrets = append(secrets, p.URL)
+	}
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, "xxxxx")
+		}
+	}
+	return s
+}
+
+func exitStderr(err error) string {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return string(exitErr.Stderr)
+	}
+	return ""
+}
+
+func handleJobLogs(w http.ResponseWriter, r *http.Request) {
+	job, err := getJob(r.PathValue("id"))
+	if errors.Is(err, errJobNotFound) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Job not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading job")
+		return
+	}
+	if job.UserID != "" {
+		if user := requestUser(r); user == nil || user.ID != job.UserID {
+			writeError(w, r, http.StatusNotFound, codeNotFound, "Job not found")
+			return
+		}
+	}
+	writeJobLog(w, r, job.ID)
+}
+
+func handleAdminJobLogs(w http.ResponseWriter, r *http.Request) {
+	writeJobLog(w, r, r.PathValue("id"))
+}
+
+func writeJobLog(w http.ResponseWriter, r *http.Request, id string) {
+	logs, err := readJobLog(id)
+	if errors.Is(err, errJobNotFound) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "No logs for this job")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading job logs")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	io.WriteString(w, logs)
+}
@@ -0,0 +1,553 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+type JobStatus string
+
+const (
+	JobScheduled JobStatus = "scheduled"
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobCompleted JobStatus = "completed"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+const (
+	jobQueueKey      = "jobs:queue"
+	jobScheduleKey   = "jobs:scheduled"
+	defaultJobFormat = "bv*+ba/b"
+	premiereRetry    = 5 * time.Minute
+	premiereDeadline = 24 * time.Hour
+)
+
+var (
+	errJobNotFound   = errors.New("job not found")
+	errQuotaExceeded = errors.New("quota exceeded")
+	errJobCancelled  = errors.New("job cancelled")
+)
+
+type Job struct {
+	ID             string       `json:"id"`
+	Tenant         string       `json:"tenant,omitempty"`
+	UserID         string       `json:"user_id,omitempty"`
+	Submitter      string       `json:"submitter,omitempty"`
+	SubscriptionID string       `json:"subscription_id,omitempty"`
+	URL            string       `json:"url"`
+	Source         string       `json:"source,omitempty"`
+	FormatID       string       `json:"format_id"`
+	Outputs        []JobOutput  `json:"outputs,omitempty"`
+	Title          string       `json:"title,omitempty"`
+	Status         JobStatus    `json:"status"`
+	Error          string       `json:"error,omitempty"`
+	WebhookURL     string       `json:"webhook_url,omitempty"`
+	Email          string       `json:"email,omitempty"`
+	RunAt          *time.Time   `json:"run_at,omitempty"`
+	CreatedAt      time.Time    `json:"created_at"`
+	StartedAt      *time.Time   `json:"started_at,omitempty"`
+	FinishedAt     *time.Time   `json:"finished_at,omitempty"`
+	ArtifactID     string       `json:"artifact_id,omitempty"`
+	Checksum       string       `json:"checksum,omitempty"`
+	Progress       *JobProgress `json:"progress,omitempty"`
+	OneTimeURL     string       `json:"one_time_url,omitempty"`
+	ETA            *JobETA      `json:"eta,omitempty"`
+	EstimatedSize  int64        `json:"estimated_size,omitempty"`
+	Lane           string       `json:"lane,omitempty"`
+
+	LinkConsumption string `json:"link_consumption,omitempty"`
+	Protected       bool   `json:"protected,omitempty"`
+
+	Scan *JobScan `json:"scan,omitempty"`
+
+	LastDownload *JobTransfer `json:"last_download,omitempty"`
+
+	OffPeak        bool   `json:"off_peak,omitempty"`
+	DeferredReason string `json:"deferred_reason,omitempty"`
+
+	DownloadOptions
+}
+
+func saveJob(job *Job) error {
+	return jobstore.SaveJob(job)
+}
+
+func getJob(id string) (*Job, error) {
+	return jobstore.GetJob(id)
+}
+
+func jobCancelKey(id string) string {
+	return fmt.Sprintf("job_cancel:%s", id)
+}
+
+func jobCancelRequested(id string) bool {
+	n, err := rdb.Exists(ctx, jobCancelKey(id)).Result()
+	return err == nil && n > 0
+}
+
+func jobListKey(job *Job) (time.Time, string, string) {
+	return job.CreatedAt, job.ID, string(job.Status)
+}
+
+func newJob(videoURL, formatID string) *Job {
+	if formatID == "" {
+		formatID = defaultJobFormat
+	}
+	return &Job{
+		ID:        utils.RandomID(12),
+		URL:       videoURL,
+		FormatID:  formatID,
+		Status:    JobQueued,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+func enqueueJob(job *Job) error {
+	if job.RunAt != nil && job.RunAt.After(time.Now()) {
+		job.Status = JobScheduled
+		if err := saveJob(job); err != nil {
+			return err
+		}
+		return rdb.ZAdd(ctx, jobScheduleKey, redis.Z{Score: float64(job.RunAt.Unix()), Member: job.ID}).Err()
+	}
+
+	job.Status = JobQueued
+	if err := saveJob(job); err != nil {
+		return err
+	}
+	return queue.Push(job.ID, jobLane(job))
+}
+
+func runScheduler() {
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		ids, err := rdb.ZRangeByScore(ctx, jobScheduleKey, &redis.ZRangeBy{
+			Min: "-inf",
+			Max: strconv.FormatInt(time.Now().Unix(), 10),
+		}).Result()
+		if err != nil {
+			log.Printf("scheduler: %v", err)
+			continue
+		}
+		for _, id := range ids {
+			if removed, err := rdb.ZRem(ctx, jobScheduleKey, id).Result(); err != nil || removed == 0 {
+				continue
+			}
+			job, err := getJob(id)
+			if err != nil {
+				continue
+			}
+			job.Status = JobQueued
+			if err := saveJob(job); err == nil {
+				if err := queue.Push(job.ID, jobLane(job)); err != nil {
+					log.Printf("scheduler: queueing job %s: %v", job.ID, err)
+				}
+			}
+		}
+	}
+}
+
+func startWorkers(n int) {
+	for i := 0; i < n; i++ {
+		go runWorker()
+	}
+}
+
+func runWorker() {
+	lanes := &laneScheduler{}
+	fair := &fairScheduler{}
+	for {
+		id, ack, err := queue.Pop(queuePopTimeout, lanes.order()...)
+		if errors.Is(err, errQueueEmpty) {
+			continue
+		}
+		if err != nil {
+			log.Printf("worker: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		runQueuedJob(id, lanes, fair)
+		if err := ack(); err != nil {
+			log.Printf("worker: acking job %s: %v", id, err)
+		}
+	}
+}
+
+func runQueuedJob(id string, lanes *laneScheduler, fair *fairScheduler) {
+	job, err := getJob(id)
+	if err != nil {
+		log.Printf("worker: job %s: %v", id, err)
+		return
+	}
+	switch job.Status {
+	case JobCancelled, JobCompleted, JobFailed:
+		return
+	}
+	if fair.shouldDefer(job) {
+		if err := queue.Push(job.ID, jobLane(job)); err != nil {
+			log.Printf("worker: requeueing job %s: %v", job.ID, err)
+		}
+		return
+	}
+	if c, ok := activeCooldown(urlSource(job.URL)); ok {
+		deferForCooldown(job, &CooldownError{Cooldown: c})
+		return
+	}
+	source, wait, ok := acquireSource(job)
+	if !ok {
+		if err := queue.Push(job.ID, jobLane(job)); err != nil {
+			log.Printf("worker: requeueing job %s: %v", job.ID, err)
+		}
+		time.Sleep(min(wait, time.Second))
+		return
+	}
+	debugf("worker: starting job %s from the %s lane", job.ID, jobLane(job))
+	lanes.picked(job)
+	startOwnerRun(job)
+	processJobSafely(job)
+	endOwnerRun(job)
+	releaseSource(job, source)
+}
+
+func processJob(job *Job) {
+	if deferToOffPeak(job) || deferForBandwidth(job) {
+		return
+	}
+	invalidateMetadata(job.URL)
+	videoData, err := fetchVideoMetaData(job.URL)
+	if deferForCooldown(job, err) {
+		return
+	}
+	if err != nil {
+		logs := openJobLog(job.ID)
+		logs.Printf("--- %s fetching video meta data: %v", time.Now().UTC().Format(time.RFC3339), err)
+		io.WriteString(logs, exitStderr(err))
+		logs.Flush()
+		finishJob(job, fmt.Errorf("fetching video meta data: %w", err))
+		return
+	}
+	job.Title = videoData.Title
+	job.Source = videoData.Source
+
+	if videoData.IsLive || videoData.IsUpcoming() {
+		if time.Since(job.CreatedAt) > premiereDeadline {
+			finishJob(job, errors.New("video did not become available in time"))
+			return
+		}
+		runAt := time.Now().Add(premiereRetry).UTC()
+		job.RunAt = &runAt
+		if err := enqueueJob(job); err != nil {
+			log.Printf("worker: rescheduling job %s: %v", job.ID, err)
+		}
+		return
+	}
+	if err := checkDownloadable(videoData); err != nil {
+		finishJob(job, err)
+		return
+	}
+
+	if jobCancelRequested(job.ID) {
+		finishJob(job, errJobCancelled)
+		return
+	}
+	if job.Protected && !hasJobPassphrase(job.ID) {
+		finishJob(job, errPassphraseGone)
+		return
+	}
+	release, err := admitToDisk(job, videoData)
+	if deferForDisk(job, err) {
+		return
+	}
+	if err != nil {
+		finishJob(job, err)
+		return
+	}
+	defer release()
+
+	now := time.Now().UTC()
+	job.Status = JobRunning
+	job.StartedAt = &now
+	job.DeferredReason = ""
+	if err := saveJob(job); err != nil {
+		log.Printf("worker: saving job %s: %v", job.ID, err)
+	}
+
+	var artifact *Artifact
+	if len(job.Outputs) > 0 {
+		artifact, err = downloadBundleToStore(job, videoData)
+	} else {
+		artifact, err = downloadToStore(job, videoData)
+	}
+	recordBandwidth(jobBandwidth(job, artifact))
+	if deferForCooldown(job, err) || retryWithAnotherProxy(job, err) {
+		return
+	}
+	if err == nil {
+		job.ArtifactID = artifact.ID
+		job.Checksum = artifact.Checksum
+		recordDownloadArchive(job, videoData)
+		if len(job.Outputs) > 0 || job.UserID == "" {
+			token, linkErr := createOneTimeLink(artifact.ID, cfg.JobTTL, job.LinkConsumption)
+			if linkErr != nil {
+				log.Printf("job %s: creating link: %v", job.ID, linkErr)
+			} else {
+				job.OneTimeURL = oneTimeLinkURL(token)
+			}
+		}
+	}
+	finishJob(job, err)
+}
+
+func downloadToStore(job *Job, videoData *VideoResponse) (*Artifact, error) {
+	jellyfin := job.SubscriptionID != "" && cfg.ArchiveLayout == archiveLayoutJellyfin
+
+	var dir, base string
+	var err error
+	if jellyfin {
+		dir, base, err = jellyfinPath(job, videoData)
+	} else {
+		dir, err = store.JobDir(tenantByID(job.Tenant).StoragePrefix, job.UserID, job.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{
+		"-f", job.FormatID,
+		"--merge-output-format", mergeOutputFormat,
+		"--prefer-ffmpeg",
+		"--no-mtime",
+		"--no-playlist",
+	}
+	if cfg.MaxFilesize > 0 {
+		args = append(args, "--max-filesize", fmt.Sprint(cfg.MaxFilesize))
+	}
+	if jellyfin {
+		args = append(args,
+			"--write-thumbnail", "--convert-thumbnails", "jpg",
+			"-o", "thumbnail:"+filepath.Join(dir, base+"-thumb.%(ext)s"),
+			"-o", filepath.Join(dir, base+".%(ext)s"),
+		)
+	} else {
+		args = append(args, "-o", filepath.Join(dir, "%(title).100B.%(ext)s"))
+	}
+	if err := runJobDownload(job, args); err != nil {
+		return nil, fmt.Errorf("yt-dlp: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !utils.IsMediaExt(filepath.Ext(name)) {
+			continue
+		}
+		if base != "" && strings.TrimSuffix(name, filepath.Ext(name)) != base {
+			continue
+		}
+		if jellyfin {
+			if err := writeJellyfinMetadata(dir, base, videoData); err != nil {
+				log.Printf("job %s: writing nfo: %v", job.ID, err)
+			}
+		}
+		if err := sniffFile(filepath.Join(dir, name), filepath.Ext(name)); err != nil {
+			os.Remove(filepath.Join(dir, name))
+			return nil, err
+		}
+		if err := scanJobFile(job, videoData, filepath.Join(dir, name)); err != nil {
+			return nil, err
+		}
+		return store.AddArtifact(job, filepath.Join(dir, name))
+	}
+	return nil, errors.New("yt-dlp did not produce a media file")
+}
+
+func finishJob(job *Job, err error) {
+	dropJobPassphrase(job.ID)
+	now := time.Now().UTC()
+	job.FinishedAt = &now
+	job.Status = JobCompleted
+	if err == nil && job.Progress != nil {
+		job.Progress.Percent = 100
+	}
+	if errors.Is(err, errJobCancelled) {
+		job.Status = JobCancelled
+		job.Error = ""
+		if err := saveJob(job); err != nil {
+			log.Printf("saving job %s: %v", job.ID, err)
+		}
+		markJobFinished(job)
+		rdb.Del(ctx, jobCancelKey(job.ID))
+		return
+	}
+	if err != nil {
+		job.Status = JobFailed
+		job.Error = err.Error()
+		log.Printf("job %s failed: %v", job.ID, err)
+	}
+	if err := saveJob(job); err != nil {
+		log.Printf("saving job %s: %v", job.ID, err)
+	}
+	markJobFinished(job)
+	recordJobDuration(job)
+	recordOutcome(job.URL, err)
+	if job.Status == JobFailed {
+		events.Publish(EventJobFailed, job.Tenant, *job)
+	} else {
+		events.Publish(EventJobCompleted, job.Tenant, *job)
+	}
+	notifyJobFinished(job)
+}
+
+type jobRequest struct {
+	URL        string      `json:"url"`
+	FormatID   string      `json:"format"`
+	Outputs    []JobOutput `json:"outputs"`
+	RunAt      *time.Time  `json:"run_at"`
+	WebhookURL string      `json:"webhook_url"`
+	Email      string      `json:"email"`
+
+	LinkConsumption string `json:"link_consumption"`
+	Passphrase      string `json:"passphrase"`
+	OffPeak         bool   `json:"off_peak"`
+
+	DownloadOptions
+
+	title        string
+	lane         string
+	skipMetadata bool
+}
+
+func (req *jobRequest) validate(tenant *Tenant) error {
+	if req.URL == "" || !validateVideoURL(req.URL) || !tenant.AllowsURL(req.URL) {
+		return errUnsupportedURL
+	}
+	if req.FormatID != "" && !isValidFormatID(req.FormatID) {
+		return errInvalidFormat
+	}
+	if err := req.DownloadOptions.normalize(); err != nil {
+		return err
+	}
+	if len(req.Outputs) > 0 && !flagEnabled(flagBundles) {
+		return errors.New("Bundles are disabled on this server")
+	}
+	if len(req.Outputs) > maxBundleOutputs {
+		return fmt.Errorf("At most %d outputs can be bundled", maxBundleOutputs)
+	}
+	for i := range req.Outputs {
+		if err := req.Outputs[i].normalize(); err != nil {
+			return err
+		}
+	}
+	if req.LinkConsumption != "" && !linkConsumptionModes[req.LinkConsumption] {
+		return errors.New("Invalid link_consumption, use first_byte, completion or confirm")
+	}
+	if req.OffPeak && offPeak == nil {
+		return errOffPeakDisabled
+	}
+	if req.Passphrase != "" && len(req.Passphrase) < minPassphraseLength {
+		return fmt.Errorf("The passphrase must be at least %d characters", minPassphraseLength)
+	}
+	if req.WebhookURL != "" {
+		u, err := url.ParseRequestURI(req.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return errors.New("Invalid webhook URL")
+		}
+		if publicHostCheck(u.Hostname()) != nil {
+			return errors.New("The webhook URL must point to a public address")
+		}
+	}
+	if req.Email != "" {
+		if _, err := mail.ParseAddress(req.Email); err != nil {
+			return errors.New("Invalid email address")
+		}
+	}
+	return nil
+}
+
+func createJob(req jobRequest, tenant *Tenant, user *User, clientAddr string) (*Job, error) {
+	if err := checkStorageQuota(tenant, user.ownerID()); err != nil {
+		return nil, err
+	}
+	if ok, err := tenant.ConsumeQuota("jobs", tenant.Quota.DailyJobs); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, errQuotaExceeded
+	}
+	job, err := newQueuedJob(req, tenant, user, clientAddr)
+	if err != nil {
+		tenant.RefundQuota("jobs", tenant.Quota.DailyJobs)
+		return nil, err
+	}
+	events.Publish(EventJobCreated, job.Tenant, *job)
+	return job, nil
+}
+
+func newQueuedJob(req jobRequest, tenant *Tenant, user *User, clientAddr string) (*Job, error) {
+	job := newJob(req.URL, req.FormatID)
+	job.Tenant = tenant.ID
+	job.UserID = user.ownerID()
+	if job.UserID == "" {
+		job.Submitter = submitterHash(clientAddr)
+	}
+	job.WebhookURL = req.WebhookURL
+	job.Email = req.Email
+	job.Outputs = req.Outputs
+	job.LinkConsumption = req.LinkConsumption
+	job.Protected = req.Passphrase != ""
+	job.DownloadOptions = req.DownloadOptions
+	job.RunAt = req.RunAt
+
+	switch {
+	case req.skipMetadata:
+		if c, ok := activeCooldown(urlSource(req.URL)); ok {
+			return nil, &CooldownError{Cooldown: c}
+		}
+		job.Title = req.title
+		classifyJob(job, &VideoResponse{})
+	case job.RunAt == nil:
+		videoData, err := fetchVideoMetaData(req.URL)
+		if err != nil {
+			return nil, err
+		}
+		job.Title = videoData.Title
+		job.Source = videoData.Source
+		classifyJob(job, videoData)
+		if videoData.IsUpcoming() && videoData.ReleaseAt > 0 {
+			releaseAt := time.Unix(videoData.ReleaseAt, 0).UTC()
+			job.RunAt = &releaseAt
+		}
+	}
+	if req.lane != "" {
+		job.Lane = req.lane
+	}
+	job.OffPeak = req.OffPeak
+	scheduleOffPeak(job)
+	scheduleForBandwidth(job)
+	if req.Passphrase != "" {
+		if err := storeJobPassphrase(job.ID, req.Passphrase); err != nil {
+			return nil, err
+		}
+	}
+	if err := enqueueJob(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
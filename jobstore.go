@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	usersKey = "users"
+
+	listScanBatch = 100
+
+	jobStoreRedis    = "redis"
+	jobStoreSQLite   = "sqlite"
+	jobStorePostgres = "postgres"
+)
+
+type JobStore interface {
+	SaveJob(job *Job) error
+	GetJob(id string) (*Job, error)
+	DeleteJob(job *Job) error
+	ListJobs(userID string, q ListQuery) ([]*Job, error)
+
+	CreateUser(user *User, apiKeyHash string) error
+	GetUser(id string) (*User, error)
+	GetUserByName(name string) (*User, error)
+	GetUserByAPIKey(apiKeyHash string) (*User, error)
+	ListUsers() ([]*User, error)
+
+	CreateLink(token, artifactID string, ttl time.Duration) error
+	ConsumeLink(token string) (string, error)
+	PeekLink(token string) (string, error)
+
+	ExportUsers() ([]UserRecord, error)
+	ImportUser(rec UserRecord) error
+	ExportLinks() ([]LinkRecord, error)
+	ImportLink(rec LinkRecord) error
+}
+
+type UserRecord struct {
+	User
+	APIKeyHash string `json:"api_key_hash"`
+}
+
+type LinkRecord struct {
+	Token      string    `json:"token"`
+	ArtifactID string    `json:"artifact_id"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+var jobstore JobStore
+
+func newJobStore(kind, dsn string) (JobStore, error) {
+	switch kind {
+	case "", jobStoreRedis:
+		return redisJobStore{}, nil
+	case jobStoreSQLite, jobStorePostgres:
+		return openSQLJobStore(kind, dsn)
+	default:
+		return nil, fmt.Errorf("unknown job store %q", kind)
+	}
+}
+
+type redisJobStore struct{}
+
+func jobKey(id string) string {
+	return fmt.Sprintf("job:%s", id)
+}
+
+func userJobsKey(userID string) string {
+	return fmt.Sprintf("user:%s:jobs", userID)
+}
+
+func (redisJobStore) SaveJob(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		if job.UserID != "" {
+			pipe.ZAdd(ctx, userJobsKey(job.UserID), redis.Z{Score: float64(job.CreatedAt.Unix()), Member: job.ID})
+		}
+		pipe.Set(ctx, jobKey(job.ID), data, cfg.JobTTL)
+		return nil
+	})
+	return err
+}
+
+func (redisJobStore) GetJob(id string) (*Job, error) {
+	data, err := rdb.Get(ctx, jobKey(id)).Result()
+	if err == redis.Nil {
+		return nil, errJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var job Job
+	if err := json.Unmarshal([]byte(data), &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (redisJobStore) DeleteJob(job *Job) error {
+	if job.UserID != "" {
+		rdb.ZRem(ctx, userJobsKey(job.UserID), job.ID)
+	}
+	return rdb.Del(ctx, jobKey(job.ID)).Err()
+}
+
+func (s redisJobStore) ListJobs(userID string, q ListQuery) ([]*Job, error) {
+	key := userJobsKey(userID)
+	rdb.ZRemRangeByScore(ctx, key, "-inf", fmt.Sprint(time.Now().Add(-cfg.JobTTL).Unix()))
+
+	opt := &redis.ZRangeBy{Min: "-inf", Max: "+inf", Count: listScanBatch}
+	if !q.Since.IsZero() {
+		opt.Min = fmt.Sprint(q.Since.Unix())
+	}
+	if max, ok := q.maxUnix(); ok {
+		opt.Max = fmt.Sprint(max)
+	}
+	jobs := []*Job{}
+	for {
+		ids, err := rdb.ZRevRangeByScore(ctx, key, opt).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			job, err := s.GetJob(id)
+			if err != nil || !q.matches(job.CreatedAt, job.ID, string(job.Status)) {
+				continue
+			}
+			if jobs = append(jobs, job); len(jobs) > q.Limit {
+				return jobs, nil
+			}
+		}
+		if int64(len(ids)) < opt.Count {
+			return jobs, nil
+		}
+		opt.Offset += opt.Count
+	}
+}
+
+func userKey(id string) string {
+	return fmt.Sprintf("user:%s", id)
+}
+
+func (redisJobStore) CreateUser(user *User, apiKeyHash string) error {
+	ok, err := rdb.SetNX(ctx, "user_name:"+user.Name, user.ID, 0).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errUserExists
+	}
+
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	if err := rdb.Set(ctx, userKey(user.ID), data, 0).Err(); err != nil {
+		return err
+	}
+	if err := rdb.Set(ctx, "apikey:"+apiKeyHash, user.ID, 0).Err(); err != nil {
+		return err
+	}
+	return rdb.SAdd(ctx, usersKey, user.ID).Err()
+}
+
+func (redisJobStore) GetUser(id string) (*User, error) {
+	data, err := rdb.Get(ctx, userKey(id)).Result()
+	if err == redis.Nil {
+		return nil, errUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var user User
+	if err := json.Unmarshal([]byte(data), &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s redisJobStore) lookupUser(key string) (*User, error) {
+	id, err := rdb.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return nil, errUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetUser(id)
+}
+
+func (s redisJobStore) GetUserByName(name string) (*User, error) {
+	return s.lookupUser("user_name:" + name)
+}
+
+func (s redisJobStore) GetUserByAPIKey(apiKeyHash string) (*User, error) {
+	return s.lookupUser("apikey:" + apiKeyHash)
+}
+
+func (s redisJobStore) ListUsers() ([]*User, error) {
+	ids, err := rdb.SMembers(ctx, usersKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	users := make([]*User, 0, len(ids))
+	for _, id := range ids {
+		if user, err := s.GetUser(id); err == nil {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func linkKey(token string) string {
+	return fmt.Sprintf("link:%s", token)
+}
+
+func (redisJobStore) CreateLink(token, artifactID string, ttl time.Duration) error {
+	return rdb.Set(ctx, linkKey(token), artifactID, ttl).Err()
+}
+
+func (redisJobStore) ConsumeLink(token string) (string, error) {
+	artifactID, err := rdb.GetDel(ctx, linkKey(token)).Result()
+	if err == redis.Nil {
+		return "", errLinkNotFound
+	}
+	return artifactID, err
+}
+
+func (redisJobStore) PeekLink(token string) (string, error) {
+	artifactID, err := rdb.Get(ctx, linkKey(token)).Result()
+	if err == redis.Nil {
+		return "", errLinkNotFound
+	}
+	return artifactID, err
+}
+
+func scanKeys(pattern string) ([]string, error) {
+	var keys []string
+	iter := rdb.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+func (s redisJobStore) ExportUsers() ([]UserRecord, error) {
+	keys, err := scanKeys("apikey:*")
+	if err != nil {
+		return nil, err
+	}
+	records := []UserRecord{}
+	for _, key := range keys {
+		user, err := s.lookupUser(key)
+		if err != nil {
+			continue
+		}
+		records = append(records, UserRecord{User: *user, APIKeyHash: strings.TrimPrefix(key, "apikey:")})
+	}
+	return records, nil
+}
+
+func (s redisJobStore) ImportUser(rec UserRecord) error {
+	user := rec.User
+	return s.CreateUser(&user, rec.APIKeyHash)
+}
+
+func (redisJobStore) ExportLinks() ([]LinkRecord, error) {
+	keys, err := scanKeys("link:*")
+	if err != nil {
+		return nil, err
+	}
+	records := []LinkRecord{}
+	for _, key := range keys {
+		artifactID, err := rdb.Get(ctx, key).Result()
+		if err != nil {
+			continue
+		}
+		ttl, err := rdb.TTL(ctx, key).Result()
+		if err != nil || ttl <= 0 {
+			continue
+		}
+		records = append(records, LinkRecord{
+			Token:      strings.TrimPrefix(key, "link:"),
+			ArtifactID: artifactID,
+			ExpiresAt:  time.Now().Add(ttl).UTC().Truncate(time.Second),
+		})
+	}
+	return records, nil
+}
+
+func (s redisJobStore) ImportLink(rec LinkRecord) error {
+	ttl := time.Until(rec.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.CreateLink(rec.Token, rec.ArtifactID, ttl)
+}
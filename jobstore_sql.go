@@ -0,0 +1,288 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+type sqlJobStore struct {
+	db       *sql.DB
+	postgres bool
+}
+
+func openSQLJobStore(kind, dsn string) (*sqlJobStore, error) {
+	driver := "postgres"
+	if kind == jobStoreSQLite {
+		driver = "sqlite"
+		if dsn == "" {
+			dsn = "file:" + filepath.Join(cfg.StorageDir, "onetimedownload.db") + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+		}
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if kind == jobStoreSQLite {
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &sqlJobStore{db: db, postgres: kind == jobStorePostgres}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *sqlJobStore) rebind(query string) string {
+	if !s.postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func (s *sqlJobStore) exec(query string, args ...any) error {
+	_, err := s.db.Exec(s.rebind(query), args...)
+	return err
+}
+
+func (s *sqlJobStore) SaveJob(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return s.exec(`INSERT INTO jobs (id, user_id, status, created_at, data) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET status = excluded.status, data = excluded.data`,
+		job.ID, job.UserID, string(job.Status), job.CreatedAt.Unix(), string(data))
+}
+
+func (s *sqlJobStore) scanJobs(rows *sql.Rows) ([]*Job, error) {
+	defer rows.Close()
+	var jobs []*Job
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var job Job
+		if err := json.Unmarshal([]byte(data), &job); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, &job)
+	}
+	return jobs, rows.Err()
+}
+
+func (s *sqlJobStore) GetJob(id string) (*Job, error) {
+	rows, err := s.db.Query(s.rebind(`SELECT data FROM jobs WHERE id = ?`), id)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := s.scanJobs(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, errJobNotFound
+	}
+	return jobs[0], nil
+}
+
+func (s *sqlJobStore) DeleteJob(job *Job) error {
+	return s.exec(`DELETE FROM jobs WHERE id = ?`, job.ID)
+}
+
+func (s *sqlJobStore) ListJobs(userID string, q ListQuery) ([]*Job, error) {
+	where, args := []string{"user_id = ?"}, []any{userID}
+	if len(q.Statuses) > 0 {
+		where = append(where, "status IN (?"+strings.Repeat(", ?", len(q.Statuses)-1)+")")
+		for _, status := range q.Statuses {
+			args = append(args, status)
+		}
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, q.Since.Unix())
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, q.Until.Unix())
+	}
+	if q.cursor != nil {
+		where = append(where, "(created_at < ? OR (created_at = ? AND id < ?))")
+		args = append(args, q.cursor.at, q.cursor.at, q.cursor.id)
+	}
+	args = append(args, q.Limit+1)
+
+	rows, err := s.db.Query(s.rebind(`SELECT data FROM jobs WHERE `+strings.Join(where, " AND ")+` ORDER BY created_at DESC, id DESC LIMIT ?`), args...)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := s.scanJobs(rows)
+	if jobs == nil {
+		jobs = []*Job{}
+	}
+	return jobs, err
+}
+
+func (s *sqlJobStore) CreateUser(user *User, apiKeyHash string) error {
+	if _, err := s.GetUserByName(user.Name); err == nil {
+		return errUserExists
+	}
+	err := s.exec(`INSERT INTO users (id, name, tenant, api_key_hash, created_at) VALUES (?, ?, ?, ?, ?)`,
+		user.ID, user.Name, user.Tenant, apiKeyHash, user.CreatedAt.Unix())
+	if err != nil {
+		if _, lookupErr := s.GetUserByName(user.Name); lookupErr == nil {
+			return errUserExists
+		}
+	}
+	return err
+}
+
+func (s *sqlJobStore) queryUsers(where string, args ...any) ([]*User, error) {
+	rows, err := s.db.Query(s.rebind(`SELECT id, name, tenant, created_at FROM users `+where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		var createdAt int64
+		if err := rows.Scan(&user.ID, &user.Name, &user.Tenant, &createdAt); err != nil {
+			return nil, err
+		}
+		user.CreatedAt = time.Unix(createdAt, 0).UTC()
+		users = append(users, &user)
+	}
+	return users, rows.Err()
+}
+
+func (s *sqlJobStore) getUserWhere(where string, arg string) (*User, error) {
+	users, err := s.queryUsers(where, arg)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, errUserNotFound
+	}
+	return users[0], nil
+}
+
+func (s *sqlJobStore) GetUser(id string) (*User, error) {
+	return s.getUserWhere(`WHERE id = ?`, id)
+}
+
+func (s *sqlJobStore) GetUserByName(name string) (*User, error) {
+	return s.getUserWhere(`WHERE name = ?`, name)
+}
+
+func (s *sqlJobStore) GetUserByAPIKey(apiKeyHash string) (*User, error) {
+	return s.getUserWhere(`WHERE api_key_hash = ?`, apiKeyHash)
+}
+
+func (s *sqlJobStore) ListUsers() ([]*User, error) {
+	return s.queryUsers(`ORDER BY created_at`)
+}
+
+func (s *sqlJobStore) CreateLink(token, artifactID string, ttl time.Duration) error {
+	s.exec(`DELETE FROM links WHERE expires_at < ?`, time.Now().Unix())
+	return s.exec(`INSERT INTO links (token, artifact_id, expires_at) VALUES (?, ?, ?)`,
+		token, artifactID, time.Now().Add(ttl).Unix())
+}
+
+func (s *sqlJobStore) ConsumeLink(token string) (string, error) {
+	var artifactID string
+	err := s.db.QueryRow(s.rebind(`DELETE FROM links WHERE token = ? AND expires_at >= ? RETURNING artifact_id`),
+		token, time.Now().Unix()).Scan(&artifactID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", errLinkNotFound
+	}
+	return artifactID, err
+}
+
+func (s *sqlJobStore) PeekLink(token string) (string, error) {
+	var artifactID string
+	err := s.db.QueryRow(s.rebind(`SELECT artifact_id FROM links WHERE token = ? AND expires_at >= ?`),
+		token, time.Now().Unix()).Scan(&artifactID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", errLinkNotFound
+	}
+	return artifactID, err
+}
+
+func (s *sqlJobStore) ExportUsers() ([]UserRecord, error) {
+	rows, err := s.db.Query(`SELECT id, name, tenant, api_key_hash, created_at FROM users ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []UserRecord{}
+	for rows.Next() {
+		var rec UserRecord
+		var createdAt int64
+		if err := rows.Scan(&rec.ID, &rec.Name, &rec.Tenant, &rec.APIKeyHash, &createdAt); err != nil {
+			return nil, err
+		}
+		rec.CreatedAt = time.Unix(createdAt, 0).UTC()
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+func (s *sqlJobStore) ImportUser(rec UserRecord) error {
+	user := rec.User
+	return s.CreateUser(&user, rec.APIKeyHash)
+}
+
+func (s *sqlJobStore) ExportLinks() ([]LinkRecord, error) {
+	rows, err := s.db.Query(s.rebind(`SELECT token, artifact_id, expires_at FROM links WHERE expires_at >= ?`), time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []LinkRecord{}
+	for rows.Next() {
+		var rec LinkRecord
+		var expiresAt int64
+		if err := rows.Scan(&rec.Token, &rec.ArtifactID, &expiresAt); err != nil {
+			return nil, err
+		}
+		rec.ExpiresAt = time.Unix(expiresAt, 0).UTC()
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+func (s *sqlJobStore) ImportLink(rec LinkRecord) error {
+	if !rec.ExpiresAt.After(time.Now()) {
+		return nil
+	}
+	return s.exec(`INSERT INTO links (token, artifact_id, expires_at) VALUES (?, ?, ?) ON CONFLICT (token) DO NOTHING`,
+		rec.Token, rec.ArtifactID, rec.ExpiresAt.Unix())
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+const (
+	maxURLLength      = 2048
+	maxFileNameLength = 255
+)
+
+var paramLimits = map[string]int{
+	"url":      maxURLLength,
+	"videoURL": maxURLLength,
+	"filename": maxFileNameLength,
+}
+
+func checkParamLengths(values url.Values) error {
+	for name, limit := range paramLimits {
+		for _, v := range values[name] {
+			if len(v) > limit {
+				return fmt.Errorf("Parameter %q exceeds %d characters", name, limit)
+			}
+		}
+	}
+	return nil
+}
+
+func writeBodyError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, r, http.StatusRequestEntityTooLarge, codePayloadTooLarge, fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit))
+		return
+	}
+	writeError(w, r, http.StatusBadRequest, codeInvalidRequest, message)
+}
+
+func parseForm(w http.ResponseWriter, r *http.Request) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.MaxBodyBytes)
+	if err := r.ParseForm(); err != nil {
+		writeBodyError(w, r, err, "Error Parsing Form")
+		return false
+	}
+	if err := checkParamLengths(r.Form); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return false
+	}
+	return true
+}
+
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.MaxBodyBytes)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeBodyError(w, r, err, "Invalid JSON body")
+		return false
+	}
+	return true
+}
+
+func withQueryLimits(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.URL.RawQuery) > cfg.MaxQueryBytes {
+			writeError(w, r, http.StatusRequestURITooLong, codeQueryTooLong, fmt.Sprintf("Query string exceeds %d bytes", cfg.MaxQueryBytes))
+			return
+		}
+		if err := checkParamLengths(r.URL.Query()); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	LinkDownloadCompleted  = "completed"
+	LinkDownloadIncomplete = "incomplete"
+	LinkDownloadRejected   = "rejected"
+)
+
+const (
+	LinkConsumeFirstByte  = "first_byte"
+	LinkConsumeCompletion = "completion"
+	LinkConsumeConfirm    = "confirm"
+)
+
+var linkConsumptionModes = map[string]bool{LinkConsumeFirstByte: true, LinkConsumeCompletion: true, LinkConsumeConfirm: true}
+
+const (
+	linkClaimTTL     = 30 * time.Minute
+	linkClientCookie = "odl_link_client"
+	headerLinkClient = "X-Link-Client"
+)
+
+var (
+	errLinkNotFound     = errors.New("link not found or already used")
+	linkRangeRequests   = metrics.Counter("odl_link_range_requests_total", "Ranged requests served through an already used one-time link.")
+	linkPartialFailures = metrics.Counter("odl_link_partial_failures_total", "One-time links used up on the first byte whose download then broke off.")
+	linkResumes         = metrics.Counter("odl_link_resumes_total", "One-time link downloads resumed from the offset the client had reached.")
+)
+
+type LinkEvent struct {
+	ID        string    `json:"id,omitempty"`
+	At        time.Time `json:"at"`
+	IPHash    string    `json:"ip_hash"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Bytes     int64     `json:"bytes"`
+	Status    string    `json:"status"`
+
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+	BytesPerSecond  float64 `json:"bytes_per_second,omitempty"`
+
+	client string
+}
+
+func createOneTimeLink(artifactID string, ttl time.Duration, consumption string) (string, error) {
+	token := utils.RandomID(24)
+	if err := jobstore.CreateLink(token, artifactID, ttl); err != nil {
+		return "", err
+	}
+	if consumption != "" && consumption != cfg.LinkConsumption {
+		rdb.Set(ctx, linkConsumptionKey(token), consumption, ttl)
+	}
+	return token, nil
+}
+
+func consumeOneTimeLink(token string) (string, error) {
+	artifactID, err := jobstore.ConsumeLink(token)
+	if err == nil {
+		rdb.Del(ctx, linkConsumptionKey(token), linkAttemptsKey(token))
+	}
+	return artifactID, err
+}
+
+func linkAttemptsKey(token string) string {
+	return fmt.Sprintf("link_attempts:%s", token)
+}
+
+func linkAttemptsExhausted(token string) bool {
+	if cfg.LinkMaxAttempts <= 0 {
+		return false
+	}
+	n, err := rdb.Incr(ctx, linkAttemptsKey(token)).Result()
+	if err != nil {
+		return false
+	}
+	rdb.Expire(ctx, linkAttemptsKey(token), cfg.JobTTL)
+	return n >= cfg.LinkMaxAttempts
+}
+
+func linkConsumptionKey(token string) string {
+	return fmt.Sprintf("link_consumption:%s", token)
+}
+
+func linkConsumption(token string) string {
+	if mode, err := rdb.Get(ctx, linkConsumptionKey(token)).Result(); err == nil && linkConsumptionModes[mode] {
+		return mode
+	}
+	return cfg.LinkConsumption
+}
+
+func oneTimeLinkURL(token string) string {
+	return fmt.Sprintf("%s/d/%s", cfg.PublicURL, token)
+}
+
+func linkRangeKey(token string) string {
+	return fmt.Sprintf("link_ranges:%s", token)
+}
+
+func openLinkRanges(token, artifactID string, event LinkEvent) {
+	if cfg.LinkRangeWindow > 0 {
+		rdb.Set(ctx, linkRangeKey(token), artifactID+":"+event.client, cfg.LinkRangeWindow)
+	}
+}
+
+func linkRangeArtifact(token string, event LinkEvent) (string, bool) {
+	if cfg.LinkRangeWindow <= 0 {
+		return "", false
+	}
+	value, err := rdb.Get(ctx, linkRangeKey(token)).Result()
+	if err != nil {
+		return "", false
+	}
+	artifactID, client, _ := strings.Cut(value, ":")
+	return artifactID, client == event.client
+}
+
+func linkTokenArtifact(token string) string {
+	if artifactID, err := jobstore.PeekLink(token); err == nil {
+		return artifactID
+	}
+	value, _ := rdb.Get(ctx, linkRangeKey(token)).Result()
+	artifactID, _, _ := strings.Cut(value, ":")
+	return artifactID
+}
+
+func rangesFrom(header string, offset int64, artifactID string) bool {
+	if offset == 0 {
+		return true
+	}
+	artifact, err := getArtifact(artifactID)
+	if err != nil {
+		return false
+	}
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return false
+	}
+	for _, part := range strings.Split(spec, ",") {
+		first, last, _ := strings.Cut(strings.TrimSpace(part), "-")
+		start, err := strconv.ParseInt(first, 10, 64)
+		if first == "" {
+			n, perr := strconv.ParseInt(last, 10, 64)
+			start, err = artifact.Size-n, perr
+		}
+		if err != nil || start < offset {
+			return false
+		}
+	}
+	return true
+}
+
+func linkProgressKey(token string) string {
+	return fmt.Sprintf("link_progress:%s", token)
+}
+
+func linkProgress(token string, event LinkEvent) int64 {
+	offset, err := rdb.HGet(ctx, linkProgressKey(token), event.client).Int64()
+	if err != nil {
+		return 0
+	}
+	return offset
+}
+
+func recordLinkProgress(token string, event LinkEvent, h http.Header, sent int64) {
+	if cfg.LinkRangeWindow <= 0 || sent == 0 || strings.HasPrefix(h.Get("Content-Type"), "multipart/") {
+		return
+	}
+	start, total := int64(0), h.Get("Content-Length")
+	if cr := h.Get("Content-Range"); cr != "" {
+		span, size, _ := strings.Cut(strings.TrimPrefix(cr, "bytes "), "/")
+		first, _, _ := strings.Cut(span, "-")
+		n, err := strconv.ParseInt(first, 10, 64)
+		if err != nil {
+			return
+		}
+		start, total = n, size
+	}
+	offset := linkProgress(token, event)
+	end := start + sent
+	if start > offset || end <= offset {
+		return
+	}
+	if size, err := strconv.ParseInt(total, 10, 64); err == nil && end >= size {
+		rdb.HDel(ctx, linkProgressKey(token), event.client)
+		rdb.Del(ctx, linkRangeKey(token))
+		return
+	}
+	rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, linkProgressKey(token), event.client, end)
+		pipe.Expire(ctx, linkProgressKey(token), cfg.LinkRangeWindow)
+		return nil
+	})
+}
+
+func resumeLinkDownload(w http.ResponseWriter, r *http.Request, token string, event LinkEvent) {
+	offset := linkProgress(token, event)
+	if offset == 0 {
+		return
+	}
+	w.Header().Set("X-Resume-Offset", strconv.FormatInt(offset, 10))
+	if r.URL.Query().Has("resume") && r.Header.Get("Range") == "" {
+		r.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		linkResumes.Inc()
+	}
+}
+
+func linkClaimKey(token string) string {
+	return fmt.Sprintf("link_claim:%s", token)
+}
+
+func claimOneTimeLink(token string, event LinkEvent) (string, bool) {
+	claim := event.IPHash + ":" + utils.RandomID(8)
+	ok, err := rdb.SetNX(ctx, linkClaimKey(token), claim, linkClaimTTL).Result()
+	if err != nil {
+		log.Printf("link %s: claiming: %v", token, err)
+		return "", false
+	}
+	return claim, ok
+}
+
+func releaseOneTimeLink(token, claim string) {
+	releaseLockScript.Run(ctx, rdb, []string{linkClaimKey(token)}, claim)
+}
+
+func linkStatsKey(token string) string {
+	return fmt.Sprintf("link_stats:%s", token)
+}
+
+func newLinkEvent(w http.ResponseWriter, r *http.Request, token string) LinkEvent {
+	sum := sha256.Sum256([]byte(token + clientIP(r)))
+	client := sha256.Sum256([]byte(token + linkClientKey(w, r)))
+	return LinkEvent{
+		At:        time.Now().UTC(),
+		IPHash:    hex.EncodeToString(sum[:8]),
+		UserAgent: r.UserAgent(),
+		client:    hex.EncodeToString(client[:8]),
+	}
+}
+
+func linkClientKey(w http.ResponseWriter, r *http.Request) string {
+	if key := r.Header.Get(headerLinkClient); key != "" {
+		return key
+	}
+	if c, err := r.Cookie(linkClientCookie); err == nil && c.Value != "" {
+		return c.Value
+	}
+	key := utils.RandomID(16)
+	w.Header().Set(headerLinkClient, key)
+	http.SetCookie(w, &http.Cookie{
+		Name:     linkClientCookie,
+		Value:    key,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.PublicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return key
+}
+
+func recordLinkEvent(token string, event LinkEvent, onlyExisting bool) {
+	key := linkStatsKey(token)
+	if onlyExisting {
+		if n, err := rdb.Exists(ctx, key).Result(); err != nil || n == 0 {
+			return
+		}
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	_, err = rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, key, data)
+		pipe.Expire(ctx, key, cfg.JobTTL)
+		return nil
+	})
+	if err != nil {
+		log.Printf("link stats: %v", err)
+	}
+}
+
+func linkEvents(token string) ([]LinkEvent, error) {
+	items, err := rdb.LRange(ctx, linkStatsKey(token), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	stats := make([]LinkEvent, 0, len(items))
+	for i, item := range items {
+		var event LinkEvent
+		if json.Unmarshal([]byte(item), &event) == nil {
+			event.ID = fmt.Sprintf("%08d", i)
+			stats = append(stats, event)
+		}
+	}
+	return stats, nil
+}
+
+func handleOneTimeLink(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	event := newLinkEvent(w, r, token)
+	resumeLinkDownload(w, r, token, event)
+	if r.Method == http.MethodHead {
+		artifactID, err := jobstore.PeekLink(token)
+		if id, ok := linkRangeArtifact(token, event); errors.Is(err, errLinkNotFound) && ok {
+			artifactID, err = id, nil
+		}
+		if errors.Is(err, errLinkNotFound) {
+			writeError(w, r, http.StatusGone, codeGone, "This link has expired or was already used")
+			return
+		}
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading link")
+			return
+		}
+		serveArtifactByID(w, r, artifactID)
+		return
+	}
+	if artifactID, ok := linkRangeArtifact(token, event); ok && r.Header.Get("Range") != "" {
+		if !rangesFrom(r.Header.Get("Range"), linkProgress(token, event), artifactID) {
+			w.Header().Set(headerRetryAllowed, "false")
+			writeError(w, r, http.StatusGone, codeGone, "This part of the file was already downloaded through the link")
+			return
+		}
+		linkRangeRequests.Inc()
+		cw := newCountingResponseWriter(w)
+		serveArtifactByID(cw, r, artifactID)
+		recordLinkProgress(token, event, w.Header(), cw.n)
+		return
+	}
+	artifactID, err := jobstore.PeekLink(token)
+	if errors.Is(err, errLinkNotFound) {
+		event.Status = LinkDownloadRejected
+		recordLinkEvent(token, event, true)
+		w.Header().Set(headerRetryAllowed, "false")
+		writeError(w, r, http.StatusGone, codeGone, "This link has expired or was already used")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading link")
+		return
+	}
+	claim, ok := claimOneTimeLink(token, event)
+	if !ok {
+		w.Header().Set(headerRetryAllowed, "true")
+		w.Header().Set("Retry-After", "60")
+		writeError(w, r, http.StatusConflict, codeConflict, "This link is already being downloaded")
+		return
+	}
+	defer releaseOneTimeLink(token, claim)
+
+	mode := linkConsumption(token)
+	cw := newCountingResponseWriter(w)
+	rec := &statusRecorder{ResponseWriter: cw}
+	out := http.ResponseWriter(rec)
+	consumed := false
+	if mode == LinkConsumeFirstByte {
+		out = &commitHookWriter{ResponseWriter: rec, onCommit: func(status int) {
+			if status == http.StatusOK || status == http.StatusPartialContent {
+				consumed = finishOneTimeLink(r, token, artifactID, event)
+			}
+		}}
+		w.Header().Set(headerRetryAllowed, "false")
+	} else {
+		w.Header().Set(headerRetryAllowed, "true")
+	}
+	if mode == LinkConsumeConfirm {
+		w.Header().Set("X-Link-Confirm-URL", oneTimeLinkURL(token)+"/confirm")
+	}
+	serveArtifactByID(out, r, artifactID)
+
+	transfer := cw.transfer()
+	event.Bytes = transfer.Bytes
+	event.DurationSeconds = transfer.Duration.Seconds()
+	event.BytesPerSecond = transfer.Speed()
+	event.Status = LinkDownloadIncomplete
+	if artifact, err := getArtifact(artifactID); err == nil && cw.n >= artifact.Size {
+		event.Status = LinkDownloadCompleted
+	}
+	recordLinkEvent(token, event, false)
+
+	switch {
+	case mode == LinkConsumeCompletion && delivered(rec, cw):
+		finishOneTimeLink(r, token, artifactID, event)
+	case consumed && !delivered(rec, cw):
+		linkPartialFailures.Inc()
+	case mode != LinkConsumeFirstByte && sentFile(rec, cw) && linkAttemptsExhausted(token):
+		finishOneTimeLink(r, token, artifactID, event)
+	}
+	recordLinkProgress(token, event, w.Header(), cw.n)
+}
+
+func finishOneTimeLink(r *http.Request, token, artifactID string, event LinkEvent) bool {
+	if _, err := consumeOneTimeLink(token); err != nil {
+		if !errors.Is(err, errLinkNotFound) {
+			log.Printf("link %s: consuming: %v", token, err)
+		}
+		return false
+	}
+	events.Publish(EventLinkConsumed, requestTenant(r).ID, map[string]string{"artifact_id": artifactID})
+	openLinkRanges(token, artifactID, event)
+	return true
+}
+
+func handleConfirmOneTimeLink(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	if _, err := jobstore.PeekLink(token); errors.Is(err, errLinkNotFound) {
+		writeError(w, r, http.StatusGone, codeGone, "This link has expired or was already used")
+		return
+	}
+	if linkConsumption(token) != LinkConsumeConfirm {
+		writeError(w, r, http.StatusConflict, codeConflict, "This link is used up without confirmation")
+		return
+	}
+	artifactID, err := consumeOneTimeLink(token)
+	if errors.Is(err, errLinkNotFound) {
+		writeError(w, r, http.StatusGone, codeGone, "This link has expired or was already used")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading link")
+		return
+	}
+	events.Publish(EventLinkConsumed, requestTenant(r).ID, map[string]string{"artifact_id": artifactID})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type commitHookWriter struct {
+	http.ResponseWriter
+	onCommit  func(status int)
+	committed bool
+}
+
+func (w *commitHookWriter) WriteHeader(status int) {
+	if !w.committed {
+		w.committed = true
+		w.onCommit(status)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *commitHookWriter) Write(p []byte) (int, error) {
+	if !w.committed {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *commitHookWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func sentFile(rec *statusRecorder, cw *countingResponseWriter) bool {
+	return (rec.status == http.StatusOK || rec.status == http.StatusPartialContent) && cw.n > 0
+}
+
+func delivered(rec *statusRecorder, cw *countingResponseWriter) bool {
+	if rec.status != 0 && rec.status != http.StatusOK && rec.status != http.StatusPartialContent {
+		return false
+	}
+	size, err := strconv.ParseInt(rec.Header().Get("Content-Length"), 10, 64)
+	return err == nil && cw.n >= size
+}
+
+func handleJobLinkStats(w http.ResponseWriter, r *http.Request) {
+	job, err := getJob(r.PathValue("id"))
+	if errors.Is(err, errJobNotFound) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Job not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading job")
+		return
+	}
+	if !ownsJob(r, job) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Job not found")
+		return
+	}
+	if job.OneTimeURL == "" {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Job has no one-time link")
+		return
+	}
+
+	q, ok := parseListQuery(w, r)
+	if !ok {
+		return
+	}
+	stats, err := linkEvents(path.Base(job.OneTimeURL))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading link statistics")
+		return
+	}
+	downloaded := false
+	for _, event := range stats {
+		if event.Status == LinkDownloadCompleted {
+			downloaded = true
+		}
+	}
+	events, next := paginate(stats, q, func(event LinkEvent) (time.Time, string, string) {
+		return event.At, event.ID, event.Status
+	})
+	body := map[string]any{
+		"url":        job.OneTimeURL,
+		"downloaded": downloaded,
+		"events":     events,
+	}
+	if next != "" {
+		body["next_cursor"] = next
+	}
+	writeJSON(w, http.StatusOK, body)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type brokenResponseWriter struct {
+	*httptest.ResponseRecorder
+	limit int
+}
+
+func (w *brokenResponseWriter) Write(p []byte) (int, error) {
+	if w.Body.Len()+len(p) > w.limit {
+		n, _ := w.ResponseRecorder.Write(p[:w.limit-w.Body.Len()])
+		return n, errors.New("connection reset")
+	}
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestOneTimeLinkConsumptionModes(t *testing.T) {
+	setupTestServer(t, nil)
+	prevStore, prevJobstore, prevKeyring := store, jobstore, keyring
+	t.Cleanup(func() { store, jobstore, keyring = prevStore, prevJobstore, prevKeyring })
+	jobstore, keyring = redisJobStore{}, nil
+	var err error
+	if store, err = newContentStore(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := store.JobDir("", "", "job1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "video.mp4")
+	if err := os.WriteFile(path, []byte(strings.Repeat("x", 100<<10)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	artifact, err := store.AddArtifact(&Job{ID: "job1", Tenant: "default"}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		mode        string
+		interrupted bool
+		confirm     bool
+		used        bool
+	}{
+		{LinkConsumeFirstByte, false, false, true},
+		{LinkConsumeFirstByte, true, false, true},
+		{LinkConsumeCompletion, false, false, true},
+		{LinkConsumeCompletion, true, false, false},
+		{LinkConsumeConfirm, false, false, false},
+		{LinkConsumeConfirm, true, true, true},
+	}
+	for _, tt := range tests {
+		name := tt.mode
+		if tt.interrupted {
+			name += " interrupted"
+		}
+		if tt.confirm {
+			name += " confirmed"
+		}
+		t.Run(name, func(t *testing.T) {
+			token, err := createOneTimeLink(artifact.ID, time.Hour, tt.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/d/"+token, nil)
+			req.SetPathValue("token", token)
+			rec := httptest.NewRecorder()
+			var w http.ResponseWriter = rec
+			if tt.interrupted {
+				w = &brokenResponseWriter{ResponseRecorder: rec, limit: 1000}
+			}
+			handleOneTimeLink(w, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("download answered %d", rec.Code)
+			}
+			if complete := int64(rec.Body.Len()) == artifact.Size; complete == tt.interrupted {
+				t.Fatalf("sent %d of %d bytes", rec.Body.Len(), artifact.Size)
+			}
+			if tt.confirm {
+				req := httptest.NewRequest(http.MethodPost, "/d/"+token+"/confirm", nil)
+				req.SetPathValue("token", token)
+				rec := httptest.NewRecorder()
+				handleConfirmOneTimeLink(rec, req)
+				if rec.Code != http.StatusNoContent {
+					t.Fatalf("confirm answered %d", rec.Code)
+				}
+			}
+			_, err = jobstore.PeekLink(token)
+			if used := errors.Is(err, errLinkNotFound); used != tt.used {
+				t.Errorf("link used = %v, want %v", used, tt.used)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	errLockHeld = errors.New("lock is held by another instance")
+	errLockLost = errors.New("lock was lost")
+)
+
+var instanceID = func() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}()
+
+var (
+	lockAcquired  = metrics.Counter("odl_lock_acquired_total", "Locks acquired.", "lock")
+	lockContended = metrics.Counter("odl_lock_contended_total", "Lock attempts that found the lock already held.", "lock")
+	lockLost      = metrics.Counter("odl_lock_lost_total", "Locks lost before release, including fenced writes that were rejected.", "lock")
+	lockBroken    = metrics.Counter("odl_lock_broken_total", "Locks broken by an administrator.", "lock")
+	lockHeld      = metrics.Counter("odl_lock_held_seconds_total", "Total time locks were held.", "lock")
+)
+
+var (
+	extendLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+	fencedSetScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+redis.call("SET", KEYS[2], ARGV[2])
+return 1`)
+)
+
+type Lock struct {
+	Name  string
+	Fence int64
+
+	key      string
+	token    string
+	acquired time.Time
+	stop     chan struct{}
+	once     sync.Once
+}
+
+type LockInfo struct {
+	Name   string `json:"name"`
+	Holder string `json:"holder"`
+	Fence  int64  `json:"fence"`
+	TTLMS  int64  `json:"ttl_ms"`
+}
+
+func lockKey(name string) string {
+	return fmt.Sprintf("lock:%s", name)
+}
+
+func lockFenceKey(name string) string {
+	return fmt.Sprintf("lockfence:%s", name)
+}
+
+func acquireLock(name string, ttl time.Duration) (*Lock, error) {
+	fence, err := rdb.Incr(ctx, lockFenceKey(name)).Result()
+	if err != nil {
+		return nil, err
+	}
+	token := fmt.Sprintf("%d:%s", fence, instanceID)
+	ok, err := rdb.SetNX(ctx, lockKey(name), token, ttl).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		lockContended.Inc(lockMetricName(name))
+		return nil, errLockHeld
+	}
+	lockAcquired.Inc(lockMetricName(name))
+
+	l := &Lock{Name: name, Fence: fence, key: lockKey(name), token: token, acquired: time.Now(), stop: make(chan struct{})}
+	go l.keepAlive(ttl)
+	return l, nil
+}
+
+func (l *Lock) keepAlive(ttl time.Duration) {
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+		n, err := extendLockScript.Run(ctx, rdb, []string{l.key}, l.token, ttl.Milliseconds()).Int()
+		if err == nil && n == 0 {
+			log.Printf("lock %s: lost (fence %d)", l.Name, l.Fence)
+			lockLost.Inc(lockMetricName(l.Name))
+			return
+		}
+	}
+}
+
+func (l *Lock) SetIfHeld(key string, value any) error {
+	n, err := fencedSetScript.Run(ctx, rdb, []string{l.key, key}, l.token, value).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		lockLost.Inc(lockMetricName(l.Name))
+		return errLockLost
+	}
+	return nil
+}
+
+func (l *Lock) Release() {
+	l.once.Do(func() {
+		close(l.stop)
+		releaseLockScript.Run(ctx, rdb, []string{l.key}, l.token)
+		lockHeld.Add(time.Since(l.acquired).Seconds(), lockMetricName(l.Name))
+	})
+}
+
+func lockMetricName(name string) string {
+	kind, _, _ := strings.Cut(name, ":")
+	return kind
+}
+
+func listLocks() ([]LockInfo, error) {
+	keys, err := scanKeys("lock:*")
+	if err != nil {
+		return nil, err
+	}
+	locks := make([]LockInfo, 0, len(keys))
+	for _, key := range keys {
+		token, err := rdb.Get(ctx, key).Result()
+		if err != nil {
+			continue
+		}
+		ttl, _ := rdb.PTTL(ctx, key).Result()
+		fence, holder, _ := strings.Cut(token, ":")
+		info := LockInfo{Name: strings.TrimPrefix(key, "lock:"), Holder: holder, TTLMS: ttl.Milliseconds()}
+		info.Fence, _ = strconv.ParseInt(fence, 10, 64)
+		locks = append(locks, info)
+	}
+	return locks, nil
+}
+
+func breakLock(name string) (bool, error) {
+	n, err := rdb.Del(ctx, lockKey(name)).Result()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	rdb.Incr(ctx, lockFenceKey(name))
+	lockBroken.Inc(lockMetricName(name))
+	return true, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	logLevelInfo  = "info"
+	logLevelDebug = "debug"
+	logLevelKey   = "log_level"
+	logLevelSync  = 15 * time.Second
+	logFileStamp  = "20060102-150405"
+)
+
+var debugLogging atomic.Bool
+
+func debugf(format string, args ...any) {
+	if debugLogging.Load() {
+		log.Printf("debug: "+format, args...)
+	}
+}
+
+func setLogLevel(level string) error {
+	switch level {
+	case logLevelInfo:
+		debugLogging.Store(false)
+	case logLevelDebug:
+		debugLogging.Store(true)
+	default:
+		return fmt.Errorf("Unknown log level %q, use info or debug", level)
+	}
+	return nil
+}
+
+func currentLogLevel() string {
+	if debugLogging.Load() {
+		return logLevelDebug
+	}
+	return logLevelInfo
+}
+
+type rotatingLog struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func openRotatingLog(path string) (*rotatingLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return nil, err
+	}
+	l := &rotatingLog{path: path}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *rotatingLog) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file, l.size, l.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+func (l *rotatingLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.dueForRotation(int64(len(p))) {
+		if err := l.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "log rotation failed: %v\n", err)
+		}
+	}
+	n, err := l.file.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+func (l *rotatingLog) dueForRotation(n int64) bool {
+	if l.size == 0 {
+		return false
+	}
+	if cfg.LogMaxSize > 0 && l.size+n > cfg.LogMaxSize {
+		return true
+	}
+	return cfg.LogRotateEvery > 0 && time.Since(l.opened) >= cfg.LogRotateEvery
+}
+
+func (l *rotatingLog) rotate() error {
+	l.file.Close()
+	rotated := l.path + "." + time.Now().UTC().Format(logFileStamp)
+	if err := os.Rename(l.path, rotated); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+	l.prune()
+	return nil
+}
+
+func (l *rotatingLog) prune() {
+	rotated, err := filepath.Glob(l.path + ".*")
+	if err != nil {
+		return
+	}
+	slices.Sort(rotated)
+	slices.Reverse(rotated)
+	for i, path := range rotated {
+		stamp, err := time.Parse(logFileStamp, strings.TrimPrefix(path, l.path+"."))
+		if err != nil {
+			continue
+		}
+		tooMany := cfg.LogMaxBackups > 0 && i >= cfg.LogMaxBackups
+		tooOld := cfg.LogMaxAge > 0 && time.Since(stamp) > cfg.LogMaxAge
+		if tooMany || tooOld {
+			os.Remove(path)
+		}
+	}
+}
+
+func setupLogging() error {
+	if err := setLogLevel(cfg.LogLevel); err != nil {
+		return err
+	}
+	if cfg.LogFile == "" {
+		return nil
+	}
+	file, err := openRotatingLog(cfg.LogFile)
+	if err != nil {
+		return err
+	}
+	log.SetOutput(file)
+	return nil
+}
+
+func syncLogLevel() {
+	level, err := rdb.Get(ctx, logLevelKey).Result()
+	if err == redis.Nil {
+		level = cfg.LogLevel
+	} else if err != nil {
+		return
+	}
+	if level != currentLogLevel() && setLogLevel(level) == nil {
+		log.Printf("log level set to %s", level)
+	}
+}
+
+func runLogLevelSync() {
+	ticker := time.NewTicker(logLevelSync)
+	defer ticker.Stop()
+	for range ticker.C {
+		syncLogLevel()
+	}
+}
+
+func handleGetLogLevel(w http.ResponseWriter, r *http.Request) {
+	resp := map[string]any{"level": currentLogLevel(), "default": cfg.LogLevel}
+	if ttl, err := rdb.TTL(ctx, logLevelKey).Result(); err == nil && ttl > 0 {
+		resp["reverts_at"] = time.Now().Add(ttl).UTC().Truncate(time.Second)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func handleSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Level   string `json:"level"`
+		Minutes int    `json:"minutes"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Minutes < 0 {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid minutes")
+		return
+	}
+	if err := setLogLevel(req.Level); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
+	if err := rdb.Set(ctx, logLevelKey, req.Level, time.Duration(req.Minutes)*time.Minute).Err(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error saving log level")
+		return
+	}
+	log.Printf("log level set to %s", req.Level)
+	handleGetLogLevel(w, r)
+}
@@ -1,269 +1,504 @@
 package main
 
 import (
+	"cmp"
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"regexp"
+	"strconv"
 	"strings"
-	"time"
 
 	"github.com/jimmymuthoni/onetimedownload/utils"
 	"github.com/joho/godotenv"
 	"github.com/redis/go-redis/v9"
 )
 
-type VideoRequest struct {
-	URL string `json:"url"`
-}
-
-type VideoResponse struct {
-	URL       string `json:"url"`
-	Source    string `json:"source"`
-	ID        string `json:"id"`
-	Author    string `json:"author"`
-	Title     string `json:"title"`
-	Thumbnail string `json:"thumbnail"`
-	Medias    []struct {
-		FormatID string `json:"format_id"`
-		Quality  string `json:"quality"`
-		Width    int    `json:"width"`
-		Height   int    `json:"height"`
-		Ext      string `json:"ext"`
-	} `json:"medias"`
-	Error bool `json:"error"`
-}
-
-type YTDLPOutput struct {
-	ID         string `json:"id"`
-	Title      string `json:"title"`
-	Uploader   string `json:"uploader"`
-	Thumbnail  string `json:"thumbnail"`
-	WebpageURL string `json:"webpage_url"`
-	Formats    []struct {
-		FormatID string `json:"format_id"`
-		Ext      string `json:"ext"`
-		Format   string `json:"format"`
-		Width    int    `json:"width"`
-		Height   int    `json:"height"`
-		Acodec   string `json:"acodec"`
-		Vcodec   string `json:"vcodec"`
-		FPS      int    `json:"fps"`
-		Filesize int64  `json:"filesize"`
-	} `json:"formats"`
-}
-
 var ctx = context.Background()
 var rdb *redis.Client
 
 var formatIDRegex = regexp.MustCompile(`^[a-zA-Z0-9+_-]+$`)
 
+const mergeOutputFormat = "mp4"
+
 func isValidFormatID(id string) bool {
 	return id != "" && formatIDRegex.MatchString(id)
 }
 
+func isAllowedVideoURL(r *http.Request, videoURL string) bool {
+	noteURLSubmission(r, videoURL)
+	return videoURL != "" && validateVideoURL(videoURL) && requestTenant(r).AllowsURL(videoURL)
+}
+
+func downloadLink(ticket string, videoData *VideoResponse, formatID string) string {
+	q := url.Values{
+		"format":   {formatID},
+		"filename": {strings.ReplaceAll(videoData.Title, "/", "-")},
+	}
+	return cfg.PublicURL + "/download/" + ticket + "?" + q.Encode()
+}
+
+func resolveOutputFormat(videoData *VideoResponse, formatID string) (string, string) {
+	if videoData != nil && !strings.Contains(formatID, "+") {
+		if media, ok := videoData.FindMedia(formatID); ok && media.Ext != "" {
+			return media.Ext, utils.MediaType(media.Ext, media.AudioOnly())
+		}
+	}
+	return mergeOutputFormat, utils.MediaType(mergeOutputFormat, false)
+}
+
 func main() {
+	migrateOnly := flag.Bool("migrate-only", false, "apply job store migrations and exit")
+	flag.Parse()
+
 	if os.Getenv("RAILWAY_ENVIRONMENT") == "" {
 		_ = godotenv.Load()
 	}
 
-	redisAddr := os.Getenv("REDIS_URL")
-	if redisAddr == "" {
-		redisAddr = "redis:6379"
+	cfg = loadConfig()
+	if err := setupLogging(); err != nil {
+		log.Fatalf("Logging setup failed: %v", err)
+	}
+	if err := setupRunner(); err != nil {
+		log.Fatalf("Runner setup failed: %v", err)
+	}
+	if cfg.ExecRunner == "fixtures" {
+		log.Printf("Answering yt-dlp calls from fixtures in %s", cfg.ExecFixturesDir)
+	}
+
+	if err := loadTrustedProxies(); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	if !linkConsumptionModes[cfg.LinkConsumption] {
+		log.Fatalf("Invalid LINK_CONSUMPTION %q, use first_byte, completion or confirm", cfg.LinkConsumption)
+	}
+
+	if *migrateOnly {
+		if _, err := newJobStore(cfg.JobStore, cfg.JobStoreDSN); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		log.Printf("Job store %q is up to date", cfg.JobStore)
+		return
 	}
 
 	rdb = redis.NewClient(&redis.Options{
-		Addr:     redisAddr,
-		Password: os.Getenv("REDIS_PASSWORD"),
-		DB:       0,
+		Addr:         cfg.RedisAddr,
+		Password:     cfg.RedisPassword,
+		DB:           0,
+		PoolSize:     cfg.RedisPoolSize,
+		MinIdleConns: cfg.RedisMinIdleConns,
+		DialTimeout:  cfg.RedisDialTimeout,
+		ReadTimeout:  cfg.RedisReadTimeout,
+		WriteTimeout: cfg.RedisWriteTimeout,
+		PoolTimeout:  cfg.RedisPoolTimeout,
 	})
 
 	if _, err := rdb.Ping(ctx).Result(); err != nil {
 		log.Fatalf("Redis connection failed: %v", err)
 	}
+	if err := syncFlags(); err != nil {
+		log.Fatalf("Loading feature flags failed: %v", err)
+	}
+	if cfg.YTDLPWorkers > 0 {
+		ytdlpWorkers = newYTDLPWorkerPool(cfg.YTDLPWorkers)
+	}
 
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		http.ServeFile(w, r, "templates/index.html")
-	})
+	if cfg.TenantsFile != "" {
+		if err := loadTenants(cfg.TenantsFile); err != nil {
+			log.Fatalf("Loading tenants failed: %v", err)
+		}
+	}
 
-	http.HandleFunc("/submit", func(w http.ResponseWriter, r *http.Request) {
-		if err := r.ParseForm(); err != nil {
-			http.Error(w, "Error Parsing Form", http.StatusBadRequest)
-			return
+	var err error
+	if events, err = newEventBus(cfg.EventSinks); err != nil {
+		log.Fatalf("Event bus initialization failed: %v", err)
+	}
+	if store, err = newContentStore(cfg.StorageDir); err != nil {
+		log.Fatalf("Content store initialization failed: %v", err)
+	}
+	if keyring, err = loadStorageKeys(); err != nil {
+		log.Fatalf("Loading storage encryption keys failed: %v", err)
+	}
+	if keyring != nil {
+		log.Printf("Encrypting new artifacts with key %q", keyring.active)
+	}
+	if jobstore, err = newJobStore(cfg.JobStore, cfg.JobStoreDSN); err != nil {
+		log.Fatalf("Job store initialization failed: %v", err)
+	}
+	if queue, err = newJobQueue(cfg.QueueBackend); err != nil {
+		log.Fatalf("Queue initialization failed: %v", err)
+	}
+	if validator, err = newURLValidator(cfg.URLValidator); err != nil {
+		log.Fatalf("URL validator initialization failed: %v", err)
+	}
+	if scanner, err = newFileScanner(cfg.Scanner); err != nil {
+		log.Fatalf("Scanner initialization failed: %v", err)
+	}
+	if coldStore, err = newColdStore(cfg.ColdTier); err != nil {
+		log.Fatalf("Cold tier initialization failed: %v", err)
+	}
+	if offPeak, err = loadOffPeakWindow(); err != nil {
+		log.Fatalf("Invalid off-peak window: %v", err)
+	}
+	if cfg.BandwidthBudget > 0 && cfg.BandwidthWindow <= 0 {
+		log.Fatalf("BANDWIDTH_BUDGET_WINDOW_HOURS must be at least 1")
+	}
+	if err := validateCORS(); err != nil {
+		log.Fatalf("Invalid CORS settings: %v", err)
+	}
+	if cfg.StreamBufferSize < 1024 {
+		log.Fatalf("STREAM_BUFFER_KB must be at least 1")
+	}
+	if cfg.DiskFullAction != diskFullDefer && cfg.DiskFullAction != diskFullReject {
+		log.Fatalf("DISK_FULL_ACTION must be %q or %q", diskFullDefer, diskFullReject)
+	}
+	if _, err := compileACL(defaultAccessList()); err != nil {
+		log.Fatalf("Invalid access list: %v", err)
+	}
+	if access.geoip, err = openGeoIP(cfg.GeoIPDB); err != nil {
+		log.Fatalf("GeoIP database failed to open: %v", err)
+	}
+	if cfg.JobLogDir != "" {
+		if err := os.MkdirAll(cfg.JobLogDir, 0o750); err != nil {
+			log.Fatalf("Creating job log directory failed: %v", err)
+		}
+	}
+	if reporter, err = newErrorReporter(cfg.SentryDSN); err != nil {
+		log.Fatalf("Error reporting initialization failed: %v", err)
+	}
+	if err := loadProxies(cfg.Proxies, cfg.ProxiesFile); err != nil {
+		log.Fatalf("Loading proxies failed: %v", err)
+	}
+	go store.runRetention()
+	go rebuildStorageUsage()
+	go runColdTiering()
+	startWorkers(cfg.Workers)
+	if cfg.Workers > 0 {
+		go runWorkerHeartbeat(cfg.Workers)
+	}
+	go runScheduler()
+	go runJobArchival()
+	go runProxyHealthChecks()
+	go runLogLevelSync()
+	go runFlagSync()
+	go runSubscriptions()
+	go runWatchLater()
+
+	routes := newRouter()
+	pages := routes.Group("", withCompression)
+	media := routes.Group("")
+	api := routes.Group("/api/v1", withCORS, withRateLimit, withCompression)
+	apiMedia := routes.Group("/api/v1", withCORS, withRateLimit)
+	extension := api.Group("/extension", withExtensionCORS, withAPIKey)
+	admin := routes.Group("/admin", withAdminToken, withCompression)
+
+	pages.Handle("GET /static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+	pages.HandleFunc("GET /{$}", handleIndex)
+
+	pages.HandleFunc("GET /admin/subscriptions.ics", requireFeedToken(handleSubscriptionCalendar))
+	pages.HandleFunc("GET /manifest.webmanifest", handleManifest)
+	pages.HandleFunc("GET /sw.js", handleServiceWorker)
+	pages.HandleFunc("GET /share-target", handleShareTarget)
+
+	pages.HandleFunc("POST /submit", duringMaintenance(handleSubmit))
+	pages.HandleFunc("GET /fetch/{encoded}", duringMaintenance(handleFetch))
+
+	pages.HandleFunc("POST /schedule", duringMaintenance(requireFlag(flagSchedules, handleSchedule)))
+	pages.HandleFunc("POST /bundle", duringMaintenance(requireFlag(flagBundles, handleBundle)))
+
+	api.HandleFunc("GET /metadata", handleMetadata)
+	api.HandleFunc("GET /formats", handleFormats)
+	api.HandleFunc("POST /batches", duringMaintenance(requireFlag(flagBatches, handleCreateBatch)))
+	api.HandleFunc("GET /batches/{id}", handleGetBatch)
+	api.HandleFunc("GET /batches/{id}/events", handleBatchEvents)
+	api.HandleFunc("POST /bookmarks/import", duringMaintenance(requireFlag(flagBatches, handleImportBookmarks)))
+	api.HandleFunc("POST /jobs", duringMaintenance(handleCreateJob))
+	api.HandleFunc("GET /jobs", handleListJobs)
+	api.HandleFunc("POST /jobs/bulk", duringMaintenance(handleBulkJobs))
+	api.HandleFunc("GET /jobs/{id}", handleGetJob)
+	api.HandleFunc("GET /jobs/{id}/link-stats", handleJobLinkStats)
+	api.HandleFunc("GET /jobs/{id}/logs", handleJobLogs)
+	api.HandleFunc("GET /stats", statsAccess(handleStats))
+	api.HandleFunc("GET /usage", handleStorageUsage)
+	api.HandleFunc("GET /download-archive", handleExportDownloadArchive)
+	api.HandleFunc("POST /download-archive", duringMaintenance(handleImportDownloadArchive))
+	api.HandleFunc("GET /history/export", handleExportHistory)
+	api.HandleFunc("GET /about", handleAbout)
+	api.HandleFunc("GET /notices", handleListNotices)
+	api.HandleFunc("GET /sites", handleSites)
+	api.HandleFunc("POST /diagnose", requireAdmin(handleDiagnose))
+	media.HandleFunc("GET /d/{token}", handleOneTimeLink)
+	media.HandleFunc("POST /d/{token}", handleOneTimeLink)
+	media.HandleFunc("POST /d/{token}/confirm", handleConfirmOneTimeLink)
+	api.HandleFunc("GET /artifacts/{id}", handleGetArtifact)
+	apiMedia.HandleFunc("GET /artifacts/{id}/download", handleDownloadArtifact)
+	apiMedia.HandleFunc("POST /artifacts/{id}/download", handleDownloadArtifact)
+	api.HandleFunc("GET /artifacts/{id}/checksum", handleArtifactChecksum)
+	apiMedia.HandleFunc("GET /artifacts/{id}/torrent", handleArtifactTorrent)
+	api.HandleFunc("DELETE /artifacts/{id}", handleDeleteArtifact)
+
+	api.HandleFunc("POST /subscriptions", duringMaintenance(requireFlag(flagSubscriptions, handleCreateSubscription)))
+	api.HandleFunc("GET /subscriptions", requireFlag(flagSubscriptions, handleListSubscriptions))
+	api.HandleFunc("DELETE /subscriptions/{id}", handleDeleteSubscription)
+	api.HandleFunc("GET /watch-later", requireFlag(flagWatchLater, handleListWatchLater))
+	api.HandleFunc("POST /watch-later", duringMaintenance(requireFlag(flagWatchLater, handleAddWatchLater)))
+	api.HandleFunc("PUT /watch-later/settings", requireFlag(flagWatchLater, handleWatchLaterSettings))
+	api.HandleFunc("DELETE /watch-later/{id}", handleDeleteWatchLater)
+
+	extension.HandleFunc("GET /resolve", requireFlag(flagExtension, handleExtensionResolve))
+	extension.HandleFunc("POST /queue", requireFlag(flagExtension, duringMaintenance(handleExtensionQueue)))
+	extension.HandleFunc("POST /watch-later", requireFlag(flagExtension, requireFlag(flagWatchLater, duringMaintenance(handleAddWatchLater))))
+	extension.HandleFunc("OPTIONS /{path...}", handleNotFound)
+	api.HandleFunc("OPTIONS /{path...}", handleNotFound)
+
+	admin.HandleFunc("GET /recycle", handleListRecycled)
+	admin.HandleFunc("POST /artifacts/{id}/restore", handleRestoreArtifact)
+	admin.HandleFunc("GET /users", handleListUsers)
+	admin.HandleFunc("GET /storage", handleAdminStorageUsage)
+	admin.HandleFunc("POST /users", handleCreateUser)
+	admin.HandleFunc("GET /speedtest", handleSpeedTest)
+	admin.HandleFunc("GET /locks", handleListLocks)
+	admin.HandleFunc("GET /proxies", handleListProxies)
+	admin.HandleFunc("GET /log-level", handleGetLogLevel)
+	admin.HandleFunc("GET /flags", handleListFlags)
+	admin.HandleFunc("PUT /flags/{name}", handleSetFlag)
+	admin.HandleFunc("DELETE /flags/{name}", handleResetFlag)
+	admin.HandleFunc("POST /notices", handleCreateNotice)
+	admin.HandleFunc("DELETE /notices/{id}", handleDeleteNotice)
+	admin.HandleFunc("GET /maintenance", handleGetMaintenance)
+	admin.HandleFunc("PUT /maintenance", handleStartMaintenance)
+	admin.HandleFunc("DELETE /maintenance", handleStopMaintenance)
+	admin.HandleFunc("PUT /log-level", handleSetLogLevel)
+	admin.HandleFunc("DELETE /locks/{name}", handleBreakLock)
+	admin.HandleFunc("GET /export", handleExportState)
+	admin.HandleFunc("POST /import", handleImportState)
+	admin.HandleFunc("GET /acl", handleGetACL)
+	admin.HandleFunc("PUT /acl", handleUpdateACL)
+	admin.HandleFunc("DELETE /acl", handleResetACL)
+	admin.HandleFunc("POST /jobs/bulk", handleAdminBulkJobs)
+	admin.HandleFunc("GET /jobs/stats", handleJobStats)
+	admin.HandleFunc("GET /jobs/{id}/logs", handleAdminJobLogs)
+	admin.HandleFunc("GET /bans", handleListBans)
+	admin.HandleFunc("POST /bans/{id}/review", handleReviewBan)
+	admin.HandleFunc("DELETE /bans/{id}", handleLiftBan)
+
+	if cfg.WebDAVEnabled {
+		routes.HandleFunc("/dav/", handleWebDAV)
+	}
+	if cfg.CastEnabled {
+		api.HandleFunc("POST /artifacts/{id}/cast", handleCreateCast)
+		media.HandleFunc("GET /cast/{token}", handleCastMedia)
+		pages.HandleFunc("GET /watch/{id}", handleWatch)
+		pages.HandleFunc("GET /dlna/description.xml", handleDLNADescription)
+		if cfg.SSDPEnabled {
+			go runSSDP()
 		}
+	}
+
+	routes.HandleFunc("GET /readyz", handleReadyz)
 
-		videoURL := r.FormValue("videoURL")
-		if videoURL == "" || !utils.ValidateURL(videoURL) {
-			http.Error(w, "Invalid or unsupported video URL", http.StatusBadRequest)
+	if cfg.MetricsEnabled {
+		routes.HandleFunc("GET /metrics", handleMetrics)
+	}
+
+	media.HandleFunc("GET /download", duringMaintenance(handleDownload))
+	media.HandleFunc("GET /download/{token}", duringMaintenance(handleDownload))
+
+	log.Printf("Server running on http://localhost:%s", cfg.Port)
+	log.Fatal(http.ListenAndServe(":"+cfg.Port, withRequestID(withAccessLog(withRecovery(withSecurityHeaders(withAccessControl(withAbuseProtection(withQueryLimits(withIdentity(withErrorReporting(routes)))))))))))
+}
+
+func handleIndex(w http.ResponseWriter, r *http.Request) {
+	page, err := os.ReadFile(requestTenant(r).Template)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading page")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Accept-CH", clientHintsHeader)
+	io.WriteString(w, injectNotices(string(page)))
+}
+
+func headDownload(w http.ResponseWriter, r *http.Request, pageURL, formatID, fileName string) {
+	var videoData *VideoResponse
+	if ytdlpData, ok := cachedYTDLPOutput(pageURL); ok {
+		if !ytdlpData.HasFormat(formatID) {
+			writeError(w, r, http.StatusBadRequest, codeFormatNotFound, fmt.Sprintf("Unknown format %q", formatID))
 			return
 		}
+		videoData = newVideoResponse(ytdlpData)
+	}
+	ext, contentType := resolveOutputFormat(videoData, formatID)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, utils.FileNameWithExt(fileName, ext)))
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Accept-Ranges", "none")
+	w.Header().Set("Trailer", "X-Content-Checksum")
+	if videoData != nil {
+		if media, ok := videoData.FindMedia(formatID); ok && media.EstimatedSize() > 0 {
+			w.Header().Set("X-Estimated-Content-Length", strconv.FormatInt(media.EstimatedSize(), 10))
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
 
-		videoData, err := fetchVideoMetaData(videoURL)
+func handleDownload(w http.ResponseWriter, r *http.Request) {
+	tenant := requestTenant(r)
+	ticket, err := lookupDownloadTicket(cmp.Or(r.PathValue("token"), r.URL.Query().Get("t")))
+	if errors.Is(err, errTicketNotFound) || (err == nil && ticket.Tenant != tenant.ID) {
+		writeError(w, r, http.StatusForbidden, codeGone, "Download link expired, submit the video URL again")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading download link")
+		return
+	}
+	pageURL := ticket.URL
+	formatID := r.URL.Query().Get("format")
+	if !isValidFormatID(formatID) {
+		writeError(w, r, http.StatusBadRequest, codeInvalidFormat, "Invalid format")
+		return
+	}
+	fileName := r.URL.Query().Get("filename")
+	if fileName == "" {
+		fileName = "video"
+	}
+	opts := DownloadOptions{Downloader: r.URL.Query().Get("downloader")}
+	if v := r.URL.Query().Get("fragments"); v != "" {
+		n, err := strconv.Atoi(v)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Error fetching video meta data: %v", err), http.StatusInternalServerError)
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid fragments, use a number")
 			return
 		}
-
-		sanitizedTitle := strings.ReplaceAll(videoData.Title, "/", "-")
-
-		fmt.Fprintf(w, `
-			<div class="mt-6 mb-20 p-4 rounded-lg shadow-2xl" x-data="{ selectedFormat: '%s', pageUrl: '%s' }">
-			<h3 class="text-lg font-bold mb-4">Video Details</h3>
-			<img src="%s" alt="Video Thumbnail" class="w-full rounded-md mb-4" />
-			<p class="text-white mb-2"><strong>Title:</strong> %s</p>
-			<p class="text-white mb-2"><strong>Author:</strong> %s</p>
-			<div class="mt-4">
-				<label for="qualitySelect" class="block mb-2">Select Quality</label>
-				<select id="qualitySelect" x-model="selectedFormat" class="w-full p-2 bg-neutral-800 text-white rounded-md border">`,
-			videoData.Medias[0].FormatID,
-			videoData.URL,
-			videoData.Thumbnail,
-			videoData.Title,
-			videoData.Author,
-		)
-
-		for _, media := range videoData.Medias {
-			label := media.Quality
-			if strings.Contains(label, "video only") || strings.Contains(label, "audio only") {
-				parts := strings.Split(label, " ")
-				res := ""
-				for _, p := range parts {
-					if strings.HasSuffix(p, "p") || strings.Contains(p, "x") {
-						res = p
-						break
-					}
-				}
-				if res == "" {
-					res = fmt.Sprintf("%dp", media.Height)
-				}
-				if strings.Contains(media.Quality, "audio only") {
-					label = "Audio only"
-				} else {
-					label = res
-				}
-			}
-			fmt.Fprintf(w, `<option value="%s">%s</option>`, media.FormatID, label)
+		opts.ConcurrentFragments = n
+	}
+	for _, raw := range r.URL.Query()["extractor_args"] {
+		key, value, err := parseExtractorArg(raw)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+			return
 		}
+		if opts.ExtractorArgs == nil {
+			opts.ExtractorArgs = make(map[string]string)
+		}
+		opts.ExtractorArgs[key] = value
+	}
+	if err := opts.normalize(); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, err.Error())
+		return
+	}
 
-		fmt.Fprintf(w, `
-			</select>
-		</div>
-		<a 
-			x-bind:href="'/download?url=' + encodeURIComponent(pageUrl) + '&filename=%s.mp4&format=' + encodeURIComponent(selectedFormat)" 
-			class="block mb-32 w-full mt-4 bg-red-900 text-center text-white p-3 rounded-md hover:bg-blue-600"
-			download
-		>
-			Download Video
-		</a>
-		</div>`, sanitizedTitle)
-	})
+	if !tenant.AllowsURL(pageURL) {
+		writeError(w, r, http.StatusBadRequest, codeUnsupportedURL, "Invalid or unsupported video URL")
+		return
+	}
+	if r.Method == http.MethodHead {
+		headDownload(w, r, pageURL, formatID, fileName)
+		return
+	}
+	if ok, err := tenant.ConsumeQuota("downloads", tenant.Quota.DailyDownloads); err != nil || !ok {
+		writeError(w, r, http.StatusTooManyRequests, codeQuotaExceeded, "Daily download quota exceeded")
+		return
+	}
 
-	http.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
-		pageURL := r.URL.Query().Get("url")
-		if pageURL == "" {
-			http.Error(w, "Missing video page URL", http.StatusBadRequest)
+	var videoData *VideoResponse
+	if ytdlpData, err := fetchYTDLPOutput(pageURL); err == nil {
+		if !ytdlpData.HasFormat(formatID) {
+			writeErrorDetails(w, r, http.StatusBadRequest, codeFormatNotFound, fmt.Sprintf("Unknown format %q, valid formats: %s", formatID, strings.Join(ytdlpData.FormatIDs(), ", ")), map[string]any{"valid_formats": ytdlpData.FormatIDs()})
 			return
 		}
-		formatID := r.URL.Query().Get("format")
-		if !isValidFormatID(formatID) {
-			http.Error(w, "Invalid format", http.StatusBadRequest)
+		videoData = newVideoResponse(ytdlpData)
+		if err := checkDownloadable(videoData); err != nil {
+			writeError(w, r, http.StatusUnprocessableEntity, codeNotDownloadable, err.Error())
 			return
 		}
-		fileName := r.URL.Query().Get("filename")
-		if fileName == "" {
-			fileName = "video.mp4"
-		}
-
-		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
-		w.Header().Set("Content-Type", "video/mp4")
-
-		cmd := exec.Command(
-			"yt-dlp",
-			"-f", formatID,
-			"--merge-output-format", "mp4",
-			"--prefer-ffmpeg",
-			"--no-mtime",
-			"-o", "-",
-			pageURL,
-		)
-		cmd.Stdout = w
-		cmd.Stderr = os.Stderr
-		if err := cmd.Run(); err != nil {
-			http.Error(w, "Failed to download video", http.StatusInternalServerError)
+		if media, ok := videoData.FindMedia(formatID); ok && exceedsMaxFilesize(media.EstimatedSize()) {
+			writeError(w, r, http.StatusRequestEntityTooLarge, codeFileTooLarge, fmt.Sprintf("Selected format exceeds the maximum download size of %s", utils.FormatBytes(cfg.MaxFilesize)))
 			return
 		}
-	})
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	} else if writeCooldownError(w, r, err) {
+		return
 	}
-	log.Printf("Server running on http://localhost:%s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
-}
+	ext, contentType := resolveOutputFormat(videoData, formatID)
+	fileName = utils.FileNameWithExt(fileName, ext)
 
-func fetchVideoMetaData(videoURL string) (*VideoResponse, error) {
-	parsedURL, err := url.ParseRequestURI(videoURL)
-	if err != nil || parsedURL.Scheme == "" || parsedURL.Host == "" {
-		return nil, err
-	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, fileName))
+	w.Header().Set("Content-Type", contentType)
 
-	cacheKey := fmt.Sprintf("video_meta:%s", videoURL)
-	if cacheData, err := rdb.Get(ctx, cacheKey).Result(); err == nil {
-		var v VideoResponse
-		if json.Unmarshal([]byte(cacheData), &v) == nil {
-			return &v, nil
-		}
+	args := []string{
+		"-f", formatID,
+		"--merge-output-format", mergeOutputFormat,
+		"--prefer-ffmpeg",
+		"--no-mtime",
 	}
-
-	cmd := exec.Command("yt-dlp", "-j", videoURL)
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, err
+	args = append(args, opts.args(true)...)
+	if cfg.MaxFilesize > 0 {
+		args = append(args, "--max-filesize", fmt.Sprint(cfg.MaxFilesize))
 	}
+	source := urlSource(pageURL)
+	proxy := pickProxy(source)
+	args = append(args, proxy.args()...)
+	args = append(args, "-o", "-", pageURL)
 
-	var ytdlpData YTDLPOutput
-	if err := json.Unmarshal(output, &ytdlpData); err != nil {
-		return nil, err
-	}
+	w.Header().Set("Trailer", strings.Join([]string{"X-Content-Checksum", headerTransferComplete, headerRetryAllowed, headerFailureClass}, ", "))
+	hash := sha256.New()
 
-	videoResp := &VideoResponse{
-		URL:       ytdlpData.WebpageURL,
-		ID:        ytdlpData.ID,
-		Author:    ytdlpData.Uploader,
-		Title:     ytdlpData.Title,
-		Thumbnail: ytdlpData.Thumbnail,
+	cmd := command("yt-dlp", args...)
+	stderr := &tailBuffer{}
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+	cw := newCountingResponseWriter(w)
+	completed := false
+	defer func() {
+		t := cw.transfer()
+		t.Incomplete = t.Bytes > 0 && !completed
+		recordTransfer("stream", t)
+		recordBandwidth(t.Bytes)
+	}()
+	sniffExt := ext
+	if strings.Contains(formatID, "+") {
+		sniffExt = ""
 	}
-
-	for _, f := range ytdlpData.Formats {
-		if f.FormatID == "" {
-			continue
+	if committed, err := streamCommand(cw, cmd, hash, sniffExt); err != nil {
+		if cw.n > 0 {
+			log.Printf("download %s: stopped after %s: %v", pageURL, utils.FormatBytes(cw.n), err)
+		}
+		w.Header().Set(headerTransferComplete, "false")
+		var mismatch *ContentMismatchError
+		if errors.As(err, &mismatch) {
+			log.Printf("download %s: %v", pageURL, err)
+			recordOutcome(pageURL, &YTDLPError{Class: failureContentMismatch, Err: err})
+			if committed && !acceptsTrailers(r) {
+				panic(http.ErrAbortHandler)
+			}
+			setRetryHint(w.Header(), failureContentMismatch, 0)
+			if !committed {
+				writeError(w, r, http.StatusBadGateway, codeUpstreamError, "Download stopped because "+err.Error())
+			}
+			return
 		}
-		if f.Vcodec == "none" && f.Acodec == "none" {
-			continue
+		class := classifyYTDLPFailure(stderr.String())
+		reportYTDLPFailure("stream", pageURL, source, stderr.String(), err)
+		recordOutcome(pageURL, &YTDLPError{Class: class, Err: err})
+		checkRateLimitOutput(source, proxy, stderr.String(), err)
+		if committed && !acceptsTrailers(r) {
+			panic(http.ErrAbortHandler)
 		}
-		videoResp.Medias = append(videoResp.Medias, struct {
-			FormatID string `json:"format_id"`
-			Quality  string `json:"quality"`
-			Width    int    `json:"width"`
-			Height   int    `json:"height"`
-			Ext      string `json:"ext"`
-		}{
-			FormatID: f.FormatID,
-			Quality:  f.Format,
-			Width:    f.Width,
-			Height:   f.Height,
-			Ext:      f.Ext,
-		})
-	}
-
-	cacheData, _ := json.Marshal(videoResp)
-	rdb.Set(ctx, cacheKey, cacheData, 5*time.Minute)
-	return videoResp, nil
-}
\ No newline at end of file
+		setRetryHint(w.Header(), class, retryDelay(source))
+		if committed {
+			return
+		}
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Failed to download video")
+		return
+	}
+	recordOutcome(pageURL, nil)
+	completed = true
+	w.Header().Set(headerTransferComplete, "true")
+	w.Header().Set("X-Content-Checksum", utils.ChecksumHeader(hex.EncodeToString(hash.Sum(nil))))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const maintenanceKey = "maintenance"
+
+type Maintenance struct {
+	Message string     `json:"message"`
+	Since   time.Time  `json:"since"`
+	Until   *time.Time `json:"until,omitempty"`
+}
+
+func activeMaintenance() (*Maintenance, bool) {
+	data, err := rdb.Get(ctx, maintenanceKey).Result()
+	if err != nil {
+		return nil, false
+	}
+	var m Maintenance
+	if json.Unmarshal([]byte(data), &m) != nil {
+		return nil, false
+	}
+	if m.Message == "" {
+		m.Message = cfg.MaintenanceMessage
+	}
+	return &m, true
+}
+
+func duringMaintenance(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m, on := activeMaintenance()
+		if !on {
+			next(w, r)
+			return
+		}
+		if m.Until != nil {
+			w.Header().Set("Retry-After", fmt.Sprint(int(time.Until(*m.Until).Seconds())+1))
+		}
+		writeError(w, r, http.StatusServiceUnavailable, codeMaintenance, m.Message)
+	}
+}
+
+func handleGetMaintenance(w http.ResponseWriter, r *http.Request) {
+	m, on := activeMaintenance()
+	writeJSON(w, http.StatusOK, map[string]any{"enabled": on, "maintenance": m})
+}
+
+func handleStartMaintenance(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Message string `json:"message"`
+		Minutes int    `json:"minutes"`
+	}
+	if r.ContentLength != 0 && !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Minutes < 0 {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid minutes")
+		return
+	}
+	m := Maintenance{Message: req.Message, Since: time.Now().UTC().Truncate(time.Second)}
+	ttl := time.Duration(req.Minutes) * time.Minute
+	if ttl > 0 {
+		until := m.Since.Add(ttl)
+		m.Until = &until
+	}
+	data, _ := json.Marshal(m)
+	if err := rdb.Set(ctx, maintenanceKey, data, ttl).Err(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error saving maintenance mode")
+		return
+	}
+	log.Printf("maintenance mode on")
+	handleGetMaintenance(w, r)
+}
+
+func handleStopMaintenance(w http.ResponseWriter, r *http.Request) {
+	if err := rdb.Del(ctx, maintenanceKey).Err(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error clearing maintenance mode")
+		return
+	}
+	log.Printf("maintenance mode off")
+	handleGetMaintenance(w, r)
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+type VideoRequest struct {
+	URL string `json:"url"`
+}
+
+type Media struct {
+	FormatID       string  `json:"format_id"`
+	Quality        string  `json:"quality"`
+	Width          int     `json:"width"`
+	Height         int     `json:"height"`
+	Ext            string  `json:"ext"`
+	Filesize       int64   `json:"filesize,omitempty"`
+	FilesizeApprox int64   `json:"filesize_approx,omitempty"`
+	FPS            float64 `json:"fps,omitempty"`
+	Vcodec         string  `json:"vcodec,omitempty"`
+	Acodec         string  `json:"acodec,omitempty"`
+	Bitrate        float64 `json:"bitrate,omitempty"`
+}
+
+func (m Media) AudioOnly() bool {
+	return m.Vcodec == "none" || strings.Contains(m.Quality, "audio only")
+}
+
+func (m Media) EstimatedSize() int64 {
+	if m.Filesize > 0 {
+		return m.Filesize
+	}
+	return m.FilesizeApprox
+}
+
+type VideoResponse struct {
+	URL         string  `json:"url"`
+	Source      string  `json:"source"`
+	ID          string  `json:"id"`
+	Author      string  `json:"author"`
+	Title       string  `json:"title"`
+	Thumbnail   string  `json:"thumbnail"`
+	Description string  `json:"description,omitempty"`
+	Duration    float64 `json:"duration,omitempty"`
+	UploadDate  string  `json:"upload_date,omitempty"`
+	ViewCount   int64   `json:"view_count,omitempty"`
+	LikeCount   int64   `json:"like_count,omitempty"`
+	LiveStatus  string  `json:"live_status,omitempty"`
+	IsLive      bool    `json:"is_live"`
+	WasLive     bool    `json:"was_live"`
+	ReleaseAt   int64   `json:"release_timestamp,omitempty"`
+	Medias      []Media `json:"medias"`
+	Error       bool    `json:"error"`
+}
+
+func (v *VideoResponse) FindMedia(formatID string) (Media, bool) {
+	for _, media := range v.Medias {
+		if media.FormatID == formatID {
+			return media, true
+		}
+	}
+	return Media{}, false
+}
+
+func (v *VideoResponse) DefaultMedia() Media {
+	for _, media := range v.Medias {
+		if !exceedsMaxFilesize(media.EstimatedSize()) {
+			return media
+		}
+	}
+	return v.Medias[0]
+}
+
+func (v *VideoResponse) BestCombinedMedia() Media {
+	for _, media := range v.Medias {
+		if media.Vcodec != "none" && media.Acodec != "none" && !exceedsMaxFilesize(media.EstimatedSize()) {
+			return media
+		}
+	}
+	return v.DefaultMedia()
+}
+
+func exceedsMaxFilesize(size int64) bool {
+	return cfg.MaxFilesize > 0 && size > cfg.MaxFilesize
+}
+
+func exceedsMaxDuration(seconds float64) bool {
+	return cfg.MaxDuration > 0 && seconds > cfg.MaxDuration.Seconds()
+}
+
+func (v *VideoResponse) IsUpcoming() bool {
+	return v.LiveStatus == "is_upcoming"
+}
+
+func checkDownloadable(videoData *VideoResponse) error {
+	if videoData.IsLive {
+		return errors.New("Live streams can't be downloaded while they are broadcasting, try again once the stream has ended")
+	}
+	if videoData.IsUpcoming() {
+		return errors.New("This video hasn't been released yet")
+	}
+	if exceedsMaxDuration(videoData.Duration) {
+		return fmt.Errorf("Videos longer than %s are not supported", cfg.MaxDuration)
+	}
+	return nil
+}
+
+func formatUploadDate(date string) string {
+	t, err := time.Parse("20060102", date)
+	if err != nil {
+		return date
+	}
+	return t.Format("2006-01-02")
+}
+
+type YTDLPFormat struct {
+	FormatID       string  `json:"format_id"`
+	Ext            string  `json:"ext"`
+	Format         string  `json:"format"`
+	Width          int     `json:"width"`
+	Height         int     `json:"height"`
+	Acodec         string  `json:"acodec"`
+	Vcodec         string  `json:"vcodec"`
+	FPS            float64 `json:"fps"`
+	TBR            float64 `json:"tbr"`
+	Filesize       int64   `json:"filesize"`
+	FilesizeApprox int64   `json:"filesize_approx"`
+}
+
+type YTDLPOutput struct {
+	ID          string        `json:"id"`
+	Title       string        `json:"title"`
+	Uploader    string        `json:"uploader"`
+	Thumbnail   string        `json:"thumbnail"`
+	Description string        `json:"description"`
+	WebpageURL  string        `json:"webpage_url"`
+	Extractor   string        `json:"extractor_key"`
+	Duration    float64       `json:"duration"`
+	UploadDate  string        `json:"upload_date"`
+	ViewCount   int64         `json:"view_count"`
+	LikeCount   int64         `json:"like_count"`
+	LiveStatus  string        `json:"live_status"`
+	IsLive      bool          `json:"is_live"`
+	WasLive     bool          `json:"was_live"`
+	ReleaseTS   int64         `json:"release_timestamp"`
+	Formats     []YTDLPFormat `json:"formats"`
+}
+
+func (o *YTDLPOutput) HasFormat(formatID string) bool {
+	for _, id := range strings.Split(formatID, "+") {
+		found := false
+		for _, f := range o.Formats {
+			if f.FormatID == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (o *YTDLPOutput) FormatIDs() []string {
+	ids := make([]string, 0, len(o.Formats))
+	for _, f := range o.Formats {
+		ids = append(ids, f.FormatID)
+	}
+	return ids
+}
+
+const (
+	metadataCacheTTL       = 5 * time.Minute
+	metadataRefreshTimeout = time.Minute
+	negativeCacheMarker    = "!"
+)
+
+var negativeCacheClasses = map[string]bool{"unsupported_url": true, "unavailable": true}
+
+var trackingParams = map[string]bool{"si": true, "feature": true, "fbclid": true, "igsh": true, "igshid": true}
+
+var (
+	metadataFlight        singleflight.Group
+	metadataFetchesShared = metrics.Counter("odl_metadata_fetches_shared_total", "Metadata lookups whose yt-dlp run was shared with concurrent lookups of the same URL.")
+)
+
+func fetchVideoMetaData(videoURL string) (*VideoResponse, error) {
+	ytdlpData, err := fetchYTDLPOutput(videoURL)
+	if err != nil {
+		return nil, err
+	}
+	return newVideoResponse(ytdlpData), nil
+}
+
+func fetchYTDLPOutput(videoURL string) (*YTDLPOutput, error) {
+	parsedURL, err := url.ParseRequestURI(videoURL)
+	if err != nil {
+		return nil, err
+	}
+	if parsedURL.Scheme == "" || parsedURL.Host == "" {
+		return nil, fmt.Errorf("invalid video URL %q", videoURL)
+	}
+
+	cacheKey := metadataCacheKey(videoURL)
+	pipe := rdb.Pipeline()
+	get := pipe.Get(ctx, cacheKey)
+	ttl := pipe.TTL(ctx, cacheKey)
+	pipe.Exec(ctx)
+	if cacheData, err := get.Result(); err == nil {
+		if cached, ok := negativeCacheError(cacheData); ok {
+			recordCacheLookup(true)
+			return nil, cached
+		}
+		var v YTDLPOutput
+		if json.Unmarshal([]byte(cacheData), &v) == nil {
+			recordCacheLookup(true)
+			if left, err := ttl.Result(); err == nil && left > 0 && left <= cfg.MetadataStaleTTL {
+				go revalidateMetadata(videoURL)
+			}
+			return &v, nil
+		}
+	}
+	recordCacheLookup(false)
+	return loadYTDLPOutputShared(videoURL)
+}
+
+func cachedYTDLPOutput(videoURL string) (*YTDLPOutput, bool) {
+	cacheData, err := rdb.Get(ctx, metadataCacheKey(videoURL)).Result()
+	if err != nil {
+		return nil, false
+	}
+	if _, negative := negativeCacheError(cacheData); negative {
+		return nil, false
+	}
+	var v YTDLPOutput
+	if json.Unmarshal([]byte(cacheData), &v) != nil {
+		return nil, false
+	}
+	return &v, true
+}
+
+func canonicalVideoURL(videoURL string) string {
+	u, err := url.Parse(videoURL)
+	if err != nil {
+		return videoURL
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+	u.Fragment = ""
+	q := u.Query()
+	for name := range q {
+		if strings.HasPrefix(name, "utm_") || trackingParams[name] {
+			q.Del(name)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func loadYTDLPOutputShared(videoURL string) (*YTDLPOutput, error) {
+	v, err, shared := metadataFlight.Do(canonicalVideoURL(videoURL), func() (any, error) {
+		return loadYTDLPOutput(videoURL)
+	})
+	if shared {
+		metadataFetchesShared.Inc()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v.(*YTDLPOutput), nil
+}
+
+func revalidateMetadata(videoURL string) {
+	ok, err := rdb.SetNX(ctx, metadataRefreshKey(videoURL), instanceID, metadataRefreshTimeout).Result()
+	if err != nil || !ok {
+		return
+	}
+	defer rdb.Del(ctx, metadataRefreshKey(videoURL))
+	if _, err := loadYTDLPOutputShared(videoURL); err != nil {
+		debugf("metadata: revalidating %s: %v", videoURL, err)
+	}
+}
+
+func loadYTDLPOutput(videoURL string) (*YTDLPOutput, error) {
+	cacheKey := metadataCacheKey(videoURL)
+	source := urlSource(videoURL)
+	if c, ok := activeCooldown(source); ok {
+		return nil, &CooldownError{Cooldown: c}
+	}
+
+	var output []byte
+	var err error
+	for attempt := 1; ; attempt++ {
+		proxy := pickProxy(source)
+		var stderr string
+		output, stderr, err = extractMetadata(ytdlpArgs(proxy), videoURL)
+		if err == nil {
+			break
+		}
+		err = &YTDLPError{Class: classifyYTDLPFailure(stderr), Err: err}
+		if err = checkRateLimit(source, proxy, err); !errors.Is(err, errProxyBlocked) || attempt >= maxProxyAttempts {
+			reportYTDLPFailure("metadata", videoURL, source, stderr, err)
+			cacheNegativeResult(cacheKey, stderr, err)
+			return nil, err
+		}
+	}
+
+	ytdlpData, err := parseYTDLPOutput(output)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheData, _ := json.Marshal(ytdlpData)
+	cacheTTL := metadataCacheTTL + cfg.MetadataStaleTTL
+	rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, cacheKey, cacheData, cacheTTL)
+		if ytdlpData.WebpageURL != "" && ytdlpData.WebpageURL != videoURL {
+			pipe.Set(ctx, metadataCacheKey(ytdlpData.WebpageURL), cacheData, cacheTTL)
+		}
+		return nil
+	})
+	return ytdlpData, nil
+}
+
+func parseYTDLPOutput(data []byte) (*YTDLPOutput, error) {
+	var out YTDLPOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func negativeCacheError(cacheData string) (error, bool) {
+	marked, ok := strings.CutPrefix(cacheData, negativeCacheMarker)
+	if !ok {
+		return nil, false
+	}
+	class, message, _ := strings.Cut(marked, ":")
+	return &YTDLPError{Class: class, Err: errors.New(message)}, true
+}
+
+func cacheNegativeResult(cacheKey, stderr string, err error) {
+	var ytdlpErr *YTDLPError
+	if cfg.MetadataNegativeTTL <= 0 || !errors.As(err, &ytdlpErr) || !negativeCacheClasses[ytdlpErr.Class] {
+		return
+	}
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	message := redactSecrets(strings.TrimSpace(lines[len(lines)-1]))
+	rdb.Set(ctx, cacheKey, negativeCacheMarker+ytdlpErr.Class+":"+message, cfg.MetadataNegativeTTL)
+}
+
+func metadataCacheKey(videoURL string) string {
+	return fmt.Sprintf("ytdlp_meta:%s", videoURL)
+}
+
+func metadataRefreshKey(videoURL string) string {
+	return fmt.Sprintf("ytdlp_meta_refresh:%s", videoURL)
+}
+
+func invalidateMetadata(videoURL string) {
+	rdb.Del(ctx, metadataCacheKey(videoURL))
+}
+
+func newVideoResponse(ytdlpData *YTDLPOutput) *VideoResponse {
+	videoResp := &VideoResponse{
+		URL:         ytdlpData.WebpageURL,
+		Source:      ytdlpData.Extractor,
+		ID:          ytdlpData.ID,
+		Author:      ytdlpData.Uploader,
+		Title:       ytdlpData.Title,
+		Thumbnail:   ytdlpData.Thumbnail,
+		Description: ytdlpData.Description,
+		Duration:    ytdlpData.Duration,
+		UploadDate:  formatUploadDate(ytdlpData.UploadDate),
+		ViewCount:   ytdlpData.ViewCount,
+		LikeCount:   ytdlpData.LikeCount,
+		LiveStatus:  ytdlpData.LiveStatus,
+		IsLive:      ytdlpData.IsLive || ytdlpData.LiveStatus == "is_live",
+		WasLive:     ytdlpData.WasLive || ytdlpData.LiveStatus == "was_live",
+		ReleaseAt:   ytdlpData.ReleaseTS,
+	}
+
+	for _, f := range ytdlpData.Formats {
+		if f.FormatID == "" {
+			continue
+		}
+		if f.Vcodec == "none" && f.Acodec == "none" {
+			continue
+		}
+		if f.Vcodec == "none" && !flagEnabled(flagAudio) {
+			continue
+		}
+		videoResp.Medias = append(videoResp.Medias, Media{
+			FormatID:       f.FormatID,
+			Quality:        f.Format,
+			Width:          f.Width,
+			Height:         f.Height,
+			Ext:            f.Ext,
+			Filesize:       f.Filesize,
+			FilesizeApprox: f.FilesizeApprox,
+			FPS:            f.FPS,
+			Vcodec:         f.Vcodec,
+			Acodec:         f.Acodec,
+			Bitrate:        f.TBR,
+		})
+	}
+	videoResp.Medias = dedupeMedias(videoResp.Medias)
+	return videoResp
+}
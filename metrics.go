@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type metricKind string
+
+const (
+	metricCounter metricKind = "counter"
+	metricGauge   metricKind = "gauge"
+)
+
+type Metric struct {
+	name   string
+	help   string
+	kind   metricKind
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+type metricsRegistry struct {
+	mu      sync.Mutex
+	metrics []*Metric
+}
+
+var metrics = &metricsRegistry{}
+
+func (m *metricsRegistry) register(kind metricKind, name, help string, labels []string) *Metric {
+	metric := &Metric{name: name, help: help, kind: kind, labels: labels, values: make(map[string]float64)}
+	m.mu.Lock()
+	m.metrics = append(m.metrics, metric)
+	m.mu.Unlock()
+	return metric
+}
+
+func (m *metricsRegistry) Counter(name, help string, labels ...string) *Metric {
+	return m.register(metricCounter, name, help, labels)
+}
+
+func (m *metricsRegistry) Gauge(name, help string, labels ...string) *Metric {
+	return m.register(metricGauge, name, help, labels)
+}
+
+func (m *Metric) key(labelValues []string) string {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s: expected %d label values, got %d", m.name, len(m.labels), len(labelValues)))
+	}
+	pairs := make([]string, len(m.labels))
+	for i, label := range m.labels {
+		pairs[i] = fmt.Sprintf("%s=%q", label, labelValues[i])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m *Metric) Add(v float64, labelValues ...string) {
+	key := m.key(labelValues)
+	m.mu.Lock()
+	m.values[key] += v
+	m.mu.Unlock()
+}
+
+func (m *Metric) Inc(labelValues ...string) {
+	m.Add(1, labelValues...)
+}
+
+func (m *Metric) Set(v float64, labelValues ...string) {
+	key := m.key(labelValues)
+	m.mu.Lock()
+	m.values[key] = v
+	m.mu.Unlock()
+}
+
+func (m *Metric) Value(labelValues ...string) float64 {
+	key := m.key(labelValues)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.values[key]
+}
+
+func (m *metricsRegistry) writeTo(b *strings.Builder) {
+	m.mu.Lock()
+	list := append([]*Metric(nil), m.metrics...)
+	m.mu.Unlock()
+
+	for _, metric := range list {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind)
+		metric.mu.Lock()
+		keys := make([]string, 0, len(metric.values))
+		for key := range metric.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if key == "" {
+				fmt.Fprintf(b, "%s %g\n", metric.name, metric.values[key])
+			} else {
+				fmt.Fprintf(b, "%s{%s} %g\n", metric.name, key, metric.values[key])
+			}
+		}
+		metric.mu.Unlock()
+	}
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	metrics.writeTo(&b)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
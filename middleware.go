@@ -0,0 +1,177 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func withAccessLog(next http.Handler) http.Handler {
+	if !cfg.AccessLog {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := newCountingResponseWriter(w)
+		rec := &statusRecorder{ResponseWriter: cw}
+		next.ServeHTTP(rec, r)
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		t := cw.transfer()
+		log.Printf("access: %s %s %d %dB %s %s %s", r.Method, r.URL.Path, status, t.Bytes, t.Duration.Round(time.Millisecond), clientIP(r), requestID(r))
+	})
+}
+
+func withAdminToken(next http.Handler) http.Handler {
+	return requireAdmin(next.ServeHTTP)
+}
+
+func withAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestUser(r) == nil {
+			writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "API key required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func rateLimitCaller(r *http.Request) string {
+	if user := requestUser(r); user != nil {
+		return "user:" + user.ID
+	}
+	if tenant := requestTenant(r); tenant != defaultTenant {
+		return "tenant:" + tenant.ID
+	}
+	return "ip:" + clientIP(r)
+}
+
+func withRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.APIRateLimit <= 0 || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		now := time.Now()
+		key := fmt.Sprintf("ratelimit:%s:%d", rateLimitCaller(r), now.Unix()/60)
+		var incr *redis.IntCmd
+		_, err := rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			incr = pipe.Incr(ctx, key)
+			pipe.Expire(ctx, key, 2*time.Minute)
+			return nil
+		})
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		n := incr.Val()
+		w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(cfg.APIRateLimit, 10))
+		w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(max(cfg.APIRateLimit-n, 0), 10))
+		if n > cfg.APIRateLimit {
+			w.Header().Set("Retry-After", strconv.FormatInt(60-now.Unix()%60, 10))
+			writeError(w, r, http.StatusTooManyRequests, codeQuotaExceeded, "Too many requests, slow down")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+var gzipWriters = sync.Pool{
+	New: func() any {
+		return gzip.NewWriter(io.Discard)
+	},
+}
+
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(strings.ToLower(mediaType))
+	if mediaType == "text/event-stream" {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || strings.Contains(mediaType, "json") ||
+		strings.Contains(mediaType, "javascript") || strings.HasSuffix(mediaType, "xml")
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz      *gzip.Writer
+	decided bool
+}
+
+func (w *gzipResponseWriter) decide(status int) {
+	w.decided = true
+	h := w.Header()
+	if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified ||
+		h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" || !compressible(h.Get("Content-Type")) {
+		return
+	}
+	h.Del("Content-Length")
+	h.Set("Content-Encoding", "gzip")
+	h.Add("Vary", "Accept-Encoding")
+	w.gz = gzipWriters.Get().(*gzip.Writer)
+	w.gz.Reset(w.ResponseWriter)
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if !w.decided {
+		w.decide(status)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		w.decide(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *gzipResponseWriter) Flush() {
+	if !w.decided {
+		w.decide(http.StatusOK)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *gzipResponseWriter) close() {
+	if w.gz != nil {
+		w.gz.Close()
+		gzipWriters.Put(w.gz)
+	}
+}
+
+func withCompression(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cfg.Compression || r.Method == http.MethodHead || !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
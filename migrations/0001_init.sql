@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS jobs (
+	id TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL,
+	created_at BIGINT NOT NULL,
+	data TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS jobs_user_created ON jobs (user_id, created_at);
+
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE,
+	tenant TEXT NOT NULL,
+	api_key_hash TEXT NOT NULL UNIQUE,
+	created_at BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS links (
+	token TEXT PRIMARY KEY,
+	artifact_id TEXT NOT NULL,
+	expires_at BIGINT NOT NULL
+);
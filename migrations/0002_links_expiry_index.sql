@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS links_expires_at ON links (expires_at);
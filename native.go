@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	innertubePlayerURL = "https://www.youtube.com/youtubei/v1/player?prettyPrint=false"
+	innertubeClient    = "ANDROID_VR"
+	innertubeVersion   = "1.60.19"
+	innertubeUserAgent = "com.google.android.apps.youtube.vr.oculus/1.60.19 (Linux; U; Android 12L; eureka-user Build/SQ3A.220605.009.A1) gzip"
+	nativeBrowserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36"
+	maxNativeBody      = 8 << 20
+)
+
+var nativeExtractions = metrics.Counter("odl_native_extractions_total", "Metadata lookups answered by a built-in extractor instead of yt-dlp.", "site", "result")
+
+type NativeExtractor interface {
+	Extract(client *http.Client, u *url.URL) (*YTDLPOutput, error)
+}
+
+var nativeExtractors = map[string]NativeExtractor{
+	"youtube": youtubeExtractor{},
+	"tiktok":  tiktokExtractor{},
+}
+
+func nativeMetadataKey(videoURL string) string {
+	return fmt.Sprintf("native_meta:%s", videoURL)
+}
+
+var nativeClients sync.Map
+
+func nativeClient(proxy *Proxy) *http.Client {
+	key := ""
+	if proxy != nil {
+		key = proxy.URL
+	}
+	if client, ok := nativeClients.Load(key); ok {
+		return client.(*http.Client)
+	}
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment, IdleConnTimeout: 90 * time.Second}
+	if proxy != nil {
+		if u, err := url.Parse(proxy.URL); err == nil {
+			transport.Proxy = http.ProxyURL(u)
+		}
+	}
+	client, _ := nativeClients.LoadOrStore(key, &http.Client{Transport: transport, Timeout: cfg.NativeExtractorTimeout})
+	return client.(*http.Client)
+}
+
+func nativeMetadata(videoURL string) (*YTDLPOutput, bool) {
+	site := urlSource(videoURL)
+	extractor, ok := nativeExtractors[site]
+	if !ok || !slices.Contains(cfg.NativeExtractors, site) {
+		return nil, false
+	}
+	if n, err := rdb.Exists(ctx, metadataCacheKey(videoURL)).Result(); err != nil || n > 0 {
+		return nil, false
+	}
+	if data, err := rdb.Get(ctx, nativeMetadataKey(videoURL)).Result(); err == nil {
+		var cached YTDLPOutput
+		if json.Unmarshal([]byte(data), &cached) == nil {
+			return &cached, true
+		}
+	}
+	if _, on := activeCooldown(site); on {
+		return nil, false
+	}
+
+	u, err := url.Parse(videoURL)
+	if err != nil {
+		return nil, false
+	}
+	output, err := extractor.Extract(nativeClient(pickProxy(site)), u)
+	if err == nil && len(output.Formats) == 0 {
+		err = errors.New("no formats")
+	}
+	if err != nil {
+		nativeExtractions.Inc(site, "fallback")
+		debugf("native: %s: %v, falling back to yt-dlp", videoURL, err)
+		return nil, false
+	}
+	nativeExtractions.Inc(site, "ok")
+	data, _ := json.Marshal(output)
+	rdb.Set(ctx, nativeMetadataKey(videoURL), data, metadataCacheTTL)
+	return output, true
+}
+
+func fetchPickerMetadata(videoURL string) (*VideoResponse, error) {
+	if output, ok := nativeMetadata(videoURL); ok {
+		return newVideoResponse(output), nil
+	}
+	return fetchVideoMetaData(videoURL)
+}
+
+func readNativeResponse(resp *http.Response, v any) error {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxNativeBody)).Decode(v)
+}
+
+var youtubeIDRegex = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+
+func youtubeVideoID(u *url.URL) string {
+	id := u.Query().Get("v")
+	if strings.HasSuffix(u.Hostname(), "youtu.be") {
+		id = strings.Trim(u.Path, "/")
+	}
+	for _, prefix := range []string{"/shorts/", "/live/", "/embed/"} {
+		if rest, ok := strings.CutPrefix(u.Path, prefix); ok {
+			id, _, _ = strings.Cut(rest, "/")
+		}
+	}
+	if !youtubeIDRegex.MatchString(id) {
+		return ""
+	}
+	return id
+}
+
+type innertubeFormat struct {
+	Itag          int    `json:"itag"`
+	MimeType      string `json:"mimeType"`
+	Bitrate       int64  `json:"bitrate"`
+	Width         int    `json:"width"`
+	Height        int    `json:"height"`
+	FPS           int    `json:"fps"`
+	QualityLabel  string `json:"qualityLabel"`
+	ContentLength string `json:"contentLength"`
+}
+
+type innertubePlayer struct {
+	PlayabilityStatus struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	} `json:"playabilityStatus"`
+	VideoDetails struct {
+		VideoID          string `json:"videoId"`
+		Title            string `json:"title"`
+		Author           string `json:"author"`
+		LengthSeconds    string `json:"lengthSeconds"`
+		ViewCount        string `json:"viewCount"`
+		ShortDescription string `json:"shortDescription"`
+		IsLive           bool   `json:"isLive"`
+		IsLiveContent    bool   `json:"isLiveContent"`
+		IsUpcoming       bool   `json:"isUpcoming"`
+		Thumbnail        struct {
+			Thumbnails []struct {
+				URL string `json:"url"`
+			} `json:"thumbnails"`
+		} `json:"thumbnail"`
+	} `json:"videoDetails"`
+	StreamingData struct {
+		Formats         []innertubeFormat `json:"formats"`
+		AdaptiveFormats []innertubeFormat `json:"adaptiveFormats"`
+	} `json:"streamingData"`
+}
+
+type youtubeExtractor struct{}
+
+func (youtubeExtractor) Extract(client *http.Client, u *url.URL) (*YTDLPOutput, error) {
+	id := youtubeVideoID(u)
+	if id == "" {
+		return nil, errors.New("no video ID in URL")
+	}
+	body, _ := json.Marshal(map[string]any{
+		"context": map[string]any{
+			"client": map[string]any{"clientName": innertubeClient, "clientVersion": innertubeVersion, "hl": "en"},
+		},
+		"videoId":        id,
+		"contentCheckOk": true,
+		"racyCheckOk":    true,
+	})
+	req, err := http.NewRequest(http.MethodPost, innertubePlayerURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", innertubeUserAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	var player innertubePlayer
+	if err := readNativeResponse(resp, &player); err != nil {
+		return nil, err
+	}
+	if status := player.PlayabilityStatus.Status; status != "OK" {
+		return nil, fmt.Errorf("playability %s: %s", status, player.PlayabilityStatus.Reason)
+	}
+
+	details := player.VideoDetails
+	output := &YTDLPOutput{
+		ID:          details.VideoID,
+		Title:       details.Title,
+		Uploader:    details.Author,
+		Description: details.ShortDescription,
+		WebpageURL:  "https://www.youtube.com/watch?v=" + details.VideoID,
+		Extractor:   "Youtube",
+		LiveStatus:  "not_live",
+	}
+	output.Duration, _ = strconv.ParseFloat(details.LengthSeconds, 64)
+	output.ViewCount, _ = strconv.ParseInt(details.ViewCount, 10, 64)
+	if thumbs := details.Thumbnail.Thumbnails; len(thumbs) > 0 {
+		output.Thumbnail = thumbs[len(thumbs)-1].URL
+	}
+	switch {
+	case details.IsUpcoming:
+		output.LiveStatus = "is_upcoming"
+	case details.IsLive:
+		output.LiveStatus, output.IsLive = "is_live", true
+	case details.IsLiveContent:
+		output.LiveStatus, output.WasLive = "was_live", true
+	}
+	for _, f := range slices.Concat(player.StreamingData.Formats, player.StreamingData.AdaptiveFormats) {
+		output.Formats = append(output.Formats, f.toYTDLP())
+	}
+	return output, nil
+}
+
+func (f innertubeFormat) toYTDLP() YTDLPFormat {
+	mediaType, params, _ := strings.Cut(f.MimeType, ";")
+	kind, subtype, _ := strings.Cut(mediaType, "/")
+	_, codecList, _ := strings.Cut(params, "codecs=")
+	codecs := strings.Split(strings.Trim(strings.TrimSpace(codecList), `"`), ",")
+	for i := range codecs {
+		codecs[i] = strings.TrimSpace(codecs[i])
+	}
+
+	out := YTDLPFormat{
+		FormatID: strconv.Itoa(f.Itag),
+		Ext:      subtype,
+		Width:    f.Width,
+		Height:   f.Height,
+		FPS:      float64(f.FPS),
+		TBR:      float64(f.Bitrate) / 1000,
+		Vcodec:   "none",
+		Acodec:   "none",
+	}
+	out.Filesize, _ = strconv.ParseInt(f.ContentLength, 10, 64)
+	switch {
+	case kind == "audio":
+		out.Acodec = codecs[0]
+		if subtype == "mp4" {
+			out.Ext = "m4a"
+		}
+		out.Format = fmt.Sprintf("%d - audio only", f.Itag)
+	case len(codecs) > 1:
+		out.Vcodec, out.Acodec = codecs[0], codecs[1]
+		out.Format = fmt.Sprintf("%d - %dx%d (%s)", f.Itag, f.Width, f.Height, f.QualityLabel)
+	default:
+		out.Vcodec = codecs[0]
+		out.Format = fmt.Sprintf("%d - %dx%d (%s)", f.Itag, f.Width, f.Height, f.QualityLabel)
+	}
+	return out
+}
+
+var (
+	tiktokRehydrationRegex = regexp.MustCompile(`(?s)<script[^>]+id="__UNIVERSAL_DATA_FOR_REHYDRATION__"[^>]*>(.*?)</script>`)
+	tiktokURLKeyRegex      = regexp.MustCompile(`v[^_]+_(([^_]+)_(\d+p)_(\d+))`)
+)
+
+type tiktokItem struct {
+	ID         string      `json:"id"`
+	Desc       string      `json:"desc"`
+	CreateTime json.Number `json:"createTime"`
+	Author     struct {
+		UniqueID string `json:"uniqueId"`
+		Nickname string `json:"nickname"`
+	} `json:"author"`
+	Video struct {
+		Duration    float64 `json:"duration"`
+		Cover       string  `json:"cover"`
+		BitrateInfo []struct {
+			Bitrate  int64 `json:"Bitrate"`
+			PlayAddr struct {
+				URLKey   string      `json:"UrlKey"`
+				Width    int         `json:"Width"`
+				Height   int         `json:"Height"`
+				DataSize json.Number `json:"DataSize"`
+			} `json:"PlayAddr"`
+		} `json:"bitrateInfo"`
+	} `json:"video"`
+	Stats struct {
+		PlayCount int64 `json:"playCount"`
+		DiggCount int64 `json:"diggCount"`
+	} `json:"stats"`
+}
+
+type tiktokExtractor struct{}
+
+func (tiktokExtractor) Extract(client *http.Client, u *url.URL) (*YTDLPOutput, error) {
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", nativeBrowserAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	page, err := io.ReadAll(io.LimitReader(resp.Body, maxNativeBody))
+	if err != nil {
+		return nil, err
+	}
+	m := tiktokRehydrationRegex.FindSubmatch(page)
+	if m == nil {
+		return nil, errors.New("no rehydration data in page")
+	}
+	var data struct {
+		Scope map[string]struct {
+			StatusCode int `json:"statusCode"`
+			ItemInfo   struct {
+				ItemStruct tiktokItem `json:"itemStruct"`
+			} `json:"itemInfo"`
+		} `json:"__DEFAULT_SCOPE__"`
+	}
+	if err := json.Unmarshal(m[1], &data); err != nil {
+		return nil, err
+	}
+	detail, ok := data.Scope["webapp.video-detail"]
+	if !ok || detail.StatusCode != 0 || detail.ItemInfo.ItemStruct.ID == "" {
+		return nil, errors.New("video details missing from page")
+	}
+
+	item := detail.ItemInfo.ItemStruct
+	output := &YTDLPOutput{
+		ID:          item.ID,
+		Title:       item.Desc,
+		Uploader:    item.Author.Nickname,
+		Thumbnail:   item.Video.Cover,
+		Description: item.Desc,
+		WebpageURL:  fmt.Sprintf("https://www.tiktok.com/@%s/video/%s", item.Author.UniqueID, item.ID),
+		Extractor:   "TikTok",
+		Duration:    item.Video.Duration,
+		ViewCount:   item.Stats.PlayCount,
+		LikeCount:   item.Stats.DiggCount,
+		LiveStatus:  "not_live",
+	}
+	if created, err := item.CreateTime.Int64(); err == nil {
+		output.UploadDate = time.Unix(created, 0).UTC().Format("20060102")
+	}
+	for _, b := range item.Video.BitrateInfo {
+		key := tiktokURLKeyRegex.FindStringSubmatch(b.PlayAddr.URLKey)
+		if key == nil {
+			continue
+		}
+		vcodec := key[2]
+		if vcodec == "bytevc1" {
+			vcodec = "h265"
+		}
+		f := YTDLPFormat{
+			FormatID: key[1],
+			Ext:      "mp4",
+			Format:   fmt.Sprintf("%s - %dx%d", key[1], b.PlayAddr.Width, b.PlayAddr.Height),
+			Width:    b.PlayAddr.Width,
+			Height:   b.PlayAddr.Height,
+			Vcodec:   vcodec,
+			Acodec:   "aac",
+			TBR:      float64(b.Bitrate) / 1000,
+		}
+		f.Filesize, _ = b.PlayAddr.DataSize.Int64()
+		output.Formats = append(output.Formats, f)
+	}
+	return output, nil
+}
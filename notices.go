@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+)
+
+const (
+	noticesKey       = "notices"
+	maxNoticeLength  = 500
+	maxActiveNotices = 20
+)
+
+var errNoticeNotFound = errors.New("notice not found")
+
+var noticeLevelClasses = map[string]string{
+	"info":     "bg-blue-900",
+	"warning":  "bg-yellow-700",
+	"critical": "bg-red-900",
+}
+
+type Notice struct {
+	ID        string     `json:"id"`
+	Message   string     `json:"message"`
+	Level     string     `json:"level"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func activeNotices() ([]Notice, error) {
+	fields, err := rdb.HGetAll(ctx, noticesKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	notices := make([]Notice, 0, len(fields))
+	for id, data := range fields {
+		var n Notice
+		if json.Unmarshal([]byte(data), &n) != nil {
+			continue
+		}
+		if n.ExpiresAt != nil && time.Now().After(*n.ExpiresAt) {
+			rdb.HDel(ctx, noticesKey, id)
+			continue
+		}
+		notices = append(notices, n)
+	}
+	sort.Slice(notices, func(i, j int) bool { return notices[i].CreatedAt.Before(notices[j].CreatedAt) })
+	return notices, nil
+}
+
+func noticeBanners() string {
+	notices, err := activeNotices()
+	if err != nil || len(notices) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	for _, n := range notices {
+		fmt.Fprintf(&b, `<div role="status" class="w-full p-3 text-center text-white %s">%s</div>`, noticeLevelClasses[n.Level], html.EscapeString(n.Message))
+	}
+	return b.String()
+}
+
+func injectNotices(page string) string {
+	i := strings.Index(page, "<body")
+	if i < 0 {
+		return page
+	}
+	j := strings.Index(page[i:], ">")
+	if j < 0 {
+		return page
+	}
+	at := i + j + 1
+	return page[:at] + noticeBanners() + page[at:]
+}
+
+func handleListNotices(w http.ResponseWriter, r *http.Request) {
+	notices, err := activeNotices()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading notices")
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	writeJSON(w, http.StatusOK, map[string]any{"notices": notices})
+}
+
+func handleCreateNotice(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Message string `json:"message"`
+		Level   string `json:"level"`
+		Minutes int    `json:"minutes"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	req.Message = strings.TrimSpace(req.Message)
+	if req.Level == "" {
+		req.Level = "info"
+	}
+	switch {
+	case req.Message == "" || len(req.Message) > maxNoticeLength:
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("message must be 1 to %d characters", maxNoticeLength))
+		return
+	case noticeLevelClasses[req.Level] == "":
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "level must be info, warning or critical")
+		return
+	case req.Minutes < 0:
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid minutes")
+		return
+	}
+	if active, err := activeNotices(); err == nil && len(active) >= maxActiveNotices {
+		writeError(w, r, http.StatusConflict, codeConflict, fmt.Sprintf("At most %d notices can be active", maxActiveNotices))
+		return
+	}
+
+	notice := Notice{
+		ID:        utils.RandomID(8),
+		Message:   req.Message,
+		Level:     req.Level,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	if req.Minutes > 0 {
+		expires := notice.CreatedAt.Add(time.Duration(req.Minutes) * time.Minute)
+		notice.ExpiresAt = &expires
+	}
+	data, _ := json.Marshal(notice)
+	if err := rdb.HSet(ctx, noticesKey, notice.ID, data).Err(); err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error saving notice")
+		return
+	}
+	writeJSON(w, http.StatusCreated, notice)
+}
+
+func deleteNotice(id string) error {
+	n, err := rdb.HDel(ctx, noticesKey, id).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errNoticeNotFound
+	}
+	return nil
+}
+
+func handleDeleteNotice(w http.ResponseWriter, r *http.Request) {
+	err := deleteNotice(r.PathValue("id"))
+	if errors.Is(err, errNoticeNotFound) {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Notice not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error deleting notice")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+var (
+	webhookClient      = newPublicClient(10 * time.Second)
+	eventWebhookClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+type jobNotification struct {
+	Event       string `json:"event"`
+	Job         *Job   `json:"job"`
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+func artifactDownloadURL(artifactID string) string {
+	return fmt.Sprintf("%s/api/v1/artifacts/%s/download", cfg.PublicURL, artifactID)
+}
+
+func notifyJobFinished(job *Job) {
+	if job.WebhookURL == "" && job.Email == "" {
+		return
+	}
+
+	n := jobNotification{Event: "job.completed", Job: job}
+	if job.Status == JobFailed {
+		n.Event = "job.failed"
+	}
+	if job.OneTimeURL != "" {
+		n.DownloadURL = job.OneTimeURL
+	} else if job.ArtifactID != "" {
+		n.DownloadURL = artifactDownloadURL(job.ArtifactID)
+	}
+
+	if job.WebhookURL != "" {
+		if err := sendWebhook(webhookClient, job.WebhookURL, n); err != nil {
+			log.Printf("job %s: webhook: %v", job.ID, err)
+		}
+	}
+	if job.Email != "" {
+		if err := sendJobEmail(job.Email, n); err != nil {
+			log.Printf("job %s: email: %v", job.ID, err)
+		}
+	}
+}
+
+func sendWebhook(client *http.Client, webhookURL string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func sendJobEmail(to string, n jobNotification) error {
+	if cfg.SMTPAddr == "" || cfg.SMTPFrom == "" {
+		return fmt.Errorf("SMTP is not configured")
+	}
+
+	subject := fmt.Sprintf("Your download %q is ready", n.Job.Title)
+	body := fmt.Sprintf("Your download is ready:\r\n\r\n%s\r\n", n.DownloadURL)
+	if n.Job.Status == JobFailed {
+		subject = fmt.Sprintf("Your download %q failed", n.Job.Title)
+		body = fmt.Sprintf("We couldn't download %s:\r\n\r\n%s\r\n", n.Job.URL, n.Job.Error)
+	}
+	msg := strings.Join([]string{
+		"From: " + cfg.SMTPFrom,
+		"To: " + to,
+		"Subject: " + subject,
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		body,
+	}, "\r\n")
+
+	var auth smtp.Auth
+	if cfg.SMTPUsername != "" {
+		host := strings.Split(cfg.SMTPAddr, ":")[0]
+		auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, host)
+	}
+	return smtp.SendMail(cfg.SMTPAddr, auth, cfg.SMTPFrom, []string{to}, []byte(msg))
+}
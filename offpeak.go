@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errOffPeakDisabled = errors.New("Off-peak scheduling is not configured on this server")
+
+var offPeakDeferrals = metrics.Counter("odl_offpeak_deferrals_total", "Jobs moved to the next off-peak window.")
+
+var cronBounds = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+type cronSchedule struct {
+	fields  [5]uint64
+	loc     *time.Location
+	anyDays bool
+}
+
+var offPeak *cronSchedule
+
+func parseCron(expr string, loc *time.Location) (*cronSchedule, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != len(cronBounds) {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+	c := &cronSchedule{loc: loc}
+	for i, part := range parts {
+		bits, err := parseCronField(part, cronBounds[i][0], cronBounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %w", expr, err)
+		}
+		c.fields[i] = bits
+	}
+	if c.fields[4]&(1<<7) != 0 {
+		c.fields[4] |= 1
+	}
+	c.anyDays = !strings.HasPrefix(parts[2], "*") && !strings.HasPrefix(parts[4], "*")
+	return c, nil
+}
+
+func parseCronField(field string, lowest, highest int) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		spec, stepText, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", item)
+			}
+			step = n
+		}
+		lo, hi := lowest, highest
+		if spec != "*" {
+			first, last, isRange := strings.Cut(spec, "-")
+			var err error
+			if lo, err = strconv.Atoi(first); err != nil {
+				return 0, fmt.Errorf("invalid value %q", item)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(last); err != nil {
+					return 0, fmt.Errorf("invalid value %q", item)
+				}
+			} else if hasStep {
+				hi = highest
+			}
+		}
+		if lo < lowest || hi > highest || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", item, lowest, highest)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom := c.fields[2]&(1<<t.Day()) != 0
+	dow := c.fields[4]&(1<<int(t.Weekday())) != 0
+	if c.anyDays {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+func (c *cronSchedule) Matches(t time.Time) bool {
+	t = t.In(c.loc)
+	return c.fields[0]&(1<<t.Minute()) != 0 && c.fields[1]&(1<<t.Hour()) != 0 &&
+		c.fields[3]&(1<<int(t.Month())) != 0 && c.dayMatches(t)
+}
+
+func (c *cronSchedule) Next(t time.Time) (time.Time, bool) {
+	t = t.In(c.loc).Truncate(time.Minute)
+	for limit := t.AddDate(1, 0, 1); t.Before(limit); {
+		switch {
+		case c.fields[3]&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, c.loc)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, c.loc)
+		case c.fields[1]&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, c.loc)
+		case c.fields[0]&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+func loadOffPeakWindow() (*cronSchedule, error) {
+	if cfg.OffPeakWindow == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(cfg.OffPeakTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("OFF_PEAK_TIMEZONE: %w", err)
+	}
+	c, err := parseCron(cfg.OffPeakWindow, loc)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := c.Next(time.Now()); !ok {
+		return nil, fmt.Errorf("OFF_PEAK_WINDOW %q never matches", cfg.OffPeakWindow)
+	}
+	return c, nil
+}
+
+func wantsOffPeak(job *Job) bool {
+	if offPeak == nil {
+		return false
+	}
+	return job.OffPeak || (cfg.OffPeakMinSize > 0 && job.EstimatedSize >= cfg.OffPeakMinSize)
+}
+
+func scheduleOffPeak(job *Job) {
+	if !wantsOffPeak(job) {
+		return
+	}
+	job.OffPeak = true
+	start := time.Now()
+	if job.RunAt != nil && job.RunAt.After(start) {
+		start = *job.RunAt
+	}
+	if offPeak.Matches(start) {
+		return
+	}
+	if next, ok := offPeak.Next(start); ok {
+		job.RunAt = &next
+	}
+}
+
+func deferToOffPeak(job *Job) bool {
+	if !wantsOffPeak(job) || offPeak.Matches(time.Now()) {
+		return false
+	}
+	job.RunAt = nil
+	scheduleOffPeak(job)
+	if job.RunAt == nil {
+		return false
+	}
+	offPeakDeferrals.Inc()
+	if err := enqueueJob(job); err != nil {
+		log.Printf("worker: deferring job %s to the off-peak window: %v", job.ID, err)
+	}
+	return true
+}
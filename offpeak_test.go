@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNextInOffsetZones(t *testing.T) {
+	tests := []struct {
+		expr string
+		zone string
+		from string
+		want string
+	}{
+		{"0 2 * * *", "Asia/Kolkata", "2026-10-14T12:00:00Z", "2026-10-15T02:00:00+05:30"},
+		{"30 * * * *", "Asia/Kolkata", "2026-10-14T12:05:00Z", "2026-10-14T18:30:00+05:30"},
+		{"0 2 * * *", "Asia/Kathmandu", "2026-10-14T12:00:00Z", "2026-10-15T02:00:00+05:45"},
+		{"* 1-6 * * *", "Europe/Berlin", "2026-10-14T12:00:00Z", "2026-10-15T01:00:00+02:00"},
+		{"0 3 * * 0", "UTC", "2026-10-14T12:00:00Z", "2026-10-18T03:00:00Z"},
+		{"0 2 1 * 1", "UTC", "2026-10-14T12:00:00Z", "2026-10-19T02:00:00Z"},
+		{"0 2 1 * 1", "UTC", "2026-10-27T12:00:00Z", "2026-11-01T02:00:00Z"},
+		{"0 2 1-7 * *", "UTC", "2026-10-14T12:00:00Z", "2026-11-01T02:00:00Z"},
+	}
+	for _, tt := range tests {
+		loc, err := time.LoadLocation(tt.zone)
+		if err != nil {
+			t.Skipf("time zone data unavailable: %v", err)
+		}
+		c, err := parseCron(tt.expr, loc)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.expr, err)
+		}
+		from, _ := time.Parse(time.RFC3339, tt.from)
+		want, _ := time.Parse(time.RFC3339, tt.want)
+		got, ok := c.Next(from)
+		if !ok {
+			t.Errorf("%q in %s from %s: never matches", tt.expr, tt.zone, tt.from)
+			continue
+		}
+		if !got.Equal(want) {
+			t.Errorf("%q in %s from %s = %s, want %s", tt.expr, tt.zone, tt.from, got.In(loc), want)
+		}
+		if !c.Matches(got) {
+			t.Errorf("%q in %s: Next returned %s, which does not match", tt.expr, tt.zone, got.In(loc))
+		}
+	}
+}
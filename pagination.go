@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+type pageCursor struct {
+	at int64
+	id string
+}
+
+type ListQuery struct {
+	Limit    int
+	Statuses []string
+	Since    time.Time
+	Until    time.Time
+	cursor   *pageCursor
+}
+
+func encodeCursor(at time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%s", at.Unix(), id)))
+}
+
+func decodeCursor(value string) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	at, id, ok := strings.Cut(string(data), ":")
+	if !ok {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	unix, err := strconv.ParseInt(at, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &pageCursor{at: unix, id: id}, nil
+}
+
+func parseListTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+func parseListQuery(w http.ResponseWriter, r *http.Request) (ListQuery, bool) {
+	values := r.URL.Query()
+	q := ListQuery{Limit: defaultPageSize}
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid limit")
+			return q, false
+		}
+		q.Limit = min(n, maxPageSize)
+	}
+	for _, v := range values["status"] {
+		for _, status := range strings.Split(v, ",") {
+			if status = strings.TrimSpace(status); status != "" {
+				q.Statuses = append(q.Statuses, status)
+			}
+		}
+	}
+
+	var err error
+	if q.Since, err = parseListTime(values.Get("since")); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid since date, use RFC 3339 or YYYY-MM-DD")
+		return q, false
+	}
+	if q.Until, err = parseListTime(values.Get("until")); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid until date, use RFC 3339 or YYYY-MM-DD")
+		return q, false
+	}
+	if v := values.Get("cursor"); v != "" {
+		if q.cursor, err = decodeCursor(v); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid cursor")
+			return q, false
+		}
+	}
+	return q, true
+}
+
+func (q ListQuery) matches(at time.Time, id, status string) bool {
+	if len(q.Statuses) > 0 && !slices.Contains(q.Statuses, status) {
+		return false
+	}
+	unix := at.Unix()
+	if !q.Since.IsZero() && unix < q.Since.Unix() {
+		return false
+	}
+	if !q.Until.IsZero() && unix >= q.Until.Unix() {
+		return false
+	}
+	if q.cursor != nil && (unix > q.cursor.at || (unix == q.cursor.at && id >= q.cursor.id)) {
+		return false
+	}
+	return true
+}
+
+func (q ListQuery) maxUnix() (int64, bool) {
+	max, bounded := int64(0), false
+	if q.cursor != nil {
+		max, bounded = q.cursor.at, true
+	}
+	if !q.Until.IsZero() {
+		if until := q.Until.Unix() - 1; !bounded || until < max {
+			max, bounded = until, true
+		}
+	}
+	return max, bounded
+}
+
+func paginate[T any](items []T, q ListQuery, key func(T) (time.Time, string, string)) ([]T, string) {
+	slices.SortStableFunc(items, func(a, b T) int {
+		atA, idA, _ := key(a)
+		atB, idB, _ := key(b)
+		if c := atB.Unix() - atA.Unix(); c != 0 {
+			return int(c)
+		}
+		return strings.Compare(idB, idA)
+	})
+
+	page := make([]T, 0, min(len(items), q.Limit))
+	for _, item := range items {
+		at, id, status := key(item)
+		if !q.matches(at, id, status) {
+			continue
+		}
+		if len(page) == q.Limit {
+			lastAt, lastID, _ := key(page[len(page)-1])
+			return page, encodeCursor(lastAt, lastID)
+		}
+		page = append(page, item)
+	}
+	return page, ""
+}
+
+func writePage[T any](w http.ResponseWriter, name string, items []T, next string) {
+	body := map[string]any{name: items}
+	if next != "" {
+		body["next_cursor"] = next
+	}
+	writeJSON(w, http.StatusOK, body)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	passphraseKeyPrefix   = "scrypt:"
+	minPassphraseLength   = 8
+	passphraseWindow      = 15 * time.Minute
+	maxPassphraseAttempts = 10
+	maxPassphraseDerives  = 2
+	passphraseBackoff     = time.Second
+	maxPassphraseBackoff  = time.Minute
+)
+
+var (
+	errPassphraseRequired = errors.New("passphrase required")
+	errWrongPassphrase    = errors.New("wrong passphrase")
+	errPassphraseGone     = errors.New("the passphrase for this job is no longer available, create the job again")
+)
+
+var passphraseSlots = make(chan struct{}, maxPassphraseDerives)
+
+var passphraseFailures = metrics.Counter("odl_passphrase_failures_total", "Downloads of passphrase protected files refused because the passphrase was missing or wrong.", "reason")
+
+func jobPassphraseKey(jobID string) string {
+	return fmt.Sprintf("job_passphrase:%s", jobID)
+}
+
+func isPassphraseKeyID(keyID string) bool {
+	return strings.HasPrefix(keyID, passphraseKeyPrefix)
+}
+
+func derivePassphraseKey(passphrase string, salt []byte) ([]byte, error) {
+	passphraseSlots <- struct{}{}
+	defer func() { <-passphraseSlots }()
+	return scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+}
+
+func passphraseAttemptsKey(r *http.Request, artifact *Artifact) string {
+	return fmt.Sprintf("passphrase_attempts:%s:%s", artifact.ID, clientIP(r))
+}
+
+func passphraseBackoffKey(r *http.Request, artifact *Artifact) string {
+	return fmt.Sprintf("passphrase_backoff:%s:%s", artifact.ID, clientIP(r))
+}
+
+func passphraseLocked(r *http.Request, artifact *Artifact) (time.Duration, bool) {
+	if wait, err := rdb.PTTL(ctx, passphraseBackoffKey(r, artifact)).Result(); err == nil && wait > 0 {
+		return wait, true
+	}
+	key := passphraseAttemptsKey(r, artifact)
+	if n, err := rdb.Get(ctx, key).Int64(); err == nil && n >= maxPassphraseAttempts {
+		wait, _ := rdb.PTTL(ctx, key).Result()
+		return max(wait, time.Second), true
+	}
+	return 0, false
+}
+
+func notePassphraseFailure(r *http.Request, artifact *Artifact) {
+	key := passphraseAttemptsKey(r, artifact)
+	var incr *redis.IntCmd
+	rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.Expire(ctx, key, passphraseWindow)
+		return nil
+	})
+	n, err := incr.Result()
+	if err != nil {
+		return
+	}
+	backoff := min(passphraseBackoff<<min(n-1, 6), maxPassphraseBackoff)
+	rdb.Set(ctx, passphraseBackoffKey(r, artifact), 1, backoff)
+}
+
+func passphraseAEAD(keyID, passphrase string) (cipher.AEAD, error) {
+	if passphrase == "" {
+		return nil, errPassphraseRequired
+	}
+	salt, err := hex.DecodeString(strings.TrimPrefix(keyID, passphraseKeyPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key id %q", keyID)
+	}
+	key, err := derivePassphraseKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	return newAESGCM(key)
+}
+
+func storeJobPassphrase(jobID, passphrase string) error {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	key, err := derivePassphraseKey(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	value := passphraseKeyPrefix + hex.EncodeToString(salt) + "=" + base64.StdEncoding.EncodeToString(key)
+	return rdb.Set(ctx, jobPassphraseKey(jobID), value, cfg.JobTTL).Err()
+}
+
+func dropJobPassphrase(jobID string) {
+	rdb.Del(ctx, jobPassphraseKey(jobID))
+}
+
+func hasJobPassphrase(jobID string) bool {
+	n, err := rdb.Exists(ctx, jobPassphraseKey(jobID)).Result()
+	return err == nil && n > 0
+}
+
+func protectJobFile(jobID, path string) (bool, error) {
+	value, err := rdb.GetDel(ctx, jobPassphraseKey(jobID)).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	keyID, encoded, _ := strings.Cut(value, "=")
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return false, err
+	}
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return false, err
+	}
+	return true, encryptFileWith(path, keyID, aead)
+}
+
+func requestPassphrase(w http.ResponseWriter, r *http.Request) string {
+	if p := r.Header.Get("X-Passphrase"); p != "" {
+		return p
+	}
+	if r.Method == http.MethodPost {
+		r.Body = http.MaxBytesReader(w, r.Body, 4<<10)
+		return r.PostFormValue("passphrase")
+	}
+	return ""
+}
+
+const passphrasePage = `<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>%s</title><script src="https://cdn.tailwindcss.com"></script></head>
+<body class="bg-gray-900 text-white flex items-center justify-center min-h-screen">
+<main class="w-full max-w-sm p-6">
+<h1 class="text-xl font-bold mb-4">%s</h1>
+<p class="mb-4 text-gray-300">%s</p>
+<form method="post" action="%s">
+<input type="password" name="passphrase" autofocus required class="w-full p-3 rounded-md text-black mb-4" placeholder="Passphrase">
+<button class="w-full bg-red-900 text-white p-3 rounded-md hover:bg-blue-600">Download</button>
+</form>
+</main>
+</body>
+</html>
+`
+
+func writePassphraseError(w http.ResponseWriter, r *http.Request, artifact *Artifact, err error) bool {
+	status, code, message := http.StatusUnauthorized, codeUnauthorized, "This file is protected with a passphrase"
+	switch {
+	case errors.Is(err, errPassphraseRequired):
+		passphraseFailures.Inc("missing")
+	case errors.Is(err, errWrongPassphrase):
+		status, code, message = http.StatusForbidden, codeForbidden, "Wrong passphrase"
+		passphraseFailures.Inc("wrong")
+		notePassphraseFailure(r, artifact)
+	default:
+		return false
+	}
+	w.Header().Set("X-Passphrase-Required", "true")
+	if r.Method == http.MethodHead || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		writeError(w, r, status, code, message)
+		return true
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, passphrasePage,
+		html.EscapeString(artifact.FileName),
+		html.EscapeString(artifact.FileName),
+		html.EscapeString(message),
+		html.EscapeString(r.URL.Path),
+	)
+	return true
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+)
+
+const resultContainerMarker = `id="result-container"`
+
+func handleSubmit(w http.ResponseWriter, r *http.Request) {
+	if !parseForm(w, r) {
+		return
+	}
+
+	videoData, ticket, status, err := loadVideoPicker(r, r.FormValue("videoURL"))
+	if err != nil {
+		writeError(w, r, status, pickerErrorCode(err, status), err.Error())
+		return
+	}
+	w.Header().Add("Vary", clientHintsHeader)
+	renderVideoPicker(w, videoData, ticket, requestClientProfile(r).maxHeight())
+}
+
+func handleFetch(w http.ResponseWriter, r *http.Request) {
+	encoded := strings.TrimRight(r.PathValue("encoded"), "=")
+	decoded, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeUnsupportedURL, "Invalid encoded video URL")
+		return
+	}
+
+	page, err := os.ReadFile(requestTenant(r).Template)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading page")
+		return
+	}
+	head, tail := injectNotices(string(page)), ""
+	if i := strings.Index(head, resultContainerMarker); i >= 0 {
+		if j := strings.Index(head[i:], ">"); j >= 0 {
+			head, tail = head[:i+j+1], head[i+j+1:]
+		}
+	}
+
+	videoData, ticket, status, err := loadVideoPicker(r, string(decoded))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Accept-CH", clientHintsHeader)
+	w.Header().Add("Vary", clientHintsHeader)
+	if err != nil {
+		w.WriteHeader(status)
+	}
+	io.WriteString(w, head)
+	if err != nil {
+		fmt.Fprintf(w, `<p class="mt-6 text-center text-white">%s</p>`, html.EscapeString(err.Error()))
+	} else {
+		renderVideoPicker(w, videoData, ticket, requestClientProfile(r).maxHeight())
+	}
+	io.WriteString(w, tail)
+}
+
+func loadVideoPicker(r *http.Request, videoURL string) (*VideoResponse, string, int, error) {
+	if !isAllowedVideoURL(r, videoURL) {
+		return nil, "", http.StatusBadRequest, errUnsupportedURL
+	}
+
+	videoData, err := fetchPickerMetadata(videoURL)
+	var cooldownErr *CooldownError
+	if errors.As(err, &cooldownErr) {
+		return nil, "", http.StatusServiceUnavailable, err
+	}
+	var ytdlpErr *YTDLPError
+	if errors.As(err, &ytdlpErr) {
+		switch ytdlpErr.Class {
+		case "unsupported_url":
+			return nil, "", http.StatusBadRequest, errUnsupportedURL
+		case "unavailable":
+			return nil, "", http.StatusUnprocessableEntity, errors.New(fetchErrorMessage(err))
+		}
+	}
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, fmt.Errorf("Error fetching video meta data: %v", err)
+	}
+
+	if videoData.IsUpcoming() && videoData.ReleaseAt > 0 {
+		return videoData, "", http.StatusOK, nil
+	}
+	if err := checkDownloadable(videoData); err != nil {
+		return nil, "", http.StatusUnprocessableEntity, err
+	}
+	if len(videoData.Medias) == 0 {
+		return nil, "", http.StatusUnprocessableEntity, errors.New("No downloadable formats found for this video")
+	}
+	ticket, err := issueDownloadTicket(requestTenant(r), videoData)
+	if err != nil {
+		return nil, "", http.StatusInternalServerError, errors.New("Error creating download link")
+	}
+	return videoData, ticket, http.StatusOK, nil
+}
+
+func pickerErrorCode(err error, status int) string {
+	switch {
+	case errors.Is(err, errUnsupportedURL):
+		return codeUnsupportedURL
+	case status == http.StatusServiceUnavailable:
+		return codeSourceCooldown
+	case status == http.StatusUnprocessableEntity:
+		return codeNotDownloadable
+	default:
+		return codeInternal
+	}
+}
+
+func renderVideoPicker(w io.Writer, videoData *VideoResponse, ticket string, maxHeight int) {
+	if videoData.IsUpcoming() && videoData.ReleaseAt > 0 {
+		renderPremiereSchedule(w, videoData)
+		return
+	}
+
+	sanitizedTitle := strings.ReplaceAll(videoData.Title, "/", "-")
+
+	selectedFormat := videoData.RecommendedMedia(maxHeight).FormatID
+
+	fmt.Fprintf(w, `
+		<div class="mt-6 mb-20 p-4 rounded-lg shadow-2xl" data-format="%s" data-page-url="%s" x-data="{ selectedFormat: $el.dataset.format, pageUrl: $el.dataset.pageUrl }">
+		<h3 class="text-lg font-bold mb-4">Video Details</h3>
+		<img src="%s" alt="Video Thumbnail" class="w-full rounded-md mb-4" />
+		<p class="text-white mb-2"><strong>Title:</strong> %s</p>
+		<p class="text-white mb-2"><strong>Author:</strong> %s</p>
+		<p class="text-white mb-2"><strong>Duration:</strong> %s</p>
+		<p class="text-white mb-2"><strong>Uploaded:</strong> %s</p>
+		<div class="mt-4">
+			<label for="qualitySelect" class="block mb-2">Select Quality</label>
+			<select id="qualitySelect" x-model="selectedFormat" class="w-full p-2 bg-neutral-800 text-white rounded-md border">`,
+		html.EscapeString(selectedFormat),
+		html.EscapeString(videoData.URL),
+		html.EscapeString(videoData.Thumbnail),
+		html.EscapeString(videoData.Title),
+		html.EscapeString(videoData.Author),
+		utils.FormatDuration(videoData.Duration),
+		html.EscapeString(videoData.UploadDate),
+	)
+
+	group := ""
+	for _, media := range videoData.Medias {
+		label := media.Quality
+		if strings.Contains(label, "video only") || strings.Contains(label, "audio only") {
+			parts := strings.Split(label, " ")
+			res := ""
+			for _, p := range parts {
+				if strings.HasSuffix(p, "p") || strings.Contains(p, "x") {
+					res = p
+					break
+				}
+			}
+			if res == "" {
+				res = fmt.Sprintf("%dp", media.Height)
+			}
+			if strings.Contains(media.Quality, "audio only") {
+				label = fmt.Sprintf("Audio only (%s)", media.Ext)
+			} else {
+				label = res
+			}
+		}
+		mediaGroup := "Video"
+		if media.AudioOnly() {
+			mediaGroup = "Audio"
+		}
+		if mediaGroup != group {
+			if group != "" {
+				fmt.Fprint(w, `</optgroup>`)
+			}
+			fmt.Fprintf(w, `<optgroup label="%s">`, mediaGroup)
+			group = mediaGroup
+		}
+
+		disabled := ""
+		if size := media.EstimatedSize(); size > 0 {
+			label = fmt.Sprintf("%s (~%s)", label, utils.FormatBytes(size))
+			if exceedsMaxFilesize(size) {
+				label += " - too large"
+				disabled = " disabled"
+			}
+		}
+		fmt.Fprintf(w, `<option value="%s"%s>%s</option>`, html.EscapeString(media.FormatID), disabled, html.EscapeString(label))
+	}
+	fmt.Fprint(w, `</optgroup>`)
+
+	fmt.Fprintf(w, `
+		</select>
+	</div>
+	<a
+		data-href="%s"
+		x-bind:href="$el.dataset.href + encodeURIComponent(selectedFormat)"
+		class="block w-full mt-4 bg-red-900 text-center text-white p-3 rounded-md hover:bg-blue-600"
+		download
+	>
+		Download Video
+	</a>`, html.EscapeString("/download/"+url.PathEscape(ticket)+"?filename="+url.QueryEscape(sanitizedTitle)+"&format="))
+
+	if flagEnabled(flagBundles) {
+		audioOutput := ""
+		if flagEnabled(flagAudio) {
+			audioOutput = `<label class="text-white"><input type="checkbox" name="outputs" value="audio" checked> Audio (mp3)</label>`
+		}
+		fmt.Fprintf(w, `
+	<form hx-post="/bundle" hx-target="this" hx-swap="outerHTML" class="flex flex-col gap-2 mt-4 mb-32 p-3 rounded-md border border-neutral-700">
+		<p class="text-white font-bold">Bundle as zip</p>
+		<input type="hidden" name="videoURL" x-bind:value="pageUrl">
+		<input type="hidden" name="format" x-bind:value="selectedFormat">
+		<label class="text-white"><input type="checkbox" name="outputs" value="video" checked> Selected quality</label>
+		%s
+		<label class="text-white"><input type="checkbox" name="outputs" value="subtitles"> Subtitles (srt)</label>
+		<input name="email" type="email" placeholder="Email the link to (optional)" class="w-full text-black rounded p-2">
+		<button type="submit" class="bg-neutral-700 text-white rounded p-2 hover:bg-blue-600">Create bundle</button>
+	</form>`, audioOutput)
+	}
+	fmt.Fprint(w, `
+	</div>`)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFetchEscapesVideoFields(t *testing.T) {
+	data, err := os.ReadFile("testdata/ytdlp/youtube_video.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var video map[string]any
+	if err := json.Unmarshal(data, &video); err != nil {
+		t.Fatal(err)
+	}
+	video["title"] = `<script>alert(1)</script>`
+	video["uploader"] = `" x-init="alert(1)`
+	video["thumbnail"] = `https://i.ytimg.com/x.jpg" hx-on:load="alert(1)`
+	line, _ := json.Marshal(video)
+	setupTestServer(t, func(name string, args []string) FakeResult {
+		return FakeResult{Stdout: append(line, '\n')}
+	})
+
+	encoded := base64.RawURLEncoding.EncodeToString([]byte("https://www.youtube.com/watch?v=dQw4w9WgXcQ"))
+	req := httptest.NewRequest(http.MethodGet, "/fetch/"+encoded, nil)
+	req.SetPathValue("encoded", encoded)
+	rec := httptest.NewRecorder()
+	handleFetch(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	body := rec.Body.String()
+	for _, raw := range []string{`<script>alert(1)`, `" x-init="`, `" hx-on:load="`} {
+		if strings.Contains(body, raw) {
+			t.Errorf("page contains unescaped %q", raw)
+		}
+	}
+	for _, escaped := range []string{`&lt;script&gt;alert(1)&lt;/script&gt;`, `&#34; x-init=&#34;alert(1)`, `&#34; hx-on:load=&#34;alert(1)`} {
+		if !strings.Contains(body, escaped) {
+			t.Errorf("page is missing escaped %q", escaped)
+		}
+	}
+	if strings.Contains(body, "selectedFormat: '") {
+		t.Error("picker state is still built as a JavaScript string literal")
+	}
+}
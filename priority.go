@@ -0,0 +1,76 @@
+package main
+
+import "strings"
+
+func estimateJobSize(job *Job, videoData *VideoResponse) int64 {
+	if len(videoData.Medias) == 0 {
+		return 0
+	}
+	var total int64
+	for _, id := range strings.Split(job.FormatID, "+") {
+		media, ok := videoData.FindMedia(id)
+		if !ok {
+			return videoData.DefaultMedia().EstimatedSize()
+		}
+		total += media.EstimatedSize()
+	}
+	return total
+}
+
+func isAudioOnlyJob(job *Job, videoData *VideoResponse) bool {
+	if len(job.Outputs) > 0 {
+		for _, output := range job.Outputs {
+			if output.Type == "video" {
+				return false
+			}
+		}
+		return true
+	}
+	for _, id := range strings.Split(job.FormatID, "+") {
+		media, ok := videoData.FindMedia(id)
+		if !ok || !media.AudioOnly() {
+			return false
+		}
+	}
+	return true
+}
+
+func classifyJob(job *Job, videoData *VideoResponse) {
+	job.EstimatedSize = estimateJobSize(job, videoData)
+	job.Lane = laneNormal
+	if !cfg.PrioritizeSmallJobs {
+		return
+	}
+	if isAudioOnlyJob(job, videoData) || (job.EstimatedSize > 0 && job.EstimatedSize <= cfg.SmallJobBytes) {
+		job.Lane = laneFast
+	}
+}
+
+func jobLane(job *Job) string {
+	if job.Lane == "" {
+		return laneNormal
+	}
+	return job.Lane
+}
+
+type laneScheduler struct {
+	streak int
+}
+
+func (s *laneScheduler) order() []string {
+	if !cfg.PrioritizeSmallJobs {
+		return []string{laneNormal, laneFast, laneLow}
+	}
+	if cfg.MaxFastStreak > 0 && s.streak >= cfg.MaxFastStreak {
+		return []string{laneNormal, laneFast, laneLow}
+	}
+	return []string{laneFast, laneNormal, laneLow}
+}
+
+func (s *laneScheduler) picked(job *Job) {
+	if jobLane(job) == laneFast {
+		s.streak++
+	} else {
+		s.streak = 0
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	progressPrefix   = "[progress]"
+	progressTemplate = "download:" + progressPrefix + " %(progress.downloaded_bytes)s %(progress.total_bytes)s %(progress.total_bytes_estimate)s %(progress.speed)s"
+	progressInterval = 2 * time.Second
+)
+
+type JobProgress struct {
+	DownloadedBytes int64     `json:"downloaded_bytes"`
+	TotalBytes      int64     `json:"total_bytes,omitempty"`
+	Percent         float64   `json:"percent"`
+	Speed           int64     `json:"speed,omitempty"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+type progressTracker struct {
+	mu    sync.Mutex
+	job   *Job
+	saved time.Time
+}
+
+func (t *progressTracker) update(downloaded, total, speed int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p := &JobProgress{DownloadedBytes: downloaded, TotalBytes: total, Speed: speed, UpdatedAt: time.Now().UTC()}
+	if total > 0 {
+		p.Percent = float64(int(float64(downloaded)/float64(total)*1000)) / 10
+	}
+	t.job.Progress = p
+	if time.Since(t.saved) < progressInterval {
+		return
+	}
+	t.saved = time.Now()
+	if err := saveJob(t.job); err != nil {
+		log.Printf("job %s: saving progress: %v", t.job.ID, err)
+	}
+}
+
+func (t *progressTracker) parseLine(line string) {
+	fields := strings.Fields(strings.TrimPrefix(line, progressPrefix))
+	if len(fields) != 4 {
+		return
+	}
+	downloaded := parseProgressValue(fields[0])
+	total := parseProgressValue(fields[1])
+	if total == 0 {
+		total = parseProgressValue(fields[2])
+	}
+	t.update(downloaded, total, parseProgressValue(fields[3]))
+}
+
+func parseProgressValue(v string) int64 {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0
+	}
+	return int64(f)
+}
+
+func runJobDownload(job *Job, args []string) error {
+	tracker := &progressTracker{job: job}
+
+	var aria2cArgs []string
+	if job.downloader() == downloaderAria2c {
+		rpc, err := newAria2RPC()
+		if err != nil {
+			log.Printf("job %s: aria2c rpc: %v", job.ID, err)
+		} else {
+			aria2cArgs = rpc.args()
+			stop := rpc.poll(tracker)
+			defer stop()
+		}
+	}
+	source := urlSource(job.URL)
+	proxy := pickProxy(source)
+	args = append(args, proxy.args()...)
+	args = append(args, job.DownloadOptions.args(false, aria2cArgs...)...)
+	args = append(args, "--newline", "--progress-template", progressTemplate, job.URL)
+
+	logs := openJobLog(job.ID)
+	defer logs.Flush()
+	logs.Printf("--- %s yt-dlp download", time.Now().UTC().Format(time.RFC3339))
+
+	debugf("job %s: yt-dlp %s", job.ID, redactSecrets(strings.Join(args, " ")))
+	cmd := command("yt-dlp", args...)
+	stderr := &tailBuffer{}
+	cmd.Stderr = io.MultiWriter(logs, stderr)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		logs.Printf("starting yt-dlp: %v", err)
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go watchJobCancel(job.ID, cmd, logs, done)
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, progressPrefix) {
+			tracker.parseLine(line)
+		} else {
+			logs.Printf("%s", line)
+		}
+	}
+	io.Copy(io.Discard, stdout)
+	err = cmd.Wait()
+	if err != nil {
+		logs.Printf("yt-dlp: %v", err)
+	}
+	if err != nil && jobCancelRequested(job.ID) {
+		return errJobCancelled
+	}
+	if err != nil {
+		reportYTDLPFailure("download", job.URL, jobSource(job), stderr.String(), err)
+		err = &YTDLPError{Class: classifyYTDLPFailure(stderr.String()), Err: err}
+		return checkRateLimitOutput(source, proxy, stderr.String(), err)
+	}
+	return nil
+}
+
+func watchJobCancel(id string, cmd *exec.Cmd, logs *jobLog, done <-chan struct{}) {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			logs.Flush()
+			if jobCancelRequested(id) {
+				cmd.Process.Kill()
+				return
+			}
+		}
+	}
+}
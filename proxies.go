@@ -0,0 +1,274 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	proxiesHealthKey    = "proxies:health"
+	proxyHealthLockName = "proxy_health"
+	proxyAssignmentTTL  = time.Hour
+	proxyHealthTimeout  = 10 * time.Second
+	maxProxyAttempts    = 3
+)
+
+var errProxyBlocked = errors.New("proxy blocked by the video site")
+
+var proxyBlockMarkers = []string{
+	"HTTP Error 403",
+	"HTTP Error 429",
+	"Too Many Requests",
+	"Sign in to confirm you're not a bot",
+	"Sign in to confirm you’re not a bot",
+}
+
+var proxyRotations = metrics.Counter("odl_proxy_rotations_total", "Proxies rotated away from a source after a 403 or 429.", "source")
+
+type Proxy struct {
+	ID      string   `json:"id"`
+	URL     string   `json:"url"`
+	Origins []string `json:"origins,omitempty"`
+}
+
+type ProxyHealth struct {
+	Healthy   bool      `json:"healthy"`
+	LatencyMS int64     `json:"latency_ms,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+var proxies []*Proxy
+
+func loadProxies(urls []string, path string) error {
+	var loaded []*Proxy
+	for _, u := range urls {
+		loaded = append(loaded, &Proxy{URL: u})
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var fromFile []*Proxy
+		if err := json.Unmarshal(data, &fromFile); err != nil {
+			return err
+		}
+		loaded = append(loaded, fromFile...)
+	}
+	for _, p := range loaded {
+		u, err := url.Parse(p.URL)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid proxy URL %q", p.redactedURL())
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+		}
+		for i, origin := range p.Origins {
+			p.Origins[i] = strings.ToLower(origin)
+		}
+		sum := sha256.Sum256([]byte(p.URL))
+		p.ID = hex.EncodeToString(sum[:6])
+	}
+	proxies = loaded
+	return nil
+}
+
+func (p *Proxy) redactedURL() string {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return "invalid"
+	}
+	return u.Redacted()
+}
+
+func (p *Proxy) args() []string {
+	if p == nil {
+		return nil
+	}
+	return []string{"--proxy", p.URL}
+}
+
+func (p *Proxy) serves(source string) bool {
+	return len(p.Origins) == 0 || slices.Contains(p.Origins, source)
+}
+
+func ytdlpArgs(proxy *Proxy, args ...string) []string {
+	return slices.Concat(proxy.args(), extractorArgs(nil), args)
+}
+
+func retryWithAnotherProxy(job *Job, err error) bool {
+	if !errors.Is(err, errProxyBlocked) {
+		return false
+	}
+	job.RunAt = nil
+	job.StartedAt = nil
+	job.Progress = nil
+	if err := enqueueJob(job); err != nil {
+		log.Printf("worker: requeueing job %s: %v", job.ID, err)
+	}
+	return true
+}
+
+func proxyAssignKey(source string) string {
+	return fmt.Sprintf("proxy_assign:%s", source)
+}
+
+func proxyBlockKey(id, source string) string {
+	return fmt.Sprintf("proxy_block:%s:%s", id, source)
+}
+
+func proxyHealth() map[string]ProxyHealth {
+	fields, err := rdb.HGetAll(ctx, proxiesHealthKey).Result()
+	if err != nil {
+		return nil
+	}
+	health := make(map[string]ProxyHealth, len(fields))
+	for id, data := range fields {
+		var h ProxyHealth
+		if json.Unmarshal([]byte(data), &h) == nil {
+			health[id] = h
+		}
+	}
+	return health
+}
+
+func proxyCandidates(source string) (usable, allowed []*Proxy) {
+	health := proxyHealth()
+	for _, p := range proxies {
+		if !p.serves(source) {
+			continue
+		}
+		allowed = append(allowed, p)
+		if h, ok := health[p.ID]; ok && !h.Healthy {
+			continue
+		}
+		if n, _ := rdb.Exists(ctx, proxyBlockKey(p.ID, source)).Result(); n > 0 {
+			continue
+		}
+		usable = append(usable, p)
+	}
+	return usable, allowed
+}
+
+func pickProxy(source string) *Proxy {
+	if len(proxies) == 0 {
+		return nil
+	}
+	usable, allowed := proxyCandidates(source)
+	if len(usable) == 0 {
+		usable = allowed
+	}
+	if len(usable) == 0 {
+		return nil
+	}
+	if id, err := rdb.Get(ctx, proxyAssignKey(source)).Result(); err == nil {
+		for _, p := range usable {
+			if p.ID == id {
+				return p
+			}
+		}
+	}
+	n, _ := rdb.Incr(ctx, fmt.Sprintf("proxy_rr:%s", source)).Result()
+	p := usable[int(n)%len(usable)]
+	rdb.Set(ctx, proxyAssignKey(source), p.ID, proxyAssignmentTTL)
+	return p
+}
+
+func rotateProxy(p *Proxy, source, output string) bool {
+	if p == nil {
+		return false
+	}
+	if _, blocked := detectMarker(output, proxyBlockMarkers); !blocked {
+		return false
+	}
+	rdb.Set(ctx, proxyBlockKey(p.ID, source), "1", cfg.ProxyBlockDuration)
+	if id, _ := rdb.Get(ctx, proxyAssignKey(source)).Result(); id == p.ID {
+		rdb.Del(ctx, proxyAssignKey(source))
+	}
+	proxyRotations.Inc(source)
+	log.Printf("proxy: %s blocked by %s, rotating", p.ID, source)
+	usable, _ := proxyCandidates(source)
+	return len(usable) > 0
+}
+
+func checkProxy(p *Proxy) ProxyHealth {
+	h := ProxyHealth{CheckedAt: time.Now().UTC().Truncate(time.Second)}
+	u, _ := url.Parse(p.URL)
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(u), DisableKeepAlives: true},
+		Timeout:   proxyHealthTimeout,
+	}
+	start := time.Now()
+	resp, err := client.Get(cfg.ProxyHealthURL)
+	if err != nil {
+		h.Error = err.Error()
+		return h
+	}
+	resp.Body.Close()
+	h.LatencyMS = time.Since(start).Milliseconds()
+	if resp.StatusCode >= 400 {
+		h.Error = resp.Status
+		return h
+	}
+	h.Healthy = true
+	return h
+}
+
+func checkProxies() {
+	lock, err := acquireLock(proxyHealthLockName, cfg.ProxyHealthInterval)
+	if errors.Is(err, errLockHeld) {
+		return
+	}
+	if err != nil {
+		log.Printf("proxy health: %v", err)
+		return
+	}
+	defer lock.Release()
+
+	for _, p := range proxies {
+		h := checkProxy(p)
+		if !h.Healthy {
+			log.Printf("proxy health: %s: %s", p.ID, h.Error)
+		}
+		data, _ := json.Marshal(h)
+		rdb.HSet(ctx, proxiesHealthKey, p.ID, data)
+	}
+}
+
+func runProxyHealthChecks() {
+	if len(proxies) == 0 || cfg.ProxyHealthInterval <= 0 {
+		return
+	}
+	checkProxies()
+	ticker := time.NewTicker(cfg.ProxyHealthInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		checkProxies()
+	}
+}
+
+func handleListProxies(w http.ResponseWriter, r *http.Request) {
+	health := proxyHealth()
+	list := make([]map[string]any, 0, len(proxies))
+	for _, p := range proxies {
+		entry := map[string]any{"id": p.ID, "url": p.redactedURL(), "origins": p.Origins}
+		if h, ok := health[p.ID]; ok {
+			entry["health"] = h
+		}
+		list = append(list, entry)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"proxies": list})
+}
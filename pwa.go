@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+)
+
+var sharedURLRegex = regexp.MustCompile(`https?://\S+`)
+
+func handleManifest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/manifest+json")
+	http.ServeFile(w, r, "static/manifest.webmanifest")
+}
+
+func handleServiceWorker(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/javascript")
+	w.Header().Set("Service-Worker-Allowed", "/")
+	http.ServeFile(w, r, "static/sw.js")
+}
+
+func handleShareTarget(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	sharedURL := q.Get("url")
+	if sharedURL == "" {
+		sharedURL = sharedURLRegex.FindString(q.Get("text"))
+	}
+	if sharedURL == "" {
+		sharedURL = sharedURLRegex.FindString(q.Get("title"))
+	}
+	if !isAllowedVideoURL(r, sharedURL) {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/?"+url.Values{"videoURL": {sharedURL}}.Encode(), http.StatusSeeOther)
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	queueRedis    = "redis"
+	queueNATS     = "nats"
+	queueRabbitMQ = "rabbitmq"
+
+	queuePopTimeout = 5 * time.Second
+
+	laneNormal = "normal"
+	laneFast   = "fast"
+	laneLow    = "low"
+)
+
+var queueLanes = []string{laneNormal, laneFast, laneLow}
+
+var (
+	errQueueEmpty      = errors.New("queue empty")
+	errPositionUnknown = errors.New("queue position unknown")
+)
+
+type JobQueue interface {
+	Push(jobID, lane string) error
+	// Pop returns the next job ID and an ack func the worker calls once it is
+	// done with the job. Backends that redeliver unacked jobs keep them until then.
+	Pop(timeout time.Duration, lanes ...string) (string, func() error, error)
+	// Ahead returns how many jobs will be popped before jobID, or
+	// errPositionUnknown when the backend cannot tell.
+	Ahead(jobID string) (int64, error)
+}
+
+var queue JobQueue = redisJobQueue{}
+
+func newJobQueue(kind string) (JobQueue, error) {
+	switch kind {
+	case "", queueRedis:
+		return redisJobQueue{}, nil
+	case queueNATS:
+		return openNATSJobQueue(cfg.NATSURL, cfg.QueueName)
+	case queueRabbitMQ:
+		return openAMQPJobQueue(cfg.AMQPURL, cfg.QueueName)
+	default:
+		return nil, fmt.Errorf("unknown queue backend %q", kind)
+	}
+}
+
+type redisJobQueue struct{}
+
+func laneQueueKey(lane string) string {
+	if lane == laneNormal {
+		return jobQueueKey
+	}
+	return jobQueueKey + ":" + lane
+}
+
+func (redisJobQueue) Push(jobID, lane string) error {
+	return rdb.RPush(ctx, laneQueueKey(lane), jobID).Err()
+}
+
+func noAck() error { return nil }
+
+func (redisJobQueue) Pop(timeout time.Duration, lanes ...string) (string, func() error, error) {
+	keys := make([]string, len(lanes))
+	for i, lane := range lanes {
+		keys[i] = laneQueueKey(lane)
+	}
+	res, err := rdb.BLPop(ctx, timeout, keys...).Result()
+	if err == redis.Nil {
+		return "", nil, errQueueEmpty
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return res[1], noAck, nil
+}
+
+func (redisJobQueue) Ahead(jobID string) (int64, error) {
+	var ahead int64
+	for _, lane := range []string{laneFast, laneNormal, laneLow} {
+		pos, err := rdb.LPos(ctx, laneQueueKey(lane), jobID, redis.LPosArgs{}).Result()
+		if err != redis.Nil {
+			return ahead + pos, err
+		}
+		n, err := rdb.LLen(ctx, laneQueueKey(lane)).Result()
+		if err != nil {
+			return 0, err
+		}
+		ahead += n
+	}
+	return 0, nil
+}
+
+type natsJobQueue struct {
+	js        jetstream.JetStream
+	consumers map[string]jetstream.Consumer
+	subjects  map[string]string
+	stream    string
+}
+
+func openNATSJobQueue(url, name string) (*natsJobQueue, error) {
+	conn, err := nats.Connect(url, nats.Name("onetimedownload"))
+	if err != nil {
+		return nil, err
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	q := &natsJobQueue{
+		js:        js,
+		consumers: make(map[string]jetstream.Consumer),
+		subjects:  map[string]string{laneNormal: "odl." + name, laneFast: "odl." + name + ".fast", laneLow: "odl." + name + ".low"},
+		stream:    "ODL_" + name,
+	}
+	if _, err := js.CreateOrUpdateStream(c, jetstream.StreamConfig{
+		Name:      q.stream,
+		Subjects:  []string{q.subjects[laneNormal], q.subjects[laneFast], q.subjects[laneLow]},
+		Retention: jetstream.WorkQueuePolicy,
+		Storage:   jetstream.FileStorage,
+	}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	for _, lane := range queueLanes {
+		durable := "workers"
+		if lane != laneNormal {
+			durable += "_" + lane
+		}
+		consumer, err := js.CreateOrUpdateConsumer(c, q.stream, jetstream.ConsumerConfig{
+			Durable:       durable,
+			AckPolicy:     jetstream.AckExplicitPolicy,
+			AckWait:       runningJobLease,
+			FilterSubject: q.subjects[lane],
+		})
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		q.consumers[lane] = consumer
+	}
+	return q, nil
+}
+
+func (q *natsJobQueue) Push(jobID, lane string) error {
+	_, err := q.js.Publish(ctx, q.subjects[lane], []byte(jobID))
+	return err
+}
+
+func (q *natsJobQueue) Pop(timeout time.Duration, lanes ...string) (string, func() error, error) {
+	for i, lane := range lanes {
+		var batch jetstream.MessageBatch
+		var err error
+		if i == len(lanes)-1 {
+			batch, err = q.consumers[lane].Fetch(1, jetstream.FetchMaxWait(timeout))
+		} else {
+			batch, err = q.consumers[lane].FetchNoWait(1)
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		for msg := range batch.Messages() {
+			return string(msg.Data()), msg.Ack, nil
+		}
+		if err := batch.Error(); err != nil && !errors.Is(err, nats.ErrTimeout) {
+			return "", nil, err
+		}
+	}
+	return "", nil, errQueueEmpty
+}
+
+func (q *natsJobQueue) Ahead(jobID string) (int64, error) {
+	return 0, errPositionUnknown
+}
+
+type amqpJobQueue struct {
+	mu   sync.Mutex
+	url  string
+	name string
+	conn *amqp.Connection
+	ch   *amqp.Channel
+}
+
+func openAMQPJobQueue(url, name string) (*amqpJobQueue, error) {
+	q := &amqpJobQueue{url: url, name: name}
+	if err := q.connect(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *amqpJobQueue) connect() error {
+	conn, err := amqp.Dial(q.url)
+	if err != nil {
+		return err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	for _, lane := range queueLanes {
+		if _, err := ch.QueueDeclare(q.laneName(lane), true, false, false, false, nil); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	q.conn, q.ch = conn, ch
+	return nil
+}
+
+func (q *amqpJobQueue) laneName(lane string) string {
+	if lane == laneNormal {
+		return q.name
+	}
+	return q.name + "_" + lane
+}
+
+func (q *amqpJobQueue) channel() (*amqp.Channel, error) {
+	if q.ch == nil || q.ch.IsClosed() {
+		if q.conn != nil {
+			q.conn.Close()
+		}
+		q.ch = nil
+		if err := q.connect(); err != nil {
+			return nil, err
+		}
+	}
+	return q.ch, nil
+}
+
+func (q *amqpJobQueue) Push(jobID, lane string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ch, err := q.channel()
+	if err != nil {
+		return err
+	}
+	return ch.PublishWithContext(ctx, "", q.laneName(lane), false, false, amqp.Publishing{
+		ContentType:  "text/plain",
+		DeliveryMode: amqp.Persistent,
+		Body:         []byte(jobID),
+	})
+}
+
+func (q *amqpJobQueue) Pop(timeout time.Duration, lanes ...string) (string, func() error, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		q.mu.Lock()
+		ch, err := q.channel()
+		var msg amqp.Delivery
+		var ok bool
+		for _, lane := range lanes {
+			if err != nil || ok {
+				break
+			}
+			msg, ok, err = ch.Get(q.laneName(lane), true)
+		}
+		q.mu.Unlock()
+		if err != nil {
+			return "", nil, err
+		}
+		if ok {
+			return string(msg.Body), noAck, nil
+		}
+		if time.Now().After(deadline) {
+			return "", nil, errQueueEmpty
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func (q *amqpJobQueue) Ahead(jobID string) (int64, error) {
+	return 0, errPositionUnknown
+}
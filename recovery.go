@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+const panicPage = `<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Something went wrong</title><script src="https://cdn.tailwindcss.com"></script></head>
+<body class="bg-gray-900 text-white flex items-center justify-center min-h-screen">
+<main class="text-center p-6">
+<h1 class="text-2xl font-bold mb-4">Something went wrong</h1>
+<p class="mb-2">The server ran into a problem with this request. Please try again in a moment.</p>
+<p class="text-sm text-gray-400">If it keeps happening, mention request ID <code>%s</code>.</p>
+<p class="mt-6"><a class="underline" href="/">Back to the start page</a></p>
+</main>
+</body>
+</html>
+`
+
+var errJobPanicked = errors.New("internal error while processing the job")
+
+var panicsRecovered = metrics.Counter("odl_panics_total", "Panics recovered instead of crashing the server.", "source")
+
+func withRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			log.Printf("panic: %s %s (request %s): %v\n%s", r.Method, r.URL.Path, requestID(r), v, debug.Stack())
+			panicsRecovered.Inc("http")
+			if rec.status != 0 {
+				return
+			}
+			writePanicResponse(w, r)
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+func writePanicResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Del("Content-Disposition")
+	w.Header().Del("Content-Length")
+	if wantsJSON(r) || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Something went wrong, please try again")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, panicPage, html.EscapeString(requestID(r)))
+}
+
+func processJobSafely(job *Job) {
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("panic: job %s: %v\n%s", job.ID, v, debug.Stack())
+			panicsRecovered.Inc("worker")
+			reportPanic(v, nil)
+			finishJob(job, errJobPanicked)
+		}
+	}()
+	processJob(job)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	headerRetryAllowed = "X-Retry-Allowed"
+	headerFailureClass = "X-Failure-Class"
+)
+
+var permanentFailureClasses = map[string]bool{
+	"unsupported_url":    true,
+	"unavailable":        true,
+	"login_required":     true,
+	"geo_blocked":        true,
+	"format_unavailable": true,
+}
+
+var streamRetryHints = metrics.Counter("odl_stream_retry_hints_total", "Failed streamed downloads that told the client whether to retry.", "allowed")
+
+func retryAllowed(class string) bool {
+	return !permanentFailureClasses[class]
+}
+
+func retryDelay(source string) time.Duration {
+	if c, ok := activeCooldown(source); ok {
+		return max(time.Until(c.Until).Round(time.Second), time.Second)
+	}
+	return 0
+}
+
+func setRetryHint(h http.Header, class string, delay time.Duration) {
+	allowed := retryAllowed(class)
+	h.Set(headerRetryAllowed, strconv.FormatBool(allowed))
+	h.Set(headerFailureClass, class)
+	if allowed && delay > 0 {
+		h.Set("Retry-After", strconv.Itoa(int(delay.Seconds())))
+	}
+	streamRetryHints.Inc(strconv.FormatBool(allowed))
+}
+
+func acceptsTrailers(r *http.Request) bool {
+	for _, te := range strings.Split(r.Header.Get("TE"), ",") {
+		name, _, _ := strings.Cut(te, ";")
+		if strings.EqualFold(strings.TrimSpace(name), "trailers") {
+			return true
+		}
+	}
+	return false
+}
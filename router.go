@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+type middleware func(http.Handler) http.Handler
+
+type router struct {
+	mux    *http.ServeMux
+	prefix string
+	chain  []middleware
+}
+
+func newRouter() *router {
+	return &router{mux: http.NewServeMux()}
+}
+
+func (rt *router) Group(prefix string, mw ...middleware) *router {
+	return &router{mux: rt.mux, prefix: rt.prefix + prefix, chain: slices.Concat(rt.chain, mw)}
+}
+
+func (rt *router) Handle(pattern string, h http.Handler) {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		method, path = "", pattern
+	}
+	if rt.prefix != "" && path == "/" {
+		path = ""
+	}
+	pattern = strings.TrimSpace(method + " " + rt.prefix + path)
+	rt.mux.Handle(pattern, chain(h, rt.chain...))
+}
+
+func (rt *router) HandleFunc(pattern string, h http.HandlerFunc) {
+	rt.Handle(pattern, h)
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, pattern := rt.mux.Handler(r)
+	if pattern != "" {
+		rt.mux.ServeHTTP(w, r)
+		return
+	}
+	probe := &routeProbe{header: http.Header{}}
+	h.ServeHTTP(probe, r)
+	switch probe.status {
+	case http.StatusNotFound:
+		handleNotFound(w, r)
+	case http.StatusMethodNotAllowed:
+		w.Header().Set("Allow", probe.header.Get("Allow"))
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+	default:
+		h.ServeHTTP(w, r)
+	}
+}
+
+type routeProbe struct {
+	header http.Header
+	status int
+}
+
+func (p *routeProbe) Header() http.Header { return p.header }
+
+func (p *routeProbe) WriteHeader(status int) {
+	if p.status == 0 {
+		p.status = status
+	}
+}
+
+func (p *routeProbe) Write(b []byte) (int, error) {
+	p.WriteHeader(http.StatusOK)
+	return len(b), nil
+}
+
+func chain(h http.Handler, mw ...middleware) http.Handler {
+	for i := len(mw) - 1; i >= 0; i-- {
+		h = mw[i](h)
+	}
+	return h
+}
+
+func handleNotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, codeNotFound, "Not found")
+}
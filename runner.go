@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Runner interface {
+	CommandContext(ctx context.Context, name string, args ...string) *exec.Cmd
+}
+
+type execRunner struct{}
+
+func (execRunner) CommandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, name, args...)
+}
+
+var runner Runner = execRunner{}
+
+func command(name string, args ...string) *exec.Cmd {
+	return runner.CommandContext(context.Background(), name, args...)
+}
+
+func setupRunner() error {
+	switch cfg.ExecRunner {
+	case "", "exec":
+		return nil
+	case "fixtures":
+		r, err := newFixtureRunner(cfg.ExecFixturesDir)
+		if err != nil {
+			return err
+		}
+		runner = r
+		return nil
+	default:
+		return fmt.Errorf("unknown EXEC_RUNNER %q", cfg.ExecRunner)
+	}
+}
+
+type FakeResult struct {
+	Stdout   []byte
+	Stderr   string
+	ExitCode int
+}
+
+type FakeCall struct {
+	Name string
+	Args []string
+}
+
+type fakeRunner struct {
+	mu      sync.Mutex
+	calls   []FakeCall
+	respond func(name string, args []string) FakeResult
+}
+
+func newFakeRunner(respond func(name string, args []string) FakeResult) *fakeRunner {
+	return &fakeRunner{respond: respond}
+}
+
+func (f *fakeRunner) CommandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	f.mu.Lock()
+	f.calls = append(f.calls, FakeCall{Name: name, Args: slices.Clone(args)})
+	f.mu.Unlock()
+	return replayCommand(ctx, name, f.respond(name, args))
+}
+
+func (f *fakeRunner) Calls() []FakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.calls)
+}
+
+const replayScript = `cat "$1"; rm -f "$1"; printf '%s' "$2" >&2; exit "$3"`
+
+func replayCommand(ctx context.Context, name string, res FakeResult) *exec.Cmd {
+	f, err := os.CreateTemp("", "odl-replay-*")
+	if err != nil {
+		return exec.CommandContext(ctx, "/bin/sh", "-c", `printf '%s\n' "$1" >&2; exit 1`, name, err.Error())
+	}
+	f.Write(res.Stdout)
+	f.Close()
+	return exec.CommandContext(ctx, "/bin/sh", "-c", replayScript, name, f.Name(), res.Stderr, strconv.Itoa(res.ExitCode))
+}
+
+type fixtureRunner struct {
+	dir   string
+	index map[string]string
+}
+
+func newFixtureRunner(dir string) (*fakeRunner, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return nil, err
+	}
+	fr := &fixtureRunner{dir: dir}
+	if err := json.Unmarshal(data, &fr.index); err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Join(dir, "index.json"), err)
+	}
+	for u, file := range fr.index {
+		fr.index[canonicalVideoURL(u)] = file
+	}
+	return newFakeRunner(fr.respond), nil
+}
+
+func (fr *fixtureRunner) fixture(args []string) (string, []byte, bool) {
+	for i := len(args) - 1; i >= 0; i-- {
+		if !strings.HasPrefix(args[i], "http://") && !strings.HasPrefix(args[i], "https://") {
+			continue
+		}
+		file, ok := fr.index[canonicalVideoURL(args[i])]
+		if !ok {
+			return args[i], nil, false
+		}
+		data, err := os.ReadFile(filepath.Join(fr.dir, file))
+		return args[i], data, err == nil
+	}
+	return "", nil, false
+}
+
+func (fr *fixtureRunner) respond(name string, args []string) FakeResult {
+	switch name {
+	case "yt-dlp":
+	case "ffmpeg":
+		return FakeResult{Stdout: []byte("ffmpeg version fixtures\n")}
+	default:
+		return FakeResult{Stderr: name + ": not available with fixtures\n", ExitCode: 127}
+	}
+
+	if slices.Contains(args, "--version") {
+		return FakeResult{Stdout: []byte("fixtures\n")}
+	}
+	if slices.Contains(args, "--list-extractors") {
+		return FakeResult{Stdout: []byte("generic\n")}
+	}
+	videoURL, data, ok := fr.fixture(args)
+	if !ok {
+		return FakeResult{Stderr: "ERROR: Unsupported URL: " + videoURL + "\n", ExitCode: 1}
+	}
+	var info struct {
+		ID      string `json:"id"`
+		Title   string `json:"title"`
+		Formats []struct {
+			FormatID string `json:"format_id"`
+			Ext      string `json:"ext"`
+			URL      string `json:"url"`
+		} `json:"formats"`
+	}
+	json.Unmarshal(data, &info)
+
+	switch {
+	case slices.ContainsFunc(args, func(a string) bool {
+		return a == "-j" || a == "-J" || a == "--dump-json" || a == "--dump-single-json"
+	}):
+		var line bytes.Buffer
+		if err := json.Compact(&line, data); err != nil {
+			return FakeResult{Stderr: "ERROR: [fixtures] invalid fixture: " + err.Error() + "\n", ExitCode: 1}
+		}
+		return FakeResult{Stdout: append(line.Bytes(), '\n')}
+	case slices.Contains(args, "--simulate"):
+		return FakeResult{}
+	case slices.Contains(args, "-g"):
+		format := flagValue(args, "-f")
+		for _, f := range info.Formats {
+			if f.FormatID == format || format == "" || format == "b" {
+				return FakeResult{Stdout: []byte(f.URL + "\n")}
+			}
+		}
+		return FakeResult{Stderr: "ERROR: [fixtures] " + info.ID + ": Requested format is not available\n", ExitCode: 1}
+	}
+
+	ext := "mp4"
+	for _, name := range []string{"--audio-format", "--merge-output-format", "--remux-video"} {
+		if v := flagValue(args, name); v != "" {
+			ext = v
+		}
+	}
+	media := append([]byte("\x00\x00\x00\x18ftypmp42"), bytes.Repeat([]byte{0}, 64<<10)...)
+	var stdout bytes.Buffer
+	for i, a := range args {
+		if a != "-o" || i+1 >= len(args) {
+			continue
+		}
+		out := args[i+1]
+		if out == "-" {
+			stdout.Write(media)
+			continue
+		}
+		content, fileExt := media, ext
+		if rest, ok := strings.CutPrefix(out, "thumbnail:"); ok {
+			out, content, fileExt = rest, []byte("\xff\xd8\xff"), "jpg"
+		} else if strings.Contains(out, ":") && !filepath.IsAbs(out) {
+			continue
+		}
+		path := strings.NewReplacer("%(title).100B", info.Title, "%(title)s", info.Title, "%(id)s", info.ID, "%(ext)s", fileExt).Replace(out)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return FakeResult{Stderr: "ERROR: " + err.Error() + "\n", ExitCode: 1}
+		}
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			return FakeResult{Stderr: "ERROR: " + err.Error() + "\n", ExitCode: 1}
+		}
+	}
+	if slices.Contains(args, "--progress-template") {
+		fmt.Fprintf(&stdout, "%s %d %d NA 1048576\n", progressPrefix, len(media), len(media))
+	}
+	return FakeResult{Stdout: stdout.Bytes()}
+}
+
+func flagValue(args []string, name string) string {
+	if i := slices.Index(args, name); i >= 0 && i+1 < len(args) {
+		return args[i+1]
+	}
+	return ""
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	scannerClamAV = "clamav"
+	scannerHTTP   = "http"
+
+	ScanClean       = "clean"
+	ScanQuarantined = "quarantined"
+	ScanError       = "error"
+
+	clamavChunkSize = 64 << 10
+)
+
+var errFileQuarantined = errors.New("the downloaded file was flagged by the malware scanner and quarantined")
+
+var fileScans = metrics.Counter("odl_file_scans_total", "Downloaded files checked by the malware scanner before release.", "scanner", "result")
+
+type ScanResult struct {
+	Infected  bool
+	Signature string
+}
+
+type FileScanner interface {
+	Scan(c context.Context, path string) (ScanResult, error)
+}
+
+var scanner FileScanner
+
+type JobScan struct {
+	Status    string    `json:"status"`
+	Scanner   string    `json:"scanner"`
+	Signature string    `json:"signature,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	ScannedAt time.Time `json:"scanned_at"`
+}
+
+func newFileScanner(kind string) (FileScanner, error) {
+	switch kind {
+	case "":
+		return nil, nil
+	case scannerClamAV:
+		if cfg.ScannerAddress == "" {
+			return nil, errors.New("SCANNER=clamav needs SCANNER_ADDRESS")
+		}
+		return clamavScanner{address: cfg.ScannerAddress}, nil
+	case scannerHTTP:
+		if cfg.ScannerURL == "" {
+			return nil, errors.New("SCANNER=http needs SCANNER_URL")
+		}
+		return httpScanner{url: cfg.ScannerURL, token: cfg.ScannerToken}, nil
+	default:
+		return nil, fmt.Errorf("unknown scanner %q", kind)
+	}
+}
+
+func scanRequired(videoData *VideoResponse) bool {
+	return scanner != nil && slices.Contains(cfg.ScanSources, strings.ToLower(videoData.Source))
+}
+
+func scanJobFile(job *Job, videoData *VideoResponse, path string) error {
+	if !scanRequired(videoData) {
+		return nil
+	}
+	c, cancel := context.WithTimeout(ctx, cfg.ScanTimeout)
+	defer cancel()
+	result, err := scanner.Scan(c, path)
+
+	job.Scan = &JobScan{Scanner: cfg.Scanner, ScannedAt: time.Now().UTC()}
+	switch {
+	case err != nil:
+		job.Scan.Status, job.Scan.Error = ScanError, err.Error()
+		fileScans.Inc(cfg.Scanner, ScanError)
+		if cfg.ScanFailOpen {
+			log.Printf("job %s: scanning %s failed, releasing it anyway: %v", job.ID, filepath.Base(path), err)
+			return nil
+		}
+		return fmt.Errorf("scanning the downloaded file: %w", err)
+	case result.Infected:
+		job.Scan.Status, job.Scan.Signature = ScanQuarantined, result.Signature
+		fileScans.Inc(cfg.Scanner, ScanQuarantined)
+		if err := store.Quarantine(job.ID, path); err != nil {
+			log.Printf("job %s: quarantining %s: %v", job.ID, filepath.Base(path), err)
+			os.Remove(path)
+		}
+		log.Printf("job %s: %s flagged as %q", job.ID, filepath.Base(path), result.Signature)
+		return errFileQuarantined
+	default:
+		job.Scan.Status = ScanClean
+		fileScans.Inc(cfg.Scanner, ScanClean)
+		return nil
+	}
+}
+
+type clamavScanner struct {
+	address string
+}
+
+func (s clamavScanner) Scan(c context.Context, path string) (ScanResult, error) {
+	network := "tcp"
+	if strings.HasPrefix(s.address, "/") {
+		network = "unix"
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(c, network, s.address)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	defer conn.Close()
+	if deadline, ok := c.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	defer f.Close()
+
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return ScanResult{}, err
+	}
+	buf := make([]byte, clamavChunkSize)
+	for {
+		n, readErr := f.Read(buf)
+		if n > 0 {
+			if err := binary.Write(conn, binary.BigEndian, uint32(n)); err != nil {
+				return ScanResult{}, err
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return ScanResult{}, err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return ScanResult{}, readErr
+		}
+	}
+	if err := binary.Write(conn, binary.BigEndian, uint32(0)); err != nil {
+		return ScanResult{}, err
+	}
+
+	reply, err := bufio.NewReader(io.LimitReader(conn, 4<<10)).ReadString(0)
+	if err != nil && err != io.EOF {
+		return ScanResult{}, err
+	}
+	answer := strings.TrimSpace(strings.TrimSuffix(reply, "\x00"))
+	_, answer, _ = strings.Cut(answer, ": ")
+	switch {
+	case answer == "OK":
+		return ScanResult{}, nil
+	case strings.HasSuffix(answer, " FOUND"):
+		return ScanResult{Infected: true, Signature: strings.TrimSuffix(answer, " FOUND")}, nil
+	default:
+		return ScanResult{}, fmt.Errorf("clamd: %s", answer)
+	}
+}
+
+type httpScanner struct {
+	url   string
+	token string
+}
+
+func (s httpScanner) Scan(c context.Context, path string) (ScanResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	defer f.Close()
+
+	req, err := http.NewRequestWithContext(c, http.MethodPost, s.url, f)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	if info, err := f.Stat(); err == nil {
+		req.ContentLength = info.Size()
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-File-Name", filepath.Base(path))
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return ScanResult{}, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode != http.StatusOK {
+		return ScanResult{}, fmt.Errorf("scanner answered %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	var verdict struct {
+		Clean     *bool  `json:"clean"`
+		Signature string `json:"signature"`
+	}
+	if err := json.Unmarshal(body, &verdict); err != nil || verdict.Clean == nil {
+		return ScanResult{}, errors.New("scanner answer has no \"clean\" field")
+	}
+	return ScanResult{Infected: !*verdict.Clean, Signature: verdict.Signature}, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"time"
+)
+
+func renderPremiereSchedule(w io.Writer, videoData *VideoResponse) {
+	releaseAt := time.Unix(videoData.ReleaseAt, 0).UTC()
+	fmt.Fprintf(w, `
+		<div class="mt-6 mb-20 p-4 rounded-lg shadow-2xl">
+		<h3 class="text-lg font-bold mb-4">Upcoming Premiere</h3>
+		<img src="%s" alt="Video Thumbnail" class="w-full rounded-md mb-4" />
+		<p class="text-white mb-2"><strong>Title:</strong> %s</p>
+		<p class="text-white mb-2"><strong>Author:</strong> %s</p>
+		<p class="text-white mb-2"><strong>Premieres:</strong> %s</p>
+		<form hx-post="/schedule" hx-target="this" hx-swap="outerHTML" class="flex flex-col gap-4 mt-4">
+			<input type="hidden" name="videoURL" value="%s">
+			<input name="webhookURL" type="url" placeholder="Webhook URL (optional)" class="w-full text-black rounded p-3">
+			<input name="email" type="email" placeholder="Email address (optional)" class="w-full text-black rounded p-3">
+			<button type="submit" class="bg-red-900 text-white rounded p-3 hover:bg-blue-600">
+				Download when released
+			</button>
+		</form>
+		</div>`,
+		html.EscapeString(videoData.Thumbnail),
+		html.EscapeString(videoData.Title),
+		html.EscapeString(videoData.Author),
+		releaseAt.Format("Mon, 02 Jan 2006 15:04 MST"),
+		html.EscapeString(videoData.URL),
+	)
+}
+
+func handleSchedule(w http.ResponseWriter, r *http.Request) {
+	if !parseForm(w, r) {
+		return
+	}
+
+	req := jobRequest{
+		URL:        r.FormValue("videoURL"),
+		WebhookURL: r.FormValue("webhookURL"),
+		Email:      r.FormValue("email"),
+	}
+	tenant := requestTenant(r)
+	if err := req.validate(tenant); err != nil {
+		writeError(w, r, http.StatusBadRequest, validationErrorCode(err), err.Error())
+		return
+	}
+
+	job, err := createJob(req, tenant, requestUser(r), clientIP(r))
+	if errors.Is(err, errQuotaExceeded) {
+		writeError(w, r, http.StatusTooManyRequests, codeQuotaExceeded, "Daily job quota exceeded")
+		return
+	}
+	if writeStorageQuotaError(w, r, err) {
+		return
+	}
+	if writeCooldownError(w, r, err) {
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, fmt.Sprintf("Error scheduling download: %v", err))
+		return
+	}
+
+	when := "as soon as possible"
+	if job.RunAt != nil {
+		when = "at " + job.RunAt.Format("Mon, 02 Jan 2006 15:04 MST")
+	}
+	wait := describeWait(estimateJob(job))
+	if job.DeferredReason != "" {
+		wait = job.DeferredReason
+	}
+	fmt.Fprintf(w, `
+		<div class="mt-4 p-3 rounded-md bg-neutral-800">
+			<p class="text-white mb-2">Download scheduled %s. %s</p>
+			<p class="text-white text-sm">Track it at <a class="underline" href="/api/v1/jobs/%s">/api/v1/jobs/%s</a></p>
+		</div>`, when, html.EscapeString(wait), job.ID, job.ID)
+}
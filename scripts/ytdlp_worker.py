@@ -0,0 +1,32 @@
+import io
+import json
+import sys
+
+import yt_dlp
+
+
+def extract(req):
+    captured = io.StringIO()
+    stdout, stderr = sys.stdout, sys.stderr
+    sys.stdout = sys.stderr = captured
+    try:
+        opts = yt_dlp.parse_options(req.get("args", [])).ydl_opts
+        opts.update(quiet=True, simulate=True, forcejson=False)
+        with yt_dlp.YoutubeDL(opts) as ydl:
+            info = ydl.extract_info(req["url"], download=False)
+            return {"info": ydl.sanitize_info(info)}
+    except BaseException as e:
+        return {"error": str(e) or type(e).__name__, "stderr": captured.getvalue()}
+    finally:
+        sys.stdout, sys.stderr = stdout, stderr
+
+
+def main():
+    for line in sys.stdin:
+        resp = extract(json.loads(line))
+        sys.stdout.write(json.dumps(resp) + "\n")
+        sys.stdout.flush()
+
+
+if __name__ == "__main__":
+    main()
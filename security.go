@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+const defaultContentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' 'unsafe-eval' https://cdn.tailwindcss.com https://unpkg.com; " +
+	"style-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data: https:; " +
+	"media-src 'self' blob:; " +
+	"connect-src 'self'; " +
+	"object-src 'none'; " +
+	"base-uri 'self'; " +
+	"form-action 'self'"
+
+func contentSecurityPolicy() string {
+	policy := strings.TrimSpace(cfg.ContentSecurityPolicy)
+	if cfg.FrameAncestors != "" && !strings.Contains(policy, "frame-ancestors") {
+		if policy != "" {
+			policy = strings.TrimSuffix(policy, ";") + "; "
+		}
+		policy += "frame-ancestors " + cfg.FrameAncestors
+	}
+	return policy
+}
+
+func withSecurityHeaders(next http.Handler) http.Handler {
+	if !cfg.SecurityHeaders {
+		return next
+	}
+	policy := contentSecurityPolicy()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		if policy != "" {
+			h.Set("Content-Security-Policy", policy)
+		}
+		if cfg.ReferrerPolicy != "" {
+			h.Set("Referrer-Policy", cfg.ReferrerPolicy)
+		}
+		if cfg.FrameAncestors == "'none'" {
+			h.Set("X-Frame-Options", "DENY")
+		} else if cfg.FrameAncestors == "'self'" {
+			h.Set("X-Frame-Options", "SAMEORIGIN")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+)
+
+type SiteCapabilities struct {
+	Video     bool `json:"video"`
+	AudioOnly bool `json:"audio_only"`
+	Playlists bool `json:"playlists"`
+	Subtitles bool `json:"subtitles"`
+	Live      bool `json:"live"`
+}
+
+type SiteInfo struct {
+	Site          string           `json:"site"`
+	Hosts         []string         `json:"hosts"`
+	Enabled       bool             `json:"enabled"`
+	CooldownUntil *time.Time       `json:"cooldown_until,omitempty"`
+	Capabilities  SiteCapabilities `json:"capabilities"`
+}
+
+var defaultSiteCapabilities = SiteCapabilities{Video: true, AudioOnly: true}
+
+var siteCapabilities = map[string]SiteCapabilities{
+	"youtube":   {Video: true, AudioOnly: true, Playlists: true, Subtitles: true, Live: true},
+	"twitter":   {Video: true, AudioOnly: true},
+	"facebook":  {Video: true, AudioOnly: true, Live: true},
+	"instagram": {Video: true, AudioOnly: true},
+	"tiktok":    {Video: true, AudioOnly: true, Playlists: true},
+	"linkedin":  {Video: true, AudioOnly: true},
+	"snapchat":  {Video: true},
+	"pinterest": {Video: true},
+	"vimeo":     {Video: true, AudioOnly: true, Playlists: true, Subtitles: true},
+	"twitch":    {Video: true, AudioOnly: true, Playlists: true, Live: true},
+	"threads":   {Video: true},
+	"reddit":    {Video: true, AudioOnly: true},
+	"discord":   {Video: true},
+	"bilibili":  {Video: true, AudioOnly: true, Playlists: true, Subtitles: true},
+	"rumble":    {Video: true, AudioOnly: true, Live: true},
+	"kick":      {Video: true, AudioOnly: true, Live: true},
+}
+
+func capabilitiesFor(site string) SiteCapabilities {
+	c, ok := siteCapabilities[site]
+	if !ok {
+		c = defaultSiteCapabilities
+	}
+	c.AudioOnly = c.AudioOnly && flagEnabled(flagAudio)
+	c.Playlists = c.Playlists && flagEnabled(flagSubscriptions)
+	c.Subtitles = c.Subtitles && flagEnabled(flagBundles)
+	return c
+}
+
+func supportedSites(tenant *Tenant) []SiteInfo {
+	bySite := make(map[string]*SiteInfo)
+	for host := range utils.AllowedHosts {
+		if !tenant.AllowsURL("https://" + host + "/") {
+			continue
+		}
+		site := siteLabel(&url.URL{Host: host})
+		info, ok := bySite[site]
+		if !ok {
+			info = &SiteInfo{Site: site, Enabled: flagEnabled(extractorFlagPrefix + site), Capabilities: capabilitiesFor(site)}
+			if c, on := activeCooldown(site); on {
+				info.CooldownUntil = &c.Until
+			}
+			bySite[site] = info
+		}
+		info.Hosts = append(info.Hosts, host)
+	}
+	sites := make([]SiteInfo, 0, len(bySite))
+	for _, info := range bySite {
+		sort.Strings(info.Hosts)
+		sites = append(sites, *info)
+	}
+	sort.Slice(sites, func(i, j int) bool { return sites[i].Site < sites[j].Site })
+	return sites
+}
+
+func handleSites(w http.ResponseWriter, r *http.Request) {
+	resp := map[string]any{
+		"validator": validatorName(),
+		"sites":     supportedSites(requestTenant(r)),
+		"defaults":  capabilitiesFor(""),
+	}
+	if cfg.URLValidator != "" && cfg.URLValidator != validatorStatic {
+		names, err := ytdlpExtractors.Names()
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, codeInternal, "Error listing yt-dlp extractors")
+			return
+		}
+		resp["extractors"] = names
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func validatorName() string {
+	if cfg.URLValidator == "" {
+		return validatorStatic
+	}
+	return cfg.URLValidator
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+const (
+	sniffLength = 512
+
+	failureContentMismatch = "content_mismatch"
+)
+
+var contentMismatches = metrics.Counter("odl_content_mismatches_total", "Downloads stopped because the data did not match the container of the requested format.", "expected", "detected")
+
+var containerFamilies = map[string]string{
+	"mp4":  "mp4",
+	"m4a":  "mp4",
+	"m4v":  "mp4",
+	"mov":  "mp4",
+	"3gp":  "mp4",
+	"webm": "matroska",
+	"weba": "matroska",
+	"mkv":  "matroska",
+	"ts":   "mpegts",
+	"flv":  "flv",
+	"ogg":  "ogg",
+	"opus": "ogg",
+	"mp3":  "mpeg_audio",
+	"aac":  "mpeg_audio",
+	"flac": "flac",
+	"wav":  "riff",
+	"avi":  "riff",
+}
+
+var mp4BoxTypes = []string{"ftyp", "styp", "moov", "moof", "mdat", "free", "skip", "wide"}
+
+type ContentMismatchError struct {
+	Expected string
+	Detected string
+}
+
+func (e *ContentMismatchError) Error() string {
+	return fmt.Sprintf("the source sent %s instead of %s data", e.Detected, e.Expected)
+}
+
+func detectContainer(head []byte) string {
+	switch {
+	case len(head) >= 8 && slices.Contains(mp4BoxTypes, string(head[4:8])):
+		return "mp4"
+	case bytes.HasPrefix(head, []byte{0x1a, 0x45, 0xdf, 0xa3}):
+		return "matroska"
+	case len(head) > 188 && head[0] == 0x47 && head[188] == 0x47:
+		return "mpegts"
+	case bytes.HasPrefix(head, []byte("FLV")):
+		return "flv"
+	case bytes.HasPrefix(head, []byte("OggS")):
+		return "ogg"
+	case bytes.HasPrefix(head, []byte("ID3")), len(head) >= 2 && head[0] == 0xff && head[1]&0xe0 == 0xe0:
+		return "mpeg_audio"
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return "flac"
+	case bytes.HasPrefix(head, []byte("RIFF")):
+		return "riff"
+	}
+	return ""
+}
+
+func describeNonMedia(head []byte) string {
+	mediaType, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	switch {
+	case mediaType == "text/html":
+		return "an HTML page"
+	case bytes.HasPrefix(bytes.TrimSpace(head), []byte("{")), strings.Contains(mediaType, "json"):
+		return "a JSON document"
+	case strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "xml"):
+		return "a text document"
+	}
+	return ""
+}
+
+func checkContainer(head []byte, ext string) error {
+	if !cfg.ContentSniffing || len(head) == 0 {
+		return nil
+	}
+	expected := containerFamilies[strings.ToLower(strings.TrimPrefix(ext, "."))]
+	detected := detectContainer(head)
+	if detected != "" && (expected == "" || detected == expected) {
+		return nil
+	}
+	label := strings.TrimPrefix(ext, ".")
+	if label == "" {
+		label = "media"
+	}
+	if what := describeNonMedia(head); what != "" {
+		contentMismatches.Inc(expected, "text")
+		return &ContentMismatchError{Expected: label, Detected: what}
+	}
+	if detected != "" && expected != "" {
+		contentMismatches.Inc(expected, detected)
+		return &ContentMismatchError{Expected: label, Detected: detected + " data"}
+	}
+	return nil
+}
+
+func sniffStream(br *bufio.Reader, ext string) error {
+	head, err := br.Peek(sniffLength)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	return checkContainer(head, ext)
+}
+
+func sniffFile(path, ext string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && err != io.EOF {
+		return err
+	}
+	return checkContainer(head[:n], ext)
+}
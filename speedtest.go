@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+)
+
+const (
+	defaultSpeedTestSeconds = 5
+	maxSpeedTestSeconds     = 30
+)
+
+type SpeedTestResult struct {
+	URL           string  `json:"url"`
+	MediaHost     string  `json:"media_host,omitempty"`
+	Status        int     `json:"status"`
+	ExtractMS     int64   `json:"extract_ms,omitempty"`
+	DNSMS         int64   `json:"dns_ms"`
+	ConnectMS     int64   `json:"connect_ms"`
+	TLSMS         int64   `json:"tls_ms,omitempty"`
+	TTFBMS        int64   `json:"ttfb_ms"`
+	Bytes         int64   `json:"bytes"`
+	DurationMS    int64   `json:"duration_ms"`
+	ThroughputBps float64 `json:"throughput_bps"`
+	Throughput    string  `json:"throughput"`
+	Error         string  `json:"error,omitempty"`
+}
+
+func resolveMediaURL(videoURL, formatID string) (string, error) {
+	if formatID == "" {
+		formatID = "b"
+	}
+	output, err := command("yt-dlp", ytdlpArgs(pickProxy(urlSource(videoURL)), "-g", "-f", formatID, "--no-playlist", videoURL)...).Output()
+	if err != nil {
+		return "", err
+	}
+	mediaURL, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	if mediaURL == "" {
+		return "", errors.New("yt-dlp returned no media URL")
+	}
+	return mediaURL, nil
+}
+
+func runSpeedTest(target string, limit time.Duration) *SpeedTestResult {
+	result := &SpeedTestResult{URL: target}
+	parsed, err := url.Parse(target)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.MediaHost = parsed.Host
+
+	reqCtx, cancel := context.WithTimeout(context.Background(), limit+10*time.Second)
+	defer cancel()
+
+	var start, dnsStart, connectStart, tlsStart time.Time
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			result.DNSMS = time.Since(dnsStart).Milliseconds()
+		},
+		ConnectStart: func(string, string) { connectStart = time.Now() },
+		ConnectDone: func(string, string, error) {
+			result.ConnectMS = time.Since(connectStart).Milliseconds()
+		},
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			result.TLSMS = time.Since(tlsStart).Milliseconds()
+		},
+		GotFirstResponseByte: func() {
+			result.TTFBMS = time.Since(start).Milliseconds()
+		},
+	}
+
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(reqCtx, trace), http.MethodGet, target, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment, DisableKeepAlives: true}
+	start = time.Now()
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+	result.Status = resp.StatusCode
+
+	bodyStart := time.Now()
+	deadline := time.AfterFunc(limit, cancel)
+	defer deadline.Stop()
+	n, err := io.Copy(io.Discard, resp.Body)
+	elapsed := time.Since(bodyStart)
+	if err != nil && !errors.Is(err, context.Canceled) && reqCtx.Err() == nil {
+		result.Error = err.Error()
+	}
+
+	result.Bytes = n
+	result.DurationMS = elapsed.Milliseconds()
+	if elapsed > 0 {
+		result.ThroughputBps = float64(n) / elapsed.Seconds()
+	}
+	result.Throughput = utils.FormatBytes(int64(result.ThroughputBps)) + "/s"
+	return result
+}
+
+func handleSpeedTest(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	target := q.Get("url")
+	if target == "" {
+		target = cfg.SpeedTestURL
+	}
+	seconds := defaultSpeedTestSeconds
+	if v, err := strconv.Atoi(q.Get("seconds")); err == nil && v > 0 {
+		seconds = min(v, maxSpeedTestSeconds)
+	}
+
+	var extract time.Duration
+	mediaURL := target
+	if utils.ValidateURL(target) {
+		start := time.Now()
+		resolved, err := resolveMediaURL(target, q.Get("format"))
+		extract = time.Since(start)
+		if err != nil {
+			writeError(w, r, http.StatusBadGateway, codeUpstreamError, fmt.Sprintf("Error resolving media URL: %v", err))
+			return
+		}
+		mediaURL = resolved
+	}
+
+	result := runSpeedTest(mediaURL, time.Duration(seconds)*time.Second)
+	result.URL = target
+	result.ExtractMS = extract.Milliseconds()
+	writeJSON(w, http.StatusOK, result)
+}
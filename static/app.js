@@ -0,0 +1,26 @@
+document.addEventListener("DOMContentLoaded", () => {
+    const input = document.querySelector('input[name="videoURL"]');
+
+    input.addEventListener("invalid", (event) => {
+        event.preventDefault()
+        input.setCustomValidity("Please enter a valid video URL from YouTube, Instagram, X, TikTok, etc.")
+        input.reportValidity()
+    })
+
+    input.addEventListener("input", () => {
+        input.setCustomValidity("")
+    })
+})
+
+window.addEventListener("load", () => {
+    const sharedURL = new URLSearchParams(window.location.search).get("videoURL")
+    if (sharedURL) {
+        const input = document.querySelector('input[name="videoURL"]')
+        input.value = sharedURL
+        htmx.trigger(input.form, "submit")
+    }
+})
+
+if ("serviceWorker" in navigator) {
+    navigator.serviceWorker.register("/sw.js")
+}
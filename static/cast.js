@@ -0,0 +1,6 @@
+const player = document.getElementById("player")
+document.getElementById("cast").addEventListener("click", () => {
+    if (player.remote) {
+        player.remote.prompt().catch(() => {})
+    }
+})
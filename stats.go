@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	statsHourTTL       = 48 * time.Hour
+	statsDayTTL        = 32 * 24 * time.Hour
+	defaultStatsWindow = 7
+	maxStatsWindow     = 31
+	topDomainsLimit    = 10
+)
+
+type StatsBucket struct {
+	Start     time.Time `json:"start"`
+	Downloads int64     `json:"downloads"`
+	Failed    int64     `json:"failed"`
+}
+
+type DomainCount struct {
+	Domain    string `json:"domain"`
+	Downloads int64  `json:"downloads"`
+}
+
+type Stats struct {
+	WindowDays      int              `json:"window_days"`
+	Downloads       int64            `json:"downloads"`
+	Failed          int64            `json:"failed"`
+	FailureRate     float64          `json:"failure_rate"`
+	FailuresByClass map[string]int64 `json:"failures_by_class"`
+	Hourly          []StatsBucket    `json:"hourly"`
+	Daily           []StatsBucket    `json:"daily"`
+	TopDomains      []DomainCount    `json:"top_domains"`
+	AverageDuration float64          `json:"average_duration_seconds"`
+	CacheHits       int64            `json:"cache_hits"`
+	CacheMisses     int64            `json:"cache_misses"`
+	CacheHitRatio   float64          `json:"cache_hit_ratio"`
+	Transfers       int64            `json:"transfers"`
+	TransferBytes   int64            `json:"transfer_bytes"`
+	Incomplete      int64            `json:"incomplete_transfers"`
+	AverageSpeed    float64          `json:"average_bytes_per_second"`
+
+	BandwidthBudget *BandwidthBudget `json:"bandwidth_budget,omitempty"`
+	StoredBytes     int64            `json:"stored_bytes"`
+}
+
+func statsHourKey(t time.Time) string {
+	return fmt.Sprintf("stats:h:%s", t.UTC().Format("2006010215"))
+}
+
+func statsDayKey(t time.Time) string {
+	return fmt.Sprintf("stats:d:%s", t.UTC().Format("20060102"))
+}
+
+func statsDomainsKey(t time.Time) string {
+	return fmt.Sprintf("stats:domains:%s", t.UTC().Format("20060102"))
+}
+
+func statsDomain(videoURL string) string {
+	u, err := url.Parse(videoURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+func failureClass(err error) string {
+	var ytdlpErr *YTDLPError
+	var cooldownErr *CooldownError
+	var diskErr *DiskFullError
+	switch {
+	case errors.As(err, &cooldownErr):
+		return "rate_limited"
+	case errors.As(err, &diskErr):
+		return "disk_full"
+	case errors.As(err, &ytdlpErr):
+		return ytdlpErr.Class
+	case errors.Is(err, errJobPanicked):
+		return "internal"
+	default:
+		return "other"
+	}
+}
+
+func recordOutcome(videoURL string, err error) {
+	now := time.Now()
+	hour, day := statsHourKey(now), statsDayKey(now)
+	rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		if err == nil {
+			pipe.HIncrBy(ctx, hour, "downloads", 1)
+			pipe.HIncrBy(ctx, day, "downloads", 1)
+			if domain := statsDomain(videoURL); domain != "" {
+				pipe.ZIncrBy(ctx, statsDomainsKey(now), 1, domain)
+				pipe.Expire(ctx, statsDomainsKey(now), statsDayTTL)
+			}
+		} else {
+			pipe.HIncrBy(ctx, hour, "failed", 1)
+			pipe.HIncrBy(ctx, day, "failed", 1)
+			pipe.HIncrBy(ctx, day, "failed:"+failureClass(err), 1)
+		}
+		pipe.Expire(ctx, hour, statsHourTTL)
+		pipe.Expire(ctx, day, statsDayTTL)
+		return nil
+	})
+}
+
+func recordCacheLookup(hit bool) {
+	field := "cache_miss"
+	if hit {
+		field = "cache_hit"
+	}
+	day := statsDayKey(time.Now())
+	rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(ctx, day, field, 1)
+		pipe.Expire(ctx, day, statsDayTTL)
+		return nil
+	})
+}
+
+func statsCounters(key string) (map[string]int64, error) {
+	fields, err := rdb.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	counters := make(map[string]int64, len(fields))
+	for name, value := range fields {
+		counters[name], _ = strconv.ParseInt(value, 10, 64)
+	}
+	return counters, nil
+}
+
+func collectStats(days int) (*Stats, error) {
+	now := time.Now().UTC()
+	stats := &Stats{WindowDays: days, FailuresByClass: map[string]int64{}, Hourly: []StatsBucket{}, Daily: []StatsBucket{}}
+
+	hour := now.Truncate(time.Hour)
+	for i := 23; i >= 0; i-- {
+		start := hour.Add(-time.Duration(i) * time.Hour)
+		counters, err := statsCounters(statsHourKey(start))
+		if err != nil {
+			return nil, err
+		}
+		stats.Hourly = append(stats.Hourly, StatsBucket{Start: start, Downloads: counters["downloads"], Failed: counters["failed"]})
+	}
+
+	domains := map[string]int64{}
+	var transferMillis int64
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	for i := days - 1; i >= 0; i-- {
+		start := today.AddDate(0, 0, -i)
+		counters, err := statsCounters(statsDayKey(start))
+		if err != nil {
+			return nil, err
+		}
+		stats.Daily = append(stats.Daily, StatsBucket{Start: start, Downloads: counters["downloads"], Failed: counters["failed"]})
+		stats.Downloads += counters["downloads"]
+		stats.Failed += counters["failed"]
+		stats.CacheHits += counters["cache_hit"]
+		stats.CacheMisses += counters["cache_miss"]
+		stats.Transfers += counters["transfers"]
+		stats.TransferBytes += counters["transfer_bytes"]
+		stats.Incomplete += counters["transfers_incomplete"]
+		transferMillis += counters["transfer_ms"]
+		for name, n := range counters {
+			if class, ok := strings.CutPrefix(name, "failed:"); ok {
+				stats.FailuresByClass[class] += n
+			}
+		}
+
+		top, err := rdb.ZRangeWithScores(ctx, statsDomainsKey(start), 0, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, z := range top {
+			domains[z.Member.(string)] += int64(z.Score)
+		}
+	}
+
+	for domain, n := range domains {
+		stats.TopDomains = append(stats.TopDomains, DomainCount{Domain: domain, Downloads: n})
+	}
+	sort.Slice(stats.TopDomains, func(i, j int) bool {
+		a, b := stats.TopDomains[i], stats.TopDomains[j]
+		return a.Downloads > b.Downloads || (a.Downloads == b.Downloads && a.Domain < b.Domain)
+	})
+	if len(stats.TopDomains) > topDomainsLimit {
+		stats.TopDomains = stats.TopDomains[:topDomainsLimit]
+	}
+	if stats.TopDomains == nil {
+		stats.TopDomains = []DomainCount{}
+	}
+
+	if total := stats.Downloads + stats.Failed; total > 0 {
+		stats.FailureRate = float64(stats.Failed) / float64(total)
+	}
+	if lookups := stats.CacheHits + stats.CacheMisses; lookups > 0 {
+		stats.CacheHitRatio = float64(stats.CacheHits) / float64(lookups)
+	}
+	if transferMillis > 0 {
+		stats.AverageSpeed = float64(stats.TransferBytes) / (float64(transferMillis) / 1000)
+	}
+	stats.AverageDuration = averageJobDuration().Seconds()
+	stats.BandwidthBudget = currentBandwidthBudget()
+	stats.StoredBytes = totalStoredBytes()
+	return stats, nil
+}
+
+func statsAccess(next http.HandlerFunc) http.HandlerFunc {
+	if cfg.StatsPublic {
+		return next
+	}
+	return requireAdmin(next)
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	days := defaultStatsWindow
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid days")
+			return
+		}
+		days = min(n, maxStatsWindow)
+	}
+	stats, err := collectStats(days)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading statistics")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, stats)
+}
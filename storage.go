@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	storageTenantsKey     = "storage_usage:tenants"
+	storageUsersKey       = "storage_usage:users"
+	storageTenantBlobsKey = "storage_blobs:tenants"
+	storageUserBlobsKey   = "storage_blobs:users"
+)
+
+var addBlobUsageScript = redis.NewScript(`
+local field = ARGV[1] .. ":" .. ARGV[2]
+local delta = tonumber(ARGV[3])
+local step = 1
+if delta < 0 then
+	step = -1
+end
+local refs = redis.call("HINCRBY", KEYS[2], field, step)
+if refs <= 0 then
+	redis.call("HDEL", KEYS[2], field)
+end
+if (step > 0 and refs == 1) or (step < 0 and refs == 0) then
+	redis.call("HINCRBY", KEYS[1], ARGV[1], delta)
+end
+return refs`)
+
+var storageQuotaRefusals = metrics.Counter("odl_storage_quota_refusals_total", "Jobs refused because a storage quota was used up.", "scope")
+
+type StorageUsage struct {
+	UsedBytes  int64 `json:"used_bytes"`
+	QuotaBytes int64 `json:"quota_bytes,omitempty"`
+}
+
+type StorageQuotaError struct {
+	Scope string
+	StorageUsage
+}
+
+func (e *StorageQuotaError) Error() string {
+	return fmt.Sprintf("the %s storage quota of %s is used up, %s is stored", e.Scope, utils.FormatBytes(e.QuotaBytes), utils.FormatBytes(e.UsedBytes))
+}
+
+func (t *Tenant) storageQuota() int64 {
+	if t.Quota.StorageMB > 0 {
+		return t.Quota.StorageMB * 1024 * 1024
+	}
+	return cfg.StorageQuota
+}
+
+func (t *Tenant) userStorageQuota() int64 {
+	if t.Quota.UserStorageMB > 0 {
+		return t.Quota.UserStorageMB * 1024 * 1024
+	}
+	return cfg.UserStorageQuota
+}
+
+func addStorageUsage(artifact *Artifact, delta int64) {
+	if artifact.Blob != "" {
+		addBlobUsageScript.Run(ctx, rdb, []string{storageTenantsKey, storageTenantBlobsKey}, artifact.Tenant, artifact.Blob, delta)
+		if artifact.UserID != "" {
+			addBlobUsageScript.Run(ctx, rdb, []string{storageUsersKey, storageUserBlobsKey}, artifact.UserID, artifact.Blob, delta)
+		}
+		return
+	}
+	rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(ctx, storageTenantsKey, artifact.Tenant, delta)
+		if artifact.UserID != "" {
+			pipe.HIncrBy(ctx, storageUsersKey, artifact.UserID, delta)
+		}
+		return nil
+	})
+}
+
+func storedBytes(key, id string) int64 {
+	n, err := rdb.HGet(ctx, key, id).Int64()
+	if err != nil && err != redis.Nil {
+		log.Printf("storage usage: %v", err)
+	}
+	return max(n, 0)
+}
+
+func totalStoredBytes() int64 {
+	usage, err := rdb.HVals(ctx, storageTenantsKey).Result()
+	if err != nil {
+		return 0
+	}
+	var total int64
+	for _, v := range usage {
+		n, _ := strconv.ParseInt(v, 10, 64)
+		total += max(n, 0)
+	}
+	return total
+}
+
+func tenantStorageUsage(t *Tenant) StorageUsage {
+	return StorageUsage{UsedBytes: storedBytes(storageTenantsKey, t.ID), QuotaBytes: t.storageQuota()}
+}
+
+func userStorageUsage(t *Tenant, userID string) StorageUsage {
+	return StorageUsage{UsedBytes: storedBytes(storageUsersKey, userID), QuotaBytes: t.userStorageQuota()}
+}
+
+func checkStorageQuota(t *Tenant, userID string) error {
+	if usage := tenantStorageUsage(t); usage.QuotaBytes > 0 && usage.UsedBytes >= usage.QuotaBytes {
+		storageQuotaRefusals.Inc("tenant")
+		return &StorageQuotaError{Scope: "tenant", StorageUsage: usage}
+	}
+	if userID == "" {
+		return nil
+	}
+	if usage := userStorageUsage(t, userID); usage.QuotaBytes > 0 && usage.UsedBytes >= usage.QuotaBytes {
+		storageQuotaRefusals.Inc("user")
+		return &StorageQuotaError{Scope: "user", StorageUsage: usage}
+	}
+	return nil
+}
+
+func writeStorageQuotaError(w http.ResponseWriter, r *http.Request, err error) bool {
+	var quotaErr *StorageQuotaError
+	if !errors.As(err, &quotaErr) {
+		return false
+	}
+	writeErrorDetails(w, r, http.StatusInsufficientStorage, codeQuotaExceeded, "Storage quota exceeded", map[string]any{
+		"scope":       quotaErr.Scope,
+		"used_bytes":  quotaErr.UsedBytes,
+		"quota_bytes": quotaErr.QuotaBytes,
+	})
+	return true
+}
+
+func rebuildStorageUsage() {
+	if n, err := rdb.Exists(ctx, storageTenantsKey).Result(); err != nil || n > 0 {
+		return
+	}
+	ids, err := rdb.ZRange(ctx, artifactIndexKey, 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return
+	}
+	byTenant, byUser := map[string]int64{}, map[string]int64{}
+	tenantBlobs, userBlobs := map[string]int64{}, map[string]int64{}
+	for _, id := range ids {
+		artifact, err := getArtifact(id)
+		if err != nil || artifact.DeletedAt != nil {
+			continue
+		}
+		if artifact.Tenant == "" {
+			job, err := getJob(artifact.JobID)
+			if err != nil {
+				continue
+			}
+			artifact.Tenant, artifact.UserID = job.Tenant, job.UserID
+		}
+		tenantBlob, userBlob := artifact.Tenant+":"+artifact.Blob, artifact.UserID+":"+artifact.Blob
+		if artifact.Blob != "" {
+			tenantBlobs[tenantBlob]++
+		}
+		if artifact.Blob == "" || tenantBlobs[tenantBlob] == 1 {
+			byTenant[artifact.Tenant] += artifact.Size
+		}
+		if artifact.UserID == "" {
+			continue
+		}
+		if artifact.Blob != "" {
+			userBlobs[userBlob]++
+		}
+		if artifact.Blob == "" || userBlobs[userBlob] == 1 {
+			byUser[artifact.UserID] += artifact.Size
+		}
+	}
+	rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, storageTenantBlobsKey, storageUserBlobsKey)
+		for field, n := range tenantBlobs {
+			pipe.HSet(ctx, storageTenantBlobsKey, field, n)
+		}
+		for field, n := range userBlobs {
+			pipe.HSet(ctx, storageUserBlobsKey, field, n)
+		}
+		for id, n := range byTenant {
+			pipe.HSet(ctx, storageTenantsKey, id, n)
+		}
+		for id, n := range byUser {
+			pipe.HSet(ctx, storageUsersKey, id, n)
+		}
+		return nil
+	})
+	log.Printf("storage usage: counted %d stored files", len(ids))
+}
+
+func handleStorageUsage(w http.ResponseWriter, r *http.Request) {
+	tenant := requestTenant(r)
+	resp := map[string]any{"tenant": tenantStorageUsage(tenant)}
+	if user := requestUser(r); user != nil {
+		resp["user"] = userStorageUsage(tenant, user.ID)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type ownerStorageUsage struct {
+	ID     string `json:"id"`
+	Name   string `json:"name,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+	StorageUsage
+}
+
+func handleAdminStorageUsage(w http.ResponseWriter, r *http.Request) {
+	tenantUsage, err := rdb.HGetAll(ctx, storageTenantsKey).Result()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error reading storage usage")
+		return
+	}
+	users, err := listUsers()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error listing users")
+		return
+	}
+
+	known := append([]*Tenant{defaultTenant}, tenants...)
+	byTenant := make([]ownerStorageUsage, 0, len(known))
+	for _, t := range known {
+		used, _ := strconv.ParseInt(tenantUsage[t.ID], 10, 64)
+		byTenant = append(byTenant, ownerStorageUsage{ID: t.ID, StorageUsage: StorageUsage{UsedBytes: max(used, 0), QuotaBytes: t.storageQuota()}})
+	}
+	byUser := make([]ownerStorageUsage, 0, len(users))
+	for _, u := range users {
+		byUser = append(byUser, ownerStorageUsage{ID: u.ID, Name: u.Name, Tenant: u.Tenant, StorageUsage: userStorageUsage(tenantByID(u.Tenant), u.ID)})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"tenants": byTenant, "users": byUser})
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	artifactIndexKey   = "artifacts:index"
+	artifactRecycleKey = "artifacts:recycle"
+)
+
+var (
+	errArtifactNotFound = errors.New("artifact not found")
+	errArtifactDeleted  = errors.New("artifact deleted")
+)
+
+type Artifact struct {
+	ID           string     `json:"id"`
+	JobID        string     `json:"job_id"`
+	Tenant       string     `json:"tenant,omitempty"`
+	UserID       string     `json:"user_id,omitempty"`
+	Subscription string     `json:"subscription_id,omitempty"`
+	FileName     string     `json:"file_name"`
+	Path         string     `json:"-"`
+	Blob         string     `json:"-"`
+	Size         int64      `json:"size"`
+	Checksum     string     `json:"checksum"`
+	ContentType  string     `json:"content_type"`
+	CreatedAt    time.Time  `json:"created_at"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"`
+	DeleteReason string     `json:"delete_reason,omitempty"`
+	Protected    bool       `json:"protected,omitempty"`
+	Deduplicated bool       `json:"deduplicated,omitempty"`
+	Tier         string     `json:"tier,omitempty"`
+	FrozenAt     *time.Time `json:"frozen_at,omitempty"`
+}
+
+type ContentStore struct {
+	dir string
+}
+
+var store *ContentStore
+
+func newContentStore(dir string) (*ContentStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &ContentStore{dir: dir}, nil
+}
+
+func (s *ContentStore) UserRoot(prefix, userID string) string {
+	root := filepath.Join(s.dir, filepath.Clean("/"+prefix))
+	if userID != "" {
+		root = filepath.Join(root, "users", userID)
+	}
+	return root
+}
+
+func (s *ContentStore) JobDir(prefix, userID, jobID string) (string, error) {
+	dir := filepath.Join(s.UserRoot(prefix, userID), "jobs", jobID)
+	return dir, os.MkdirAll(dir, 0o755)
+}
+
+func (s *ContentStore) AddArtifact(job *Job, path string) (*Artifact, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	checksum, err := utils.FileSHA256(path)
+	if err != nil {
+		return nil, err
+	}
+	protected, err := protectJobFile(job.ID, path)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting %s with the passphrase: %w", filepath.Base(path), err)
+	}
+	var blob string
+	if !protected {
+		blob = deduplicateFile(path, checksum, info.Size())
+	}
+	deduplicated := blob != ""
+	if keyring != nil && !protected && !deduplicated {
+		if err := encryptFile(path); err != nil {
+			return nil, fmt.Errorf("encrypting %s: %w", filepath.Base(path), err)
+		}
+		encryptedArtifacts.Inc()
+	}
+
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	contentType := utils.MediaType(ext, false)
+	if ext == "zip" {
+		contentType = "application/zip"
+	}
+	artifact := &Artifact{
+		ID:           utils.RandomID(16),
+		JobID:        job.ID,
+		Tenant:       job.Tenant,
+		UserID:       job.UserID,
+		Subscription: job.SubscriptionID,
+		FileName:     filepath.Base(path),
+		Path:         path,
+		Size:         info.Size(),
+		Checksum:     checksum,
+		ContentType:  contentType,
+		CreatedAt:    time.Now().UTC(),
+		Protected:    protected,
+		Deduplicated: deduplicated,
+		Blob:         blob,
+	}
+	if blob == "" && cfg.Deduplicate && !protected {
+		artifact.Blob = artifact.ID
+	}
+	if err := saveArtifact(artifact); err != nil {
+		return nil, err
+	}
+	rdb.ZAdd(ctx, artifactIndexKey, redis.Z{Score: float64(artifact.CreatedAt.Unix()), Member: artifact.ID})
+	addStorageUsage(artifact, artifact.Size)
+	indexArtifactChecksum(artifact)
+	s.Touch(artifact)
+	return artifact, nil
+}
+
+func (s *ContentStore) Open(artifact *Artifact) (storedFile, error) {
+	return s.Unlock(artifact, "")
+}
+
+func (s *ContentStore) Unlock(artifact *Artifact, passphrase string) (storedFile, error) {
+	if artifact.DeletedAt != nil {
+		return nil, errArtifactDeleted
+	}
+	if artifact.Protected && passphrase == "" {
+		return nil, errPassphraseRequired
+	}
+	s.Touch(artifact)
+	if artifact.Tier != "" {
+		if err := s.Thaw(artifact); err != nil {
+			return nil, err
+		}
+	}
+	f, err := openStoredFile(artifact.Path, passphrase)
+	if errors.Is(err, os.ErrNotExist) {
+		if current, cerr := getArtifact(artifact.ID); cerr == nil && current.Tier != "" {
+			*artifact = *current
+			if err := s.Thaw(artifact); err != nil {
+				return nil, err
+			}
+			return openStoredFile(artifact.Path, passphrase)
+		}
+	}
+	return f, err
+}
+
+func (s *ContentStore) recyclePath(artifact *Artifact) string {
+	return filepath.Join(s.dir, ".recycle", artifact.ID, filepath.Base(artifact.Path))
+}
+
+func (s *ContentStore) Quarantine(jobID, path string) error {
+	quarantinePath := filepath.Join(s.dir, ".quarantine", jobID, filepath.Base(path))
+	if err := os.MkdirAll(filepath.Dir(quarantinePath), 0o700); err != nil {
+		return err
+	}
+	if err := os.Rename(path, quarantinePath); err != nil {
+		return err
+	}
+	return os.Chmod(quarantinePath, 0o600)
+}
+
+func (s *ContentStore) SoftDelete(artifact *Artifact, reason string) error {
+	if artifact.DeletedAt != nil {
+		return nil
+	}
+	recyclePath := s.recyclePath(artifact)
+	if err := os.MkdirAll(filepath.Dir(recyclePath), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(artifact.Path, recyclePath); err != nil && !os.IsNotExist(err) && artifact.Tier == "" {
+		return err
+	}
+	os.Remove(s.torrentPath(artifact))
+
+	now := time.Now().UTC()
+	artifact.DeletedAt = &now
+	artifact.DeleteReason = reason
+	if err := saveArtifact(artifact); err != nil {
+		return err
+	}
+	addStorageUsage(artifact, -artifact.Size)
+	rdb.ZRem(ctx, artifactIndexKey, artifact.ID)
+	return rdb.ZAdd(ctx, artifactRecycleKey, redis.Z{Score: float64(now.Unix()), Member: artifact.ID}).Err()
+}
+
+func (s *ContentStore) Restore(artifact *Artifact) error {
+	if artifact.DeletedAt == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(artifact.Path), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(s.recyclePath(artifact), artifact.Path); err != nil && artifact.Tier == "" {
+		return err
+	}
+	os.Remove(filepath.Dir(s.recyclePath(artifact)))
+
+	artifact.DeletedAt = nil
+	artifact.DeleteReason = ""
+	if err := saveArtifact(artifact); err != nil {
+		return err
+	}
+	addStorageUsage(artifact, artifact.Size)
+	rdb.ZRem(ctx, artifactRecycleKey, artifact.ID)
+	return rdb.ZAdd(ctx, artifactIndexKey, redis.Z{Score: float64(time.Now().Unix()), Member: artifact.ID}).Err()
+}
+
+func (s *ContentStore) Purge(artifact *Artifact) error {
+	path := artifact.Path
+	if artifact.DeletedAt != nil {
+		path = s.recyclePath(artifact)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	os.Remove(filepath.Dir(path))
+	if artifact.DeletedAt == nil {
+		addStorageUsage(artifact, -artifact.Size)
+	}
+	if artifact.Tier != "" && coldStore != nil {
+		if err := coldStore.Delete(ctx, coldKey(artifact)); err != nil {
+			return err
+		}
+	}
+	unindexArtifactChecksum(artifact)
+	rdb.ZRem(ctx, artifactIndexKey, artifact.ID)
+	rdb.ZRem(ctx, artifactRecycleKey, artifact.ID)
+	rdb.ZRem(ctx, artifactAccessKey, artifact.ID)
+	return rdb.Del(ctx, artifactKey(artifact.ID), artifactSeedKey(artifact.ID)).Err()
+}
+
+func (s *ContentStore) RecycledArtifacts() ([]*Artifact, error) {
+	ids, err := rdb.ZRange(ctx, artifactRecycleKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	artifacts := make([]*Artifact, 0, len(ids))
+	for _, id := range ids {
+		if artifact, err := getArtifact(id); err == nil {
+			artifacts = append(artifacts, artifact)
+		}
+	}
+	return artifacts, nil
+}
+
+func subscriptionArtifact(a *Artifact) bool {
+	if a.Subscription != "" {
+		return true
+	}
+	job, err := getJob(a.JobID)
+	return err == nil && job.SubscriptionID != ""
+}
+
+func (s *ContentStore) runRetention() {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		if cfg.ArtifactRetention > 0 {
+			s.sweep(artifactIndexKey, cfg.ArtifactRetention, func(a *Artifact) error {
+				if subscriptionArtifact(a) {
+					return nil
+				}
+				return s.SoftDelete(a, "retention")
+			})
+		}
+		if cfg.SubscriptionRetention > 0 {
+			s.sweep(artifactIndexKey, cfg.SubscriptionRetention, func(a *Artifact) error {
+				if !subscriptionArtifact(a) {
+					return nil
+				}
+				return s.SoftDelete(a, "retention")
+			})
+		}
+		s.sweep(artifactRecycleKey, cfg.RecycleGrace, s.Purge)
+	}
+}
+
+func (s *ContentStore) sweep(key string, age time.Duration, fn func(*Artifact) error) {
+	ids, err := rdb.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(time.Now().Add(-age).Unix(), 10),
+	}).Result()
+	if err != nil {
+		log.Printf("retention: %v", err)
+		return
+	}
+	for _, id := range ids {
+		artifact, err := getArtifact(id)
+		if errors.Is(err, errArtifactNotFound) {
+			rdb.ZRem(ctx, key, id)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if err := fn(artifact); err != nil {
+			log.Printf("retention: artifact %s: %v", id, err)
+		}
+	}
+}
+
+func artifactKey(id string) string {
+	return fmt.Sprintf("artifact:%s", id)
+}
+
+type storedArtifact struct {
+	Artifact
+	Path string `json:"path"`
+	Blob string `json:"blob,omitempty"`
+}
+
+func saveArtifact(artifact *Artifact) error {
+	data, err := json.Marshal(storedArtifact{Artifact: *artifact, Path: artifact.Path, Blob: artifact.Blob})
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, artifactKey(artifact.ID), data, 0).Err()
+}
+
+func getArtifact(id string) (*Artifact, error) {
+	data, err := rdb.Get(ctx, artifactKey(id)).Result()
+	if err == redis.Nil {
+		return nil, errArtifactNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored storedArtifact
+	if err := json.Unmarshal([]byte(data), &stored); err != nil {
+		return nil, err
+	}
+	artifact := stored.Artifact
+	artifact.Path = stored.Path
+	artifact.Blob = stored.Blob
+	return &artifact, nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+func (s *ContentStore) FreeSpace() (int64, error) {
+	return 0, errors.New("free disk space is not available on this platform")
+}
@@ -0,0 +1,13 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+func (s *ContentStore) FreeSpace() (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(s.dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+var streamBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, cfg.StreamBufferSize)
+		return &buf
+	},
+}
+
+type readerOnly struct {
+	io.Reader
+}
+
+func copyBuffered(dst io.Writer, src io.Reader) (int64, error) {
+	buf := streamBuffers.Get().(*[]byte)
+	defer streamBuffers.Put(buf)
+	return io.CopyBuffer(dst, readerOnly{src}, *buf)
+}
+
+type flushWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	every   int
+	pending int
+}
+
+func newFlushWriter(w http.ResponseWriter) *flushWriter {
+	flusher, _ := w.(http.Flusher)
+	return &flushWriter{w: w, flusher: flusher, every: cfg.StreamFlushBytes}
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	f.pending += n
+	if err == nil && f.pending >= f.every {
+		f.Flush()
+	}
+	return n, err
+}
+
+func (f *flushWriter) Flush() {
+	if f.flusher != nil {
+		f.flusher.Flush()
+	}
+	f.pending = 0
+}
+
+func streamCommand(w http.ResponseWriter, cmd *exec.Cmd, tee io.Writer, ext string) (committed bool, err error) {
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return false, err
+	}
+	if err := cmd.Start(); err != nil {
+		return false, err
+	}
+	w.Header().Set("X-Accel-Buffering", "no")
+	fw := newFlushWriter(w)
+	src := bufio.NewReaderSize(stdout, sniffLength)
+	sniffed := make(chan error, 1)
+	go func() { sniffed <- sniffStream(src, ext) }()
+
+	var firstBytesTimeout <-chan time.Time
+	if cfg.StreamHeaderTimeout > 0 {
+		firstBytesTimeout = time.After(cfg.StreamHeaderTimeout)
+	}
+	var sniffErr error
+	select {
+	case sniffErr = <-sniffed:
+	case <-firstBytesTimeout:
+		w.WriteHeader(http.StatusOK)
+		fw.Flush()
+		committed = true
+		sniffErr = <-sniffed
+	}
+	if sniffErr != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return committed, sniffErr
+	}
+	n, copyErr := copyBuffered(io.MultiWriter(fw, tee), src)
+	committed = committed || n > 0
+	if copyErr != nil {
+		cmd.Process.Kill()
+	}
+	if err := cmd.Wait(); err != nil {
+		return committed, err
+	}
+	return committed, copyErr
+}
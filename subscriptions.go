@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	subscriptionsDueKey = "subscriptions:due"
+	minSubscriptionGap  = 15 * time.Minute
+	subscriptionLockTTL = time.Minute
+)
+
+var errSubscriptionNotFound = errors.New("subscription not found")
+
+type Subscription struct {
+	ID              string     `json:"id"`
+	UserID          string     `json:"user_id"`
+	Tenant          string     `json:"tenant"`
+	URL             string     `json:"url"`
+	Name            string     `json:"name,omitempty"`
+	FormatID        string     `json:"format_id,omitempty"`
+	IntervalMinutes int        `json:"interval_minutes"`
+	CreatedAt       time.Time  `json:"created_at"`
+	LastRunAt       *time.Time `json:"last_run_at,omitempty"`
+	NextRunAt       time.Time  `json:"next_run_at"`
+	LastError       string     `json:"last_error,omitempty"`
+	OffPeak         bool       `json:"off_peak,omitempty"`
+}
+
+func (s *Subscription) status() string {
+	if s.LastError != "" {
+		return "failing"
+	}
+	return "active"
+}
+
+type playlistEntry struct {
+	ID    string `json:"id"`
+	URL   string `json:"url"`
+	Title string `json:"title"`
+	IEKey string `json:"ie_key"`
+}
+
+func subscriptionKey(id string) string {
+	return fmt.Sprintf("subscription:%s", id)
+}
+
+func userSubscriptionsKey(userID string) string {
+	return fmt.Sprintf("user:%s:subscriptions", userID)
+}
+
+func subscriptionSeenKey(id string) string {
+	return fmt.Sprintf("subscription:%s:seen", id)
+}
+
+func (s *Subscription) interval() time.Duration {
+	return time.Duration(s.IntervalMinutes) * time.Minute
+}
+
+func saveSubscription(sub *Subscription) error {
+	data, err := json.Marshal(sub)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, subscriptionKey(sub.ID), data, 0).Err()
+}
+
+func getSubscription(id string) (*Subscription, error) {
+	data, err := rdb.Get(ctx, subscriptionKey(id)).Result()
+	if err == redis.Nil {
+		return nil, errSubscriptionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sub Subscription
+	if err := json.Unmarshal([]byte(data), &sub); err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+func createSubscription(sub *Subscription) error {
+	gap := sub.interval()
+	if gap == 0 {
+		gap = cfg.SubscriptionInterval
+	}
+	if gap < minSubscriptionGap {
+		gap = minSubscriptionGap
+	}
+	sub.ID = utils.RandomID(8)
+	sub.IntervalMinutes = int(gap / time.Minute)
+	sub.CreatedAt = time.Now().UTC()
+	sub.NextRunAt = sub.CreatedAt
+
+	if err := saveSubscription(sub); err != nil {
+		return err
+	}
+	rdb.SAdd(ctx, userSubscriptionsKey(sub.UserID), sub.ID)
+	return rdb.ZAdd(ctx, subscriptionsDueKey, redis.Z{Score: float64(sub.NextRunAt.Unix()), Member: sub.ID}).Err()
+}
+
+func deleteSubscription(sub *Subscription) error {
+	rdb.ZRem(ctx, subscriptionsDueKey, sub.ID)
+	rdb.SRem(ctx, userSubscriptionsKey(sub.UserID), sub.ID)
+	return rdb.Del(ctx, subscriptionKey(sub.ID), subscriptionSeenKey(sub.ID)).Err()
+}
+
+func listUserSubscriptions(userID string) ([]*Subscription, error) {
+	ids, err := rdb.SMembers(ctx, userSubscriptionsKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	subs := make([]*Subscription, 0, len(ids))
+	for _, id := range ids {
+		if sub, err := getSubscription(id); err == nil {
+			subs = append(subs, sub)
+		}
+	}
+	return subs, nil
+}
+
+func listAllSubscriptions() ([]*Subscription, error) {
+	ids, err := rdb.ZRange(ctx, subscriptionsDueKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	subs := make([]*Subscription, 0, len(ids))
+	for _, id := range ids {
+		if sub, err := getSubscription(id); err == nil {
+			subs = append(subs, sub)
+		}
+	}
+	return subs, nil
+}
+
+func listPlaylistEntries(playlistURL string, limit int) ([]playlistEntry, error) {
+	output, _, err := runYTDLPJSON(ytdlpArgs(pickProxy(urlSource(playlistURL)), "--flat-playlist", "-J", "--playlist-end", strconv.Itoa(limit), playlistURL), anyObject)
+	if err != nil {
+		return nil, err
+	}
+	var playlist struct {
+		Entries []playlistEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(output, &playlist); err != nil {
+		return nil, err
+	}
+	return playlist.Entries, nil
+}
+
+func runSubscriptions() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, on := activeMaintenance(); on || !flagEnabled(flagSubscriptions) {
+			continue
+		}
+		ids, err := rdb.ZRangeByScore(ctx, subscriptionsDueKey, &redis.ZRangeBy{
+			Min: "-inf",
+			Max: strconv.FormatInt(time.Now().Unix(), 10),
+		}).Result()
+		if err != nil {
+			log.Printf("subscriptions: %v", err)
+			continue
+		}
+		for _, id := range ids {
+			if removed, err := rdb.ZRem(ctx, subscriptionsDueKey, id).Result(); err != nil || removed == 0 {
+				continue
+			}
+			sub, err := getSubscription(id)
+			if err != nil {
+				continue
+			}
+			checkSubscription(sub)
+		}
+	}
+}
+
+func subscriptionLockName(id string) string {
+	return "subscription:" + id
+}
+
+func checkSubscription(sub *Subscription) {
+	lock, err := acquireLock(subscriptionLockName(sub.ID), subscriptionLockTTL)
+	if errors.Is(err, errLockHeld) {
+		return
+	}
+	if err != nil {
+		log.Printf("subscription %s: locking: %v", sub.ID, err)
+		rdb.ZAdd(ctx, subscriptionsDueKey, redis.Z{Score: float64(time.Now().Add(time.Minute).Unix()), Member: sub.ID})
+		return
+	}
+	defer lock.Release()
+
+	now := time.Now().UTC()
+	sub.LastRunAt = &now
+	sub.NextRunAt = now.Add(sub.interval())
+	sub.LastError = ""
+
+	if err := queueSubscriptionEntries(sub); err != nil {
+		sub.LastError = err.Error()
+		log.Printf("subscription %s: %v", sub.ID, err)
+	}
+	data, err := json.Marshal(sub)
+	if err == nil {
+		err = lock.SetIfHeld(subscriptionKey(sub.ID), data)
+	}
+	if errors.Is(err, errLockLost) {
+		log.Printf("subscription %s: lock lost (fence %d), discarding results", sub.ID, lock.Fence)
+		return
+	}
+	if err != nil {
+		log.Printf("subscription %s: saving: %v", sub.ID, err)
+	}
+	rdb.ZAdd(ctx, subscriptionsDueKey, redis.Z{Score: float64(sub.NextRunAt.Unix()), Member: sub.ID})
+}
+
+func queueSubscriptionEntries(sub *Subscription) error {
+	if err := checkStorageQuota(tenantByID(sub.Tenant), sub.UserID); err != nil {
+		return err
+	}
+	entries, err := listPlaylistEntries(sub.URL, cfg.SubscriptionMaxItems)
+	if err != nil {
+		return fmt.Errorf("listing entries: %w", err)
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.ID == "" || !strings.HasPrefix(entry.URL, "http") {
+			continue
+		}
+		if added, err := rdb.SAdd(ctx, subscriptionSeenKey(sub.ID), entry.ID).Result(); err != nil || added == 0 {
+			continue
+		}
+		if inDownloadArchive(sub.UserID, entry) {
+			continue
+		}
+
+		job := newJob(entry.URL, sub.FormatID)
+		job.Title = entry.Title
+		job.Tenant = sub.Tenant
+		job.UserID = sub.UserID
+		job.SubscriptionID = sub.ID
+		job.OffPeak = sub.OffPeak
+		scheduleOffPeak(job)
+		if err := enqueueJob(job); err != nil {
+			return err
+		}
+		events.Publish(EventJobCreated, job.Tenant, *job)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+type TenantQuota struct {
+	DailyDownloads int64 `json:"daily_downloads"`
+	DailyJobs      int64 `json:"daily_jobs"`
+	StorageMB      int64 `json:"storage_mb"`
+	UserStorageMB  int64 `json:"user_storage_mb"`
+}
+
+type Tenant struct {
+	ID             string      `json:"id"`
+	Hosts          []string    `json:"hosts"`
+	APIKeyPrefix   string      `json:"api_key_prefix"`
+	APIKeys        []string    `json:"api_keys"`
+	Template       string      `json:"template"`
+	AllowedDomains []string    `json:"allowed_domains"`
+	Quota          TenantQuota `json:"quota"`
+	StoragePrefix  string      `json:"storage_prefix"`
+}
+
+type tenantContextKey struct{}
+
+var errInvalidAPIKey = errors.New("invalid API key")
+
+var defaultTenant = &Tenant{ID: "default", Template: "templates/index.html"}
+
+var tenants []*Tenant
+
+func loadTenants(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var loaded []*Tenant
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return err
+	}
+	for _, t := range loaded {
+		if t.ID == "" {
+			return errors.New("tenant without id")
+		}
+		if t.Template == "" {
+			t.Template = defaultTenant.Template
+		}
+	}
+	tenants = loaded
+	return nil
+}
+
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+func resolveIdentity(r *http.Request) (*Tenant, *User, error) {
+	if key := requestAPIKey(r); key != "" {
+		if user, err := userByAPIKey(key); err == nil {
+			return tenantByID(user.Tenant), user, nil
+		}
+		for _, t := range tenants {
+			if t.APIKeyPrefix == "" || !strings.HasPrefix(key, t.APIKeyPrefix) {
+				continue
+			}
+			for _, k := range t.APIKeys {
+				if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+					return t, nil, nil
+				}
+			}
+			return nil, nil, errInvalidAPIKey
+		}
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	for _, t := range tenants {
+		for _, h := range t.Hosts {
+			if strings.EqualFold(h, host) {
+				return t, nil, nil
+			}
+		}
+	}
+	return defaultTenant, nil, nil
+}
+
+func withIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant, user, err := resolveIdentity(r)
+		if err != nil {
+			writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "Invalid API key")
+			return
+		}
+		reqCtx := context.WithValue(r.Context(), tenantContextKey{}, tenant)
+		reqCtx = context.WithValue(reqCtx, userContextKey{}, user)
+		next.ServeHTTP(w, r.WithContext(reqCtx))
+	})
+}
+
+func requestTenant(r *http.Request) *Tenant {
+	if t, ok := r.Context().Value(tenantContextKey{}).(*Tenant); ok {
+		return t
+	}
+	return defaultTenant
+}
+
+func tenantByID(id string) *Tenant {
+	for _, t := range tenants {
+		if t.ID == id {
+			return t
+		}
+	}
+	return defaultTenant
+}
+
+func (t *Tenant) AllowsURL(videoURL string) bool {
+	if len(t.AllowedDomains) == 0 {
+		return true
+	}
+	parsed, err := url.Parse(videoURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	for _, d := range t.AllowedDomains {
+		d = strings.ToLower(d)
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Tenant) quotaKey(kind string) string {
+	return fmt.Sprintf("quota:%s:%s:%s", t.ID, kind, time.Now().UTC().Format("20060102"))
+}
+
+func (t *Tenant) RefundQuota(kind string, limit int64) {
+	if limit > 0 {
+		rdb.Decr(ctx, t.quotaKey(kind))
+	}
+}
+
+func (t *Tenant) ConsumeQuota(kind string, limit int64) (bool, error) {
+	if limit <= 0 {
+		return true, nil
+	}
+	key := t.quotaKey(kind)
+	n, err := rdb.Incr(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+	if n == 1 {
+		rdb.Expire(ctx, key, 25*time.Hour)
+	}
+	if n > limit {
+		if n == limit+1 {
+			events.Publish(EventQuotaExceeded, t.ID, map[string]any{"kind": kind, "limit": limit})
+		}
+		return false, nil
+	}
+	return true, nil
+}
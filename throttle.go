@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var acquireSourceScript = redis.NewScript(`
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[1])
+local limit = tonumber(ARGV[2])
+if limit > 0 and redis.call("ZCARD", KEYS[1]) >= limit then
+	return -1
+end
+local last = tonumber(redis.call("GET", KEYS[2]) or "0")
+local wait = last + tonumber(ARGV[3]) - tonumber(ARGV[1])
+if wait > 0 then
+	return wait
+end
+redis.call("ZADD", KEYS[1], tonumber(ARGV[1]) + tonumber(ARGV[4]), ARGV[5])
+redis.call("PEXPIRE", KEYS[1], ARGV[4])
+if tonumber(ARGV[3]) > 0 then
+	redis.call("SET", KEYS[2], ARGV[1], "PX", ARGV[3])
+end
+return 0`)
+
+var sourceThrottled = metrics.Counter("odl_source_throttled_total", "Jobs put back because their source was at its limit.", "source")
+
+func sourceSlotsKey(source string) string {
+	return fmt.Sprintf("source:%s:slots", source)
+}
+
+func sourceLastKey(source string) string {
+	return fmt.Sprintf("source:%s:last", source)
+}
+
+func jobSource(job *Job) string {
+	if job.Source != "" {
+		return strings.ToLower(job.Source)
+	}
+	return urlSource(job.URL)
+}
+
+func sourceLimits(source string) (int64, time.Duration, bool) {
+	limit, hasLimit := cfg.SourceConcurrency[source]
+	interval, hasInterval := cfg.SourceInterval[source]
+	return limit, interval, hasLimit || hasInterval
+}
+
+func acquireSource(job *Job) (string, time.Duration, bool) {
+	source := jobSource(job)
+	limit, interval, ok := sourceLimits(source)
+	if !ok {
+		return source, 0, true
+	}
+	now := time.Now().UnixMilli()
+	wait, err := acquireSourceScript.Run(ctx, rdb,
+		[]string{sourceSlotsKey(source), sourceLastKey(source)},
+		now, limit, interval.Milliseconds(), runningJobLease.Milliseconds(), job.ID,
+	).Int64()
+	if err != nil || wait == 0 {
+		return source, 0, true
+	}
+	sourceThrottled.Inc(source)
+	if wait < 0 {
+		return source, time.Second, false
+	}
+	return source, time.Duration(wait) * time.Millisecond, false
+}
+
+func releaseSource(job *Job, source string) {
+	if _, _, ok := sourceLimits(source); ok {
+		rdb.ZRem(ctx, sourceSlotsKey(source), job.ID)
+	}
+}
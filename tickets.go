@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+var errTicketNotFound = errors.New("download ticket not found")
+
+type DownloadTicket struct {
+	URL    string `json:"url"`
+	Tenant string `json:"tenant"`
+}
+
+func ticketKey(token string) string {
+	return fmt.Sprintf("download_ticket:%s", token)
+}
+
+func issueDownloadTicket(tenant *Tenant, videoData *VideoResponse) (string, error) {
+	data, err := json.Marshal(DownloadTicket{URL: videoData.URL, Tenant: tenant.ID})
+	if err != nil {
+		return "", err
+	}
+	token := utils.RandomID(16)
+	return token, rdb.Set(ctx, ticketKey(token), data, cfg.DownloadTicketTTL).Err()
+}
+
+func lookupDownloadTicket(token string) (*DownloadTicket, error) {
+	if token == "" {
+		return nil, errTicketNotFound
+	}
+	data, err := rdb.Get(ctx, ticketKey(token)).Result()
+	if err == redis.Nil {
+		return nil, errTicketNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ticket DownloadTicket
+	if err := json.Unmarshal([]byte(data), &ticket); err != nil {
+		return nil, err
+	}
+	return &ticket, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+)
+
+func torrentPieceLength(size int64) int64 {
+	pieceLength := int64(256 * 1024)
+	for pieceLength < 16*1024*1024 && size/pieceLength > 2000 {
+		pieceLength *= 2
+	}
+	return pieceLength
+}
+
+func artifactSeedKey(id string) string {
+	return fmt.Sprintf("artifact_seed:%s", id)
+}
+
+func artifactSeedToken(artifact *Artifact) string {
+	token := utils.RandomID(24)
+	if ok, err := rdb.SetNX(ctx, artifactSeedKey(artifact.ID), token, 0).Result(); err == nil && !ok {
+		token, _ = rdb.Get(ctx, artifactSeedKey(artifact.ID)).Result()
+	}
+	return token
+}
+
+func validSeedToken(artifact *Artifact, token string) bool {
+	if !cfg.TorrentEnabled || token == "" {
+		return false
+	}
+	stored, err := rdb.Get(ctx, artifactSeedKey(artifact.ID)).Result()
+	return err == nil && subtle.ConstantTimeCompare([]byte(stored), []byte(token)) == 1
+}
+
+func (s *ContentStore) torrentPath(artifact *Artifact) string {
+	return artifact.Path + ".torrent"
+}
+
+func (s *ContentStore) Torrent(artifact *Artifact) ([]byte, error) {
+	if artifact.DeletedAt != nil {
+		return nil, errArtifactDeleted
+	}
+	if data, err := os.ReadFile(s.torrentPath(artifact)); err == nil {
+		return data, nil
+	}
+
+	f, err := s.Open(artifact)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pieceLength := torrentPieceLength(artifact.Size)
+	var pieces []byte
+	buf := make([]byte, pieceLength)
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			sum := sha1.Sum(buf[:n])
+			pieces = append(pieces, sum[:]...)
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	torrent := map[string]any{
+		"info": map[string]any{
+			"name":         artifact.FileName,
+			"length":       artifact.Size,
+			"piece length": pieceLength,
+			"pieces":       pieces,
+		},
+		"url-list":      []string{artifactDownloadURL(artifact.ID) + "?seed=" + artifactSeedToken(artifact)},
+		"creation date": time.Now().Unix(),
+		"created by":    "OneTimeDownload",
+	}
+	if len(cfg.TorrentTrackers) > 0 {
+		torrent["announce"] = cfg.TorrentTrackers[0]
+		tiers := make([]any, 0, len(cfg.TorrentTrackers))
+		for _, tracker := range cfg.TorrentTrackers {
+			tiers = append(tiers, []string{tracker})
+		}
+		torrent["announce-list"] = tiers
+	}
+
+	data, err := utils.Bencode(torrent)
+	if err != nil {
+		return nil, err
+	}
+	_ = os.WriteFile(s.torrentPath(artifact), data, 0o644)
+	return data, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	transfersServed = metrics.Counter("odl_transfers_total", "Downloads sent to clients.", "route")
+	transferBytes   = metrics.Counter("odl_transfer_bytes_total", "Bytes sent to clients by downloads.", "route")
+	transferSeconds = metrics.Counter("odl_transfer_seconds_total", "Time spent sending downloads to clients.", "route")
+	transfersCut    = metrics.Counter("odl_incomplete_transfers_total", "Downloads that ended before the whole file was sent.", "route")
+)
+
+const headerTransferComplete = "X-Transfer-Complete"
+
+type Transfer struct {
+	Bytes      int64
+	Duration   time.Duration
+	Incomplete bool
+}
+
+type JobTransfer struct {
+	At         time.Time `json:"at"`
+	Bytes      int64     `json:"bytes"`
+	Incomplete bool      `json:"incomplete,omitempty"`
+
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+	BytesPerSecond  float64 `json:"bytes_per_second,omitempty"`
+}
+
+func (t Transfer) Speed() float64 {
+	if t.Duration <= 0 {
+		return 0
+	}
+	return float64(t.Bytes) / t.Duration.Seconds()
+}
+
+type countingResponseWriter struct {
+	http.ResponseWriter
+	n       int64
+	started time.Time
+}
+
+func newCountingResponseWriter(w http.ResponseWriter) *countingResponseWriter {
+	return &countingResponseWriter{ResponseWriter: w, started: time.Now()}
+}
+
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *countingResponseWriter) ReadFrom(src io.Reader) (int64, error) {
+	var n int64
+	var err error
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(src)
+	} else {
+		n, err = copyBuffered(w.ResponseWriter, src)
+	}
+	w.n += n
+	return n, err
+}
+
+func (w *countingResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *countingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *countingResponseWriter) transfer() Transfer {
+	return Transfer{Bytes: w.n, Duration: time.Since(w.started)}
+}
+
+func (w *countingResponseWriter) short() bool {
+	size, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64)
+	return err == nil && w.n < size
+}
+
+func recordJobTransfer(jobID string, t Transfer) {
+	if jobID == "" || t.Bytes == 0 {
+		return
+	}
+	job, err := getJob(jobID)
+	if err != nil {
+		return
+	}
+	job.LastDownload = &JobTransfer{
+		At:              time.Now().UTC(),
+		Bytes:           t.Bytes,
+		Incomplete:      t.Incomplete,
+		DurationSeconds: t.Duration.Seconds(),
+		BytesPerSecond:  t.Speed(),
+	}
+	saveJob(job)
+}
+
+func recordTransfer(route string, t Transfer) {
+	if t.Bytes == 0 {
+		return
+	}
+	transfersServed.Inc(route)
+	transferBytes.Add(float64(t.Bytes), route)
+	transferSeconds.Add(t.Duration.Seconds(), route)
+	if t.Incomplete {
+		transfersCut.Inc(route)
+	}
+
+	day := statsDayKey(time.Now())
+	rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(ctx, day, "transfers", 1)
+		pipe.HIncrBy(ctx, day, "transfer_bytes", t.Bytes)
+		pipe.HIncrBy(ctx, day, "transfer_ms", t.Duration.Milliseconds())
+		if t.Incomplete {
+			pipe.HIncrBy(ctx, day, "transfers_incomplete", 1)
+		}
+		pipe.Expire(ctx, day, statsDayTTL)
+		return nil
+	})
+}
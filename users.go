@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+)
+
+var (
+	errUserNotFound = errors.New("user not found")
+	errUserExists   = errors.New("user already exists")
+)
+
+type User struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Tenant    string    `json:"tenant"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type userContextKey struct{}
+
+func apiKeyHash(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+func createUser(name string, tenant *Tenant) (*User, string, error) {
+	user := &User{
+		ID:        utils.RandomID(8),
+		Name:      name,
+		Tenant:    tenant.ID,
+		CreatedAt: time.Now().UTC(),
+	}
+	apiKey := tenant.APIKeyPrefix + utils.RandomID(24)
+	if err := jobstore.CreateUser(user, apiKeyHash(apiKey)); err != nil {
+		return nil, "", err
+	}
+	return user, apiKey, nil
+}
+
+func getUser(id string) (*User, error) {
+	return jobstore.GetUser(id)
+}
+
+func getUserByName(name string) (*User, error) {
+	return jobstore.GetUserByName(name)
+}
+
+func userByAPIKey(apiKey string) (*User, error) {
+	return jobstore.GetUserByAPIKey(apiKeyHash(apiKey))
+}
+
+func listUsers() ([]*User, error) {
+	return jobstore.ListUsers()
+}
+
+func requestUser(r *http.Request) *User {
+	user, _ := r.Context().Value(userContextKey{}).(*User)
+	return user
+}
+
+func (u *User) ownerID() string {
+	if u == nil {
+		return ""
+	}
+	return u.ID
+}
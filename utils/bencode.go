@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+func Bencode(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := bencodeValue(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func bencodeValue(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case string:
+		buf.WriteString(strconv.Itoa(len(v)) + ":" + v)
+	case []byte:
+		buf.WriteString(strconv.Itoa(len(v)) + ":")
+		buf.Write(v)
+	case int:
+		buf.WriteString("i" + strconv.Itoa(v) + "e")
+	case int64:
+		buf.WriteString("i" + strconv.FormatInt(v, 10) + "e")
+	case []string:
+		buf.WriteByte('l')
+		for _, item := range v {
+			bencodeValue(buf, item)
+		}
+		buf.WriteByte('e')
+	case []any:
+		buf.WriteByte('l')
+		for _, item := range v {
+			if err := bencodeValue(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('d')
+		for _, k := range keys {
+			bencodeValue(buf, k)
+			if err := bencodeValue(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	default:
+		return fmt.Errorf("bencode: unsupported type %T", v)
+	}
+	return nil
+}
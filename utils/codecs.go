@@ -0,0 +1,44 @@
+package utils
+
+import "strings"
+
+var codecPrefixes = []struct {
+	prefix string
+	name   string
+}{
+	{"avc", "h264"},
+	{"h264", "h264"},
+	{"hev", "h265"},
+	{"hvc", "h265"},
+	{"h265", "h265"},
+	{"vp09", "vp9"},
+	{"vp9", "vp9"},
+	{"vp08", "vp8"},
+	{"vp8", "vp8"},
+	{"av01", "av1"},
+	{"av1", "av1"},
+	{"mp4a", "aac"},
+	{"aac", "aac"},
+	{"opus", "opus"},
+	{"vorbis", "vorbis"},
+	{"mp3", "mp3"},
+	{"flac", "flac"},
+	{"ac-3", "ac3"},
+	{"ec-3", "eac3"},
+}
+
+func NormalizeCodec(codec string) string {
+	codec = strings.ToLower(strings.TrimSpace(codec))
+	if codec == "" || codec == "none" {
+		return ""
+	}
+	for _, c := range codecPrefixes {
+		if strings.HasPrefix(codec, c.prefix) {
+			return c.name
+		}
+	}
+	if i := strings.IndexByte(codec, '.'); i > 0 {
+		return codec[:i]
+	}
+	return codec
+}
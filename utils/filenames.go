@@ -0,0 +1,19 @@
+package utils
+
+import "strings"
+
+var fileNameReplacer = strings.NewReplacer(
+	"/", "-", "\\", "-", ":", "-", "*", "", "?", "", `"`, "'", "<", "", ">", "", "|", "-",
+)
+
+func SanitizeFileName(name string) string {
+	name = strings.TrimSpace(fileNameReplacer.Replace(name))
+	name = strings.Trim(name, ".")
+	if len(name) > 150 {
+		name = name[:150]
+	}
+	if name == "" {
+		return "untitled"
+	}
+	return name
+}
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+var videoMediaTypes = map[string]string{
+	"mp4":  "video/mp4",
+	"m4v":  "video/mp4",
+	"webm": "video/webm",
+	"mkv":  "video/x-matroska",
+	"mov":  "video/quicktime",
+	"flv":  "video/x-flv",
+	"3gp":  "video/3gpp",
+	"ts":   "video/mp2t",
+	"avi":  "video/x-msvideo",
+}
+
+var audioMediaTypes = map[string]string{
+	"m4a":  "audio/mp4",
+	"mp4":  "audio/mp4",
+	"mp3":  "audio/mpeg",
+	"webm": "audio/webm",
+	"weba": "audio/webm",
+	"ogg":  "audio/ogg",
+	"opus": "audio/ogg",
+	"aac":  "audio/aac",
+	"flac": "audio/flac",
+	"wav":  "audio/wav",
+}
+
+func MediaType(ext string, audioOnly bool) string {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	if audioOnly {
+		if t, ok := audioMediaTypes[ext]; ok {
+			return t
+		}
+	}
+	if t, ok := videoMediaTypes[ext]; ok {
+		return t
+	}
+	if t, ok := audioMediaTypes[ext]; ok {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+func IsMediaExt(ext string) bool {
+	ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+	_, video := videoMediaTypes[ext]
+	_, audio := audioMediaTypes[ext]
+	return video || audio
+}
+
+func FileNameWithExt(name, ext string) string {
+	if IsMediaExt(filepath.Ext(name)) {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return name + "." + strings.TrimPrefix(ext, ".")
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jimmymuthoni/onetimedownload/utils"
+	"golang.org/x/net/publicsuffix"
+)
+
+const (
+	validatorStatic     = "static"
+	validatorExtractors = "extractors"
+	validatorProbe      = "probe"
+
+	probeTimeout       = 20 * time.Second
+	maxConcurrentProbe = 4
+)
+
+var (
+	errProbeLimit = errors.New("too many URL probes, try again in a minute")
+	probeSlots    = make(chan struct{}, maxConcurrentProbe)
+)
+
+type URLValidator interface {
+	Supports(u *url.URL) (bool, error)
+}
+
+var validator URLValidator = staticValidator{}
+
+var ytdlpExtractors = &extractorListValidator{}
+
+func newURLValidator(kind string) (URLValidator, error) {
+	switch kind {
+	case "", validatorStatic:
+		return staticValidator{}, nil
+	case validatorExtractors:
+		return cachedValidator{next: ytdlpExtractors, key: hostSupportKey}, nil
+	case validatorProbe:
+		return cachedValidator{next: probeValidator{}, key: urlProbeKey}, nil
+	default:
+		return nil, fmt.Errorf("unknown URL validator %q", kind)
+	}
+}
+
+func validateVideoURL(videoURL string) bool {
+	if len(videoURL) > maxURLLength || !flagEnabled(extractorFlag(videoURL)) {
+		return false
+	}
+	if utils.ValidateURL(videoURL) {
+		return true
+	}
+	parsed, err := url.ParseRequestURI(videoURL)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return false
+	}
+	ok, err := validator.Supports(parsed)
+	if err != nil {
+		log.Printf("validator: %s: %v", parsed.Host, err)
+	}
+	return ok
+}
+
+type staticValidator struct{}
+
+func (staticValidator) Supports(u *url.URL) (bool, error) {
+	return utils.AllowedHosts[u.Host], nil
+}
+
+type cachedValidator struct {
+	next URLValidator
+	key  func(u *url.URL) string
+}
+
+func hostSupportKey(u *url.URL) string {
+	return fmt.Sprintf("url_support:%s", strings.ToLower(u.Hostname()))
+}
+
+func urlProbeKey(u *url.URL) string {
+	sum := sha256.Sum256([]byte(u.String()))
+	return fmt.Sprintf("url_support:probe:%s", hex.EncodeToString(sum[:16]))
+}
+
+func (v cachedValidator) Supports(u *url.URL) (bool, error) {
+	key := v.key(u)
+	if cached, err := rdb.Get(ctx, key).Result(); err == nil {
+		return cached == "1", nil
+	}
+	ok, err := v.next.Supports(u)
+	if err != nil {
+		return false, err
+	}
+	value := "0"
+	if ok {
+		value = "1"
+	}
+	rdb.Set(ctx, key, value, cfg.URLValidatorCacheTTL)
+	return ok, nil
+}
+
+type extractorListValidator struct {
+	once  sync.Once
+	names map[string]bool
+	err   error
+}
+
+func (v *extractorListValidator) load() {
+	output, err := command("yt-dlp", "--list-extractors").Output()
+	if err != nil {
+		v.err = fmt.Errorf("listing extractors: %w", err)
+		return
+	}
+	v.names = make(map[string]bool)
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.ToLower(fields[0]), ":")
+		if name != "generic" {
+			v.names[name] = true
+		}
+	}
+}
+
+func (v *extractorListValidator) Names() ([]string, error) {
+	v.once.Do(v.load)
+	if v.err != nil {
+		return nil, v.err
+	}
+	names := make([]string, 0, len(v.names))
+	for name := range v.names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (v *extractorListValidator) Supports(u *url.URL) (bool, error) {
+	v.once.Do(v.load)
+	if v.err != nil {
+		return false, v.err
+	}
+	label := siteLabel(u)
+	return label != "" && v.names[label], nil
+}
+
+func siteLabel(u *url.URL) string {
+	domain, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(u.Hostname()))
+	if err != nil {
+		return ""
+	}
+	label, _, _ := strings.Cut(domain, ".")
+	return label
+}
+
+type probeValidator struct{}
+
+func (probeValidator) Supports(u *url.URL) (bool, error) {
+	if err := publicHostCheck(u.Hostname()); err != nil {
+		return false, nil
+	}
+	if cfg.URLValidatorProbeRate > 0 {
+		key := fmt.Sprintf("url_probes:%d", time.Now().Unix()/60)
+		n, err := rdb.Incr(ctx, key).Result()
+		if err != nil {
+			return false, err
+		}
+		rdb.Expire(ctx, key, 2*time.Minute)
+		if n > cfg.URLValidatorProbeRate {
+			return false, errProbeLimit
+		}
+	}
+	probeSlots <- struct{}{}
+	defer func() { <-probeSlots }()
+
+	c, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := runner.CommandContext(c, "yt-dlp", ytdlpArgs(pickProxy(siteLabel(u)), "--simulate", "--quiet", "--no-warnings", "--no-playlist", u.String())...)
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err == nil {
+		return true, nil
+	}
+	if strings.Contains(stderr.String(), "Unsupported URL") {
+		return false, nil
+	}
+	return false, fmt.Errorf("probe: %v: %s", err, strings.TrimSpace(stderr.String()))
+}
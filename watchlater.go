@@ -0,0 +1,332 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	watchLaterAutoKey  = "watch_later:auto"
+	maxWatchLaterItems = 1000
+	watchLaterLockTTL  = time.Minute
+)
+
+var watchLaterQueued = metrics.Counter("odl_watch_later_jobs_total", "Jobs queued from watch-later lists.")
+
+type WatchLaterItem struct {
+	ID      string    `json:"id"`
+	URL     string    `json:"url"`
+	Title   string    `json:"title,omitempty"`
+	AddedAt time.Time `json:"added_at"`
+	JobID   string    `json:"job_id,omitempty"`
+	Status  JobStatus `json:"status,omitempty"`
+}
+
+type WatchLaterSettings struct {
+	AutoDownload bool   `json:"auto_download"`
+	FormatID     string `json:"format,omitempty"`
+}
+
+func watchLaterKey(userID string) string {
+	return fmt.Sprintf("user:%s:watch_later", userID)
+}
+
+func watchLaterSettingsKey(userID string) string {
+	return fmt.Sprintf("user:%s:watch_later:settings", userID)
+}
+
+func watchLaterItemID(videoURL string) string {
+	sum := sha256.Sum256([]byte(videoURL))
+	return hex.EncodeToString(sum[:8])
+}
+
+func getWatchLaterItem(userID, id string) (*WatchLaterItem, error) {
+	data, err := rdb.HGet(ctx, watchLaterKey(userID), id).Result()
+	if err != nil {
+		return nil, err
+	}
+	var item WatchLaterItem
+	if err := json.Unmarshal([]byte(data), &item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func listWatchLater(userID string) ([]*WatchLaterItem, error) {
+	fields, err := rdb.HGetAll(ctx, watchLaterKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*WatchLaterItem, 0, len(fields))
+	for _, data := range fields {
+		var item WatchLaterItem
+		if json.Unmarshal([]byte(data), &item) == nil {
+			items = append(items, &item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].AddedAt.After(items[j].AddedAt) })
+	return items, nil
+}
+
+func saveWatchLaterItem(userID string, item *WatchLaterItem) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return rdb.HSet(ctx, watchLaterKey(userID), item.ID, data).Err()
+}
+
+func pruneWatchLater(userID string, items []*WatchLaterItem) []*WatchLaterItem {
+	kept := items[:0]
+	for _, item := range items {
+		if item.JobID != "" {
+			job, err := getJob(item.JobID)
+			if errors.Is(err, errJobNotFound) || (err == nil && (job.Status == JobCompleted || job.Status == JobFailed)) {
+				rdb.HDel(ctx, watchLaterKey(userID), item.ID)
+				continue
+			}
+			if err == nil {
+				item.Status = job.Status
+			}
+		}
+		kept = append(kept, item)
+	}
+	return kept
+}
+
+func getWatchLaterSettings(userID string) WatchLaterSettings {
+	var settings WatchLaterSettings
+	if data, err := rdb.Get(ctx, watchLaterSettingsKey(userID)).Bytes(); err == nil {
+		json.Unmarshal(data, &settings)
+	}
+	return settings
+}
+
+func saveWatchLaterSettings(userID string, settings WatchLaterSettings) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	_, err = rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, watchLaterSettingsKey(userID), data, 0)
+		if settings.AutoDownload {
+			pipe.SAdd(ctx, watchLaterAutoKey, userID)
+		} else {
+			pipe.SRem(ctx, watchLaterAutoKey, userID)
+		}
+		return nil
+	})
+	return err
+}
+
+func queueWatchLater(user *User) (int, error) {
+	settings := getWatchLaterSettings(user.ID)
+	if !settings.AutoDownload {
+		return 0, nil
+	}
+	lock, err := acquireLock("watch_later:"+user.ID, watchLaterLockTTL)
+	if errors.Is(err, errLockHeld) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer lock.Release()
+
+	items, err := listWatchLater(user.ID)
+	if err != nil {
+		return 0, err
+	}
+	items = pruneWatchLater(user.ID, items)
+	tenant := tenantByID(user.Tenant)
+	queued := 0
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		if item.JobID != "" {
+			continue
+		}
+		req := jobRequest{URL: item.URL, FormatID: settings.FormatID, title: item.Title, lane: laneLow, skipMetadata: true}
+		if err := req.validate(tenant); err != nil {
+			continue
+		}
+		job, err := createJob(req, tenant, user, "")
+		var cooldownErr *CooldownError
+		if errors.As(err, &cooldownErr) {
+			continue
+		}
+		if err != nil {
+			return queued, err
+		}
+		item.JobID = job.ID
+		if err := saveWatchLaterItem(user.ID, item); err != nil {
+			return queued, err
+		}
+		watchLaterQueued.Inc()
+		queued++
+	}
+	return queued, nil
+}
+
+func runWatchLater() {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, on := activeMaintenance(); on || !flagEnabled(flagWatchLater) {
+			continue
+		}
+		ids, err := rdb.SMembers(ctx, watchLaterAutoKey).Result()
+		if err != nil {
+			log.Printf("watch later: %v", err)
+			continue
+		}
+		for _, id := range ids {
+			user, err := getUser(id)
+			if errors.Is(err, errUserNotFound) {
+				rdb.SRem(ctx, watchLaterAutoKey, id)
+				continue
+			}
+			if err != nil {
+				continue
+			}
+			var quotaErr *StorageQuotaError
+			if _, err := queueWatchLater(user); err != nil && !errors.Is(err, errQuotaExceeded) && !errors.As(err, &quotaErr) {
+				log.Printf("watch later: user %s: %v", id, err)
+			}
+		}
+	}
+}
+
+func handleListWatchLater(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	if user == nil {
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "API key required")
+		return
+	}
+	items, err := listWatchLater(user.ID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error loading watch-later list")
+		return
+	}
+	settings := getWatchLaterSettings(user.ID)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"auto_download": settings.AutoDownload,
+		"format":        settings.FormatID,
+		"items":         pruneWatchLater(user.ID, items),
+	})
+}
+
+func handleAddWatchLater(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	if user == nil {
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "API key required")
+		return
+	}
+	var req struct {
+		URL   string `json:"url"`
+		Title string `json:"title"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if !isAllowedVideoURL(r, req.URL) {
+		writeError(w, r, http.StatusBadRequest, codeUnsupportedURL, "Invalid or unsupported video URL")
+		return
+	}
+
+	id := watchLaterItemID(req.URL)
+	if item, err := getWatchLaterItem(user.ID, id); err == nil {
+		writeJSON(w, http.StatusOK, item)
+		return
+	}
+	if items, err := listWatchLater(user.ID); err == nil && len(pruneWatchLater(user.ID, items)) >= maxWatchLaterItems {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, fmt.Sprintf("The watch-later list is full, it holds at most %d videos", maxWatchLaterItems))
+		return
+	}
+	item := &WatchLaterItem{ID: id, URL: req.URL, Title: req.Title, AddedAt: time.Now().UTC()}
+	if item.Title == "" {
+		if videoData, err := fetchVideoMetaData(req.URL); err == nil {
+			item.Title = videoData.Title
+		}
+	}
+	if err := saveWatchLaterItem(user.ID, item); err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error saving watch-later list")
+		return
+	}
+	if _, err := queueWatchLater(user); err == nil {
+		if queued, err := getWatchLaterItem(user.ID, id); err == nil {
+			item = queued
+		}
+	}
+	writeJSON(w, http.StatusCreated, item)
+}
+
+func handleDeleteWatchLater(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	if user == nil {
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "API key required")
+		return
+	}
+	n, err := rdb.HDel(ctx, watchLaterKey(user.ID), r.PathValue("id")).Result()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error saving watch-later list")
+		return
+	}
+	if n == 0 {
+		writeError(w, r, http.StatusNotFound, codeNotFound, "Watch-later item not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleWatchLaterSettings(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	if user == nil {
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "API key required")
+		return
+	}
+	var req struct {
+		AutoDownload *bool   `json:"auto_download"`
+		FormatID     *string `json:"format"`
+	}
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	settings := getWatchLaterSettings(user.ID)
+	if req.FormatID != nil {
+		if *req.FormatID != "" && !isValidFormatID(*req.FormatID) {
+			writeError(w, r, http.StatusBadRequest, codeInvalidFormat, "Invalid format")
+			return
+		}
+		settings.FormatID = *req.FormatID
+	}
+	if req.AutoDownload != nil {
+		settings.AutoDownload = *req.AutoDownload
+	}
+	if err := saveWatchLaterSettings(user.ID, settings); err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error saving watch-later settings")
+		return
+	}
+
+	resp := map[string]any{"auto_download": settings.AutoDownload, "format": settings.FormatID}
+	queued, err := queueWatchLater(user)
+	var quotaErr *StorageQuotaError
+	switch {
+	case errors.Is(err, errQuotaExceeded):
+		resp["error"] = "Daily job quota exceeded, the rest of the list is queued later"
+	case errors.As(err, &quotaErr):
+		resp["error"] = "Storage quota exceeded, the rest of the list is queued once space is freed"
+	case err != nil:
+		log.Printf("watch later: user %s: %v", user.ID, err)
+	}
+	resp["queued"] = queued
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"io"
+	"net/http"
+	"os"
+
+	"golang.org/x/net/webdav"
+)
+
+type readOnlyFS struct {
+	webdav.FileSystem
+}
+
+func (readOnlyFS) Mkdir(context.Context, string, os.FileMode) error {
+	return os.ErrPermission
+}
+
+func (fs readOnlyFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+	f, err := fs.FileSystem.OpenFile(ctx, name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	osFile, ok := f.(*os.File)
+	if !ok {
+		return f, nil
+	}
+	if info, err := osFile.Stat(); err != nil || info.IsDir() {
+		return f, nil
+	}
+	ef, encrypted, err := readEncryptionHeader(osFile, "")
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if encrypted {
+		return ef, nil
+	}
+	if _, err := osFile.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (fs readOnlyFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	info, err := fs.FileSystem.Stat(ctx, name)
+	if err != nil || !info.Mode().IsRegular() {
+		return info, err
+	}
+	f, err := fs.OpenFile(ctx, name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+func (readOnlyFS) RemoveAll(context.Context, string) error {
+	return os.ErrPermission
+}
+
+func (readOnlyFS) Rename(context.Context, string, string) error {
+	return os.ErrPermission
+}
+
+var webdavLocks = webdav.NewMemLS()
+
+func handleWebDAV(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodOptions, http.MethodGet, http.MethodHead, "PROPFIND":
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, codeMethodNotAllowed, "WebDAV access is read-only")
+		return
+	}
+
+	name, apiKey, ok := r.BasicAuth()
+	user, err := userByAPIKey(apiKey)
+	if !ok || err != nil || subtle.ConstantTimeCompare([]byte(user.Name), []byte(name)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="OneTimeDownload"`)
+		writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
+		return
+	}
+
+	root := store.UserRoot(tenantByID(user.Tenant).StoragePrefix, user.ID)
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Error opening archive")
+		return
+	}
+
+	handler := &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: readOnlyFS{webdav.Dir(root)},
+		LockSystem: webdavLocks,
+	}
+	handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var skippedFormats = metrics.Counter("odl_metadata_formats_skipped_total", "Formats left out of yt-dlp metadata because they could not be parsed.")
+
+func parseFlexNumber(raw json.RawMessage) (float64, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return 0, nil
+	}
+	if raw[0] == '"' {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return 0, err
+		}
+		s = strings.TrimSpace(s)
+		if s == "" || strings.EqualFold(s, "none") || strings.EqualFold(s, "NA") {
+			return 0, nil
+		}
+		raw = json.RawMessage(s)
+	}
+	v, err := strconv.ParseFloat(string(raw), 64)
+	if err != nil {
+		return 0, fmt.Errorf("not a number: %s", raw)
+	}
+	return v, nil
+}
+
+func flexFloat(dst *float64, raw json.RawMessage) error {
+	v, err := parseFlexNumber(raw)
+	if err == nil {
+		*dst = v
+	}
+	return err
+}
+
+func flexInt(dst *int, raw json.RawMessage) error {
+	v, err := parseFlexNumber(raw)
+	if err == nil {
+		*dst = int(v)
+	}
+	return err
+}
+
+func flexInt64(dst *int64, raw json.RawMessage) error {
+	v, err := parseFlexNumber(raw)
+	if err == nil {
+		*dst = int64(v)
+	}
+	return err
+}
+
+func (f *YTDLPFormat) UnmarshalJSON(data []byte) error {
+	type plain YTDLPFormat
+	var raw struct {
+		plain
+		Width          json.RawMessage `json:"width"`
+		Height         json.RawMessage `json:"height"`
+		FPS            json.RawMessage `json:"fps"`
+		TBR            json.RawMessage `json:"tbr"`
+		Filesize       json.RawMessage `json:"filesize"`
+		FilesizeApprox json.RawMessage `json:"filesize_approx"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*f = YTDLPFormat(raw.plain)
+	for _, err := range []error{
+		flexInt(&f.Width, raw.Width),
+		flexInt(&f.Height, raw.Height),
+		flexFloat(&f.FPS, raw.FPS),
+		flexFloat(&f.TBR, raw.TBR),
+		flexInt64(&f.Filesize, raw.Filesize),
+		flexInt64(&f.FilesizeApprox, raw.FilesizeApprox),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *YTDLPOutput) UnmarshalJSON(data []byte) error {
+	type plain YTDLPOutput
+	var raw struct {
+		plain
+		Duration  json.RawMessage   `json:"duration"`
+		ViewCount json.RawMessage   `json:"view_count"`
+		LikeCount json.RawMessage   `json:"like_count"`
+		ReleaseTS json.RawMessage   `json:"release_timestamp"`
+		Formats   []json.RawMessage `json:"formats"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*o = YTDLPOutput(raw.plain)
+	flexFloat(&o.Duration, raw.Duration)
+	flexInt64(&o.ViewCount, raw.ViewCount)
+	flexInt64(&o.LikeCount, raw.LikeCount)
+	flexInt64(&o.ReleaseTS, raw.ReleaseTS)
+
+	o.Formats = make([]YTDLPFormat, 0, len(raw.Formats))
+	for i, rawFormat := range raw.Formats {
+		var f YTDLPFormat
+		if err := json.Unmarshal(rawFormat, &f); err != nil {
+			skippedFormats.Inc()
+			debugf("metadata: %s: skipping format %d: %v", o.ID, i, err)
+			continue
+		}
+		o.Formats = append(o.Formats, f)
+	}
+	return nil
+}
+
+var errNoMetadata = errors.New("yt-dlp printed no metadata")
+
+func videoObject(obj json.RawMessage) (json.RawMessage, bool) {
+	var probe struct {
+		Type    string            `json:"_type"`
+		Formats json.RawMessage   `json:"formats"`
+		Entries []json.RawMessage `json:"entries"`
+	}
+	if json.Unmarshal(obj, &probe) != nil {
+		return nil, false
+	}
+	if probe.Type != "playlist" && probe.Type != "multi_video" {
+		return obj, probe.Type == "video" || len(probe.Formats) > 0
+	}
+	for _, entry := range probe.Entries {
+		if v, ok := videoObject(entry); ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func anyObject(obj json.RawMessage) (json.RawMessage, bool) {
+	return obj, true
+}
+
+func readYTDLPJSON(r io.Reader, accept func(json.RawMessage) (json.RawMessage, bool)) (json.RawMessage, error) {
+	br := bufio.NewReaderSize(r, 64<<10)
+	for {
+		line, err := br.ReadBytes('\n')
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			if trimmed[0] == '{' && json.Valid(trimmed) {
+				if obj, ok := accept(trimmed); ok {
+					return obj, nil
+				}
+			} else {
+				debugf("metadata: yt-dlp stdout: %s", redactSecrets(string(trimmed)))
+			}
+		}
+		if err == io.EOF {
+			return nil, errNoMetadata
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func runYTDLPJSON(args []string, accept func(json.RawMessage) (json.RawMessage, bool)) ([]byte, string, error) {
+	if cfg.MetadataNoWarnings && !slices.Contains(args, "--no-warnings") {
+		args = append([]string{"--no-warnings"}, args...)
+	}
+	cmd := command("yt-dlp", args...)
+	stderr := &tailBuffer{}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, "", err
+	}
+	obj, readErr := readYTDLPJSON(stdout, accept)
+	if readErr == nil {
+		cmd.Process.Kill()
+	}
+	waitErr := cmd.Wait()
+	switch {
+	case readErr == nil:
+		return obj, stderr.String(), nil
+	case waitErr != nil:
+		return nil, stderr.String(), waitErr
+	default:
+		return nil, stderr.String(), readErr
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+var errWorkerUnavailable = errors.New("yt-dlp worker unavailable")
+
+var (
+	workerRequests  = metrics.Counter("odl_ytdlp_worker_requests_total", "Metadata lookups answered by a persistent yt-dlp worker.", "result")
+	workerFallbacks = metrics.Counter("odl_ytdlp_worker_fallbacks_total", "Metadata lookups that fell back to spawning yt-dlp because no worker could answer.")
+)
+
+type workerRequest struct {
+	URL  string   `json:"url"`
+	Args []string `json:"args"`
+}
+
+type workerResponse struct {
+	Info   json.RawMessage `json:"info"`
+	Error  string          `json:"error"`
+	Stderr string          `json:"stderr"`
+}
+
+type ytdlpWorker struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	served int
+}
+
+type ytdlpWorkerPool struct {
+	slots chan *ytdlpWorker
+}
+
+var ytdlpWorkers *ytdlpWorkerPool
+
+func newYTDLPWorkerPool(n int) *ytdlpWorkerPool {
+	p := &ytdlpWorkerPool{slots: make(chan *ytdlpWorker, n)}
+	for i := 0; i < n; i++ {
+		p.slots <- nil
+	}
+	return p
+}
+
+func startYTDLPWorker() (*ytdlpWorker, error) {
+	cmd := command(cfg.YTDLPWorkerPython, cfg.YTDLPWorkerScript)
+	cmd.Env = os.Environ()
+	if bin, err := exec.LookPath("yt-dlp"); err == nil {
+		pythonPath := bin
+		if existing := os.Getenv("PYTHONPATH"); existing != "" {
+			pythonPath = existing + string(os.PathListSeparator) + bin
+		}
+		cmd.Env = append(cmd.Env, "PYTHONPATH="+pythonPath)
+	}
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &ytdlpWorker{cmd: cmd, stdin: stdin, stdout: bufio.NewReaderSize(stdout, 1<<20)}, nil
+}
+
+func (w *ytdlpWorker) stop() {
+	w.stdin.Close()
+	w.cmd.Process.Kill()
+	w.cmd.Wait()
+}
+
+func (w *ytdlpWorker) roundTrip(req workerRequest) (*workerResponse, error) {
+	data, _ := json.Marshal(req)
+	done := make(chan struct{})
+	timer := time.AfterFunc(cfg.YTDLPWorkerTimeout, func() {
+		w.cmd.Process.Kill()
+		close(done)
+	})
+	defer timer.Stop()
+
+	if _, err := w.stdin.Write(append(data, '\n')); err != nil {
+		return nil, err
+	}
+	line, err := w.stdout.ReadBytes('\n')
+	if err != nil {
+		select {
+		case <-done:
+			return nil, fmt.Errorf("no answer within %s", cfg.YTDLPWorkerTimeout)
+		default:
+			return nil, err
+		}
+	}
+	var resp workerResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, err
+	}
+	w.served++
+	return &resp, nil
+}
+
+func (p *ytdlpWorkerPool) extract(args []string, videoURL string) ([]byte, string, error) {
+	w := <-p.slots
+	if w == nil {
+		var err error
+		if w, err = startYTDLPWorker(); err != nil {
+			p.slots <- nil
+			return nil, "", fmt.Errorf("%w: %v", errWorkerUnavailable, err)
+		}
+	}
+	resp, err := w.roundTrip(workerRequest{URL: videoURL, Args: args})
+	if err != nil || w.served >= cfg.YTDLPWorkerMaxRequests {
+		w.stop()
+		w = nil
+	}
+	p.slots <- w
+	if err != nil {
+		log.Printf("yt-dlp worker: %v", err)
+		return nil, "", fmt.Errorf("%w: %v", errWorkerUnavailable, err)
+	}
+	if resp.Error != "" {
+		workerRequests.Inc("error")
+		stderr := resp.Stderr
+		if stderr == "" {
+			stderr = resp.Error
+		}
+		return nil, stderr, errors.New(resp.Error)
+	}
+	workerRequests.Inc("ok")
+	return resp.Info, "", nil
+}
+
+func extractMetadata(args []string, videoURL string) ([]byte, string, error) {
+	if ytdlpWorkers != nil {
+		output, stderr, err := ytdlpWorkers.extract(args, videoURL)
+		if err == nil {
+			if v, ok := videoObject(output); ok {
+				output = v
+			}
+		}
+		if !errors.Is(err, errWorkerUnavailable) {
+			return output, stderr, err
+		}
+		workerFallbacks.Inc()
+	}
+	args = append(slices.Clone(args), "-j", videoURL)
+	debugf("metadata: yt-dlp %s", redactSecrets(strings.Join(args, " ")))
+	return runYTDLPJSON(args, videoObject)
+}